@@ -1,32 +1,42 @@
 package main
 
 import (
-	"log"
-	"os"
-
 	"context"
 	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/failures"
 	"github.com/smallwat3r/untappd-recorder/internal/photo"
 	"github.com/smallwat3r/untappd-recorder/internal/processor"
 	"github.com/smallwat3r/untappd-recorder/internal/storage"
 	"github.com/smallwat3r/untappd-recorder/internal/untappd"
-	"strconv"
-	"strings"
-	"time"
 )
 
 func main() {
 	csvPath := flag.String("csv", "", "path to a CSV file to backfill from")
+	retry := flag.Bool("retry", false, "retry the checkins which failed in previous backfills")
 	flag.Parse()
 
-	if *csvPath == "" {
+	switch {
+	case *csvPath != "" && *retry:
+		log.Fatal("-csv and -retry can't be used together")
+	case *csvPath == "" && !*retry:
 		log.Fatal("-csv is required for backfill command")
 	}
 
-	if err := run(context.Background(), *csvPath, nil, nil); err != nil {
+	if err := run(context.Background(), *csvPath, *retry, nil, nil); err != nil {
 		log.Fatalf("backfill failed: %v", err)
 	}
 	log.Println("Backfill completed successfully.")
@@ -35,16 +45,28 @@ func main() {
 func run(
 	ctx context.Context,
 	csvPath string,
+	retry bool,
 	store storage.Storage,
 	downloader photo.Downloader,
 ) error {
+	// let the checkins in flight finish on SIGTERM, rather than leaving a
+	// JPG without its WEBP
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("error loading configuration: %w", err)
 	}
 
+	if cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RunTimeout)
+		defer cancel()
+	}
+
 	if store == nil {
-		s, err := storage.NewClient(ctx, cfg)
+		s, err := storage.New(ctx, cfg)
 		if err != nil {
 			return fmt.Errorf("error creating storage client: %w", err)
 		}
@@ -55,10 +77,18 @@ func run(
 		downloader = photo.NewDownloader()
 	}
 
+	if retry {
+		log.Println("Retrying failed checkins")
+		return runRetry(ctx, store, cfg, downloader)
+	}
+
 	log.Printf("Starting backfill from %s\n", csvPath)
 	return runBackfill(ctx, csvPath, store, cfg, downloader)
 }
 
+// date format of the checkins in the Untappd CSV export
+const csvDateLayout = "2006-01-02 15:04:05"
+
 // matches the structure of the Untappd CSV export.
 type CSVRecord struct {
 	BeerName                  string
@@ -120,7 +150,49 @@ func runBackfill(
 		return fmt.Errorf("could not read csv records: %w", err)
 	}
 
-	processCSVRecords(ctx, store, cfg, records, header, downloader)
+	result := processCSVRecords(ctx, store, cfg, records, header, downloader)
+	return summarize(ctx, result.Counts)
+}
+
+// retries the rows which failed in previous backfills, kept under failures/
+func runRetry(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	downloader photo.Downloader,
+) error {
+	result, err := failures.Retry(
+		ctx,
+		store,
+		storage.FailureSourceCSV,
+		cfg.NumWorkers,
+		time.Now().UTC(),
+		func(ctx context.Context, failure *storage.Failure) error {
+			header := slices.Sorted(maps.Keys(failure.CSV))
+			rec := make([]string, len(header))
+			for i, h := range header {
+				rec[i] = failure.CSV[h]
+			}
+			return processCSVRecord(ctx, store, cfg, rec, header, downloader)
+		},
+		processOptions(cfg)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to retry checkins: %w", err)
+	}
+	return summarize(ctx, result.Counts)
+}
+
+// logs the counts, an error when some checkins failed or the run was stopped
+// before the end
+func summarize(ctx context.Context, counts processor.Counts) error {
+	log.Printf("Checkins: %s\n", counts)
+	switch {
+	case counts.Failed > 0:
+		return fmt.Errorf("%d of %d checkins failed", counts.Failed, counts.Total())
+	case ctx.Err() != nil:
+		return fmt.Errorf("interrupted with %d checkins left: %w", counts.Skipped, context.Cause(ctx))
+	}
 	return nil
 }
 
@@ -131,51 +203,111 @@ func processCSVRecords(
 	records [][]string,
 	header []string,
 	downloader photo.Downloader,
-) {
-	processor.Process(ctx, records, cfg.NumWorkers, func(ctx context.Context, rec []string) {
-		csvRecord, err := recordToCSVRecord(rec, header)
+) *processor.Result[[]string] {
+	return processor.Process(ctx, records, cfg.NumWorkers, func(ctx context.Context, rec []string) error {
+		err := processCSVRecord(ctx, store, cfg, rec, header, downloader)
 		if err != nil {
-			log.Printf("error mapping record -> CSVRecord: %v", err)
-			return
+			log.Print(err)
+			addFailure(ctx, store, rec, header, err)
 		}
+		return err
+	}, processOptions(cfg)...)
+}
 
-		checkinID, err := strconv.ParseUint(csvRecord.CheckinID, 10, 64)
-		if err != nil {
-			log.Printf("invalid checkin ID %q: %v", csvRecord.CheckinID, err)
-			return
-		}
+// lets the checkins in flight finish on shutdown, and stops at the first
+// failed one when FAIL_FAST is set
+func processOptions(cfg *config.Config) []processor.Option {
+	opts := []processor.Option{processor.GracePeriod(cfg.ShutdownGracePeriod)}
+	if cfg.FailFast {
+		opts = append(opts, processor.FailFast())
+	}
+	return opts
+}
 
-		log.Printf("Processing checkin %d", checkinID)
+// keeps the row under failures/ for -retry, best effort. Rows without a
+// valid checkin ID can't be told apart, they are only logged.
+func addFailure(ctx context.Context, store storage.FailureStore, rec []string, header []string, err error) {
+	if len(rec) != len(header) {
+		return
+	}
 
-		exists, err := store.CheckinExists(ctx, csvRecord.CheckinID, csvRecord.CreatedAt)
-		if err != nil {
-			log.Printf("failed checking exists(%d): %v", checkinID, err)
-			return
-		}
+	row := make(map[string]string, len(header))
+	for i, h := range header {
+		row[h] = rec[i]
+	}
 
-		if exists {
-			webpExists, err := store.CheckinWEBPExists(ctx, csvRecord.CheckinID, csvRecord.CreatedAt)
-			if err != nil {
-				log.Printf("failed checking webp exists(%d): %v", checkinID, err)
-				return
-			}
-			if webpExists {
-				log.Printf("checkin %d webp exists, skipping", checkinID)
-				return
-			}
+	checkinID, parseErr := strconv.ParseUint(row["checkin_id"], 10, 64)
+	if parseErr != nil {
+		return
+	}
+
+	failure := &storage.Failure{
+		CheckinID: checkinID,
+		Source:    storage.FailureSourceCSV,
+		CSV:       row,
+	}
+	if err := failures.Add(context.WithoutCancel(ctx), store, failure, err, time.Now().UTC()); err != nil {
+		log.Printf("failed to keep the failure of checkin %d: %v", checkinID, err)
+	}
+}
 
-			log.Printf("Backfilling WEBP for checkin %d", checkinID)
-			if err := saveWEBPFromJPG(ctx, store, cfg, csvRecord, downloader); err != nil {
-				log.Printf("failed to save webp(%d): %v", checkinID, err)
+func processCSVRecord(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	rec []string,
+	header []string,
+	downloader photo.Downloader,
+) error {
+	csvRecord, err := recordToCSVRecord(rec, header)
+	if err != nil {
+		return failures.WithStage(failures.StageParse, fmt.Errorf("error mapping record -> CSVRecord: %w", err))
+	}
+
+	checkinID, err := strconv.ParseUint(csvRecord.CheckinID, 10, 64)
+	if err != nil {
+		return failures.WithStage(failures.StageParse, fmt.Errorf("invalid checkin ID %q: %w", csvRecord.CheckinID, err))
+	}
+
+	createdAt, err := time.Parse(csvDateLayout, csvRecord.CreatedAt)
+	if err != nil {
+		return failures.WithStage(failures.StageParse, fmt.Errorf("invalid checkin date %q: %w", csvRecord.CreatedAt, err))
+	}
+
+	log.Printf("Processing checkin %d", checkinID)
+
+	// the export only has the first photo of each checkin
+	exists, err := store.CheckinExists(ctx, checkinID, createdAt, 1)
+	if err != nil {
+		return failures.WithStage(failures.StagePhoto, fmt.Errorf("failed checking exists(%d): %w", checkinID, err))
+	}
+
+	if exists {
+		webpExists, err := store.CheckinWEBPExists(ctx, checkinID, createdAt, 1)
+		if err != nil {
+			return failures.WithStage(failures.StagePhoto, fmt.Errorf("failed checking webp exists(%d): %w", checkinID, err))
+		}
+		if webpExists {
+			// photos are archived, make sure the sidecar is up to date
+			log.Printf("checkin %d webp exists, saving record only", checkinID)
+			if err := saveRecordOnly(ctx, store, csvRecord); err != nil {
+				return fmt.Errorf("failed to save record(%d): %w", checkinID, err)
 			}
-			return
+			return nil
 		}
 
-		log.Printf("Backfilling checkin %d", checkinID)
-		if err := saveCSVRecord(ctx, store, cfg, csvRecord, downloader); err != nil {
-			log.Printf("failed to save(%d): %v", checkinID, err)
+		log.Printf("Backfilling WEBP for checkin %d", checkinID)
+		if err := saveWEBPFromJPG(ctx, store, cfg, csvRecord, downloader); err != nil {
+			return fmt.Errorf("failed to save webp(%d): %w", checkinID, err)
 		}
-	})
+		return nil
+	}
+
+	log.Printf("Backfilling checkin %d", checkinID)
+	if err := saveCSVRecord(ctx, store, cfg, csvRecord, downloader); err != nil {
+		return fmt.Errorf("failed to save(%d): %w", checkinID, err)
+	}
+	return nil
 }
 
 func recordToCSVRecord(record []string, header []string) (*CSVRecord, error) {
@@ -227,11 +359,49 @@ func recordToCSVRecord(record []string, header []string) (*CSVRecord, error) {
 	}, nil
 }
 
-func formatLatLng(record *CSVRecord) string {
-	if record.VenueLat == "" || record.VenueLng == "" {
-		return ""
+// parses an optional numeric CSV field, empty values are zero
+func parseFloat(name, value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return v, nil
+}
+
+func parseUint(name, value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
 	}
-	return fmt.Sprintf("%s,%s", record.VenueLat, record.VenueLng)
+	return v, nil
+}
+
+func parseInt(name, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return v, nil
+}
+
+// splits a comma separated CSV field, ignoring empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
 }
 
 func formatFromAtHomeVenue(value, venue string) string {
@@ -241,6 +411,114 @@ func formatFromAtHomeVenue(value, venue string) string {
 	return value
 }
 
+func toVenueRecord(record *CSVRecord) (*storage.VenueRecord, error) {
+	if record.VenueName == "" {
+		return nil, nil
+	}
+
+	venue := &storage.VenueRecord{
+		Name:    record.VenueName,
+		Type:    untappd.ClassifyVenue(record.VenueName),
+		City:    formatFromAtHomeVenue(record.VenueCity, record.VenueName),
+		State:   formatFromAtHomeVenue(record.VenueState, record.VenueName),
+		Country: formatFromAtHomeVenue(record.VenueCountry, record.VenueName),
+	}
+
+	if record.VenueLat == "" || record.VenueLng == "" ||
+		record.VenueName == untappd.VenueUntappdAtHome {
+		return venue, nil
+	}
+
+	lat, err := parseFloat("venue_lat", record.VenueLat)
+	if err != nil {
+		return nil, err
+	}
+	lng, err := parseFloat("venue_lng", record.VenueLng)
+	if err != nil {
+		return nil, err
+	}
+	venue.Coordinates = &storage.Coordinates{Lat: lat, Lng: lng}
+
+	return venue, nil
+}
+
+// converts the CSV export row into the record stored as JSON sidecar
+func toCheckinRecord(record *CSVRecord) (*storage.CheckinRecord, error) {
+	createdAt, err := time.Parse(csvDateLayout, record.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse created_at: %w", err)
+	}
+
+	checkinID, err := parseUint("checkin_id", record.CheckinID)
+	if err != nil {
+		return nil, err
+	}
+
+	venue, err := toVenueRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &storage.CheckinRecord{
+		Version:        storage.RecordVersion,
+		Source:         storage.RecordSourceCSV,
+		CheckinID:      checkinID,
+		CreatedAt:      createdAt,
+		Comment:        record.Comment,
+		CheckinURL:     record.CheckinURL,
+		Venue:          venue,
+		PurchaseVenue:  record.PurchaseVenue,
+		FlavorProfiles: splitList(record.FlavorProfiles),
+		TaggedFriends:  splitList(record.TaggedFriends),
+		Beer: storage.BeerRecord{
+			Name:  record.BeerName,
+			Style: record.BeerType,
+			URL:   record.BeerURL,
+		},
+		Brewery: storage.BreweryRecord{
+			Name:    record.BreweryName,
+			Country: record.BreweryCountry,
+			City:    record.BreweryCity,
+			State:   record.BreweryState,
+			URL:     record.BreweryURL,
+		},
+	}
+	if record.PhotoURL != "" {
+		r.PhotoURLs = []string{record.PhotoURL}
+	}
+
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"rating_score", record.RatingScore, &r.RatingScore},
+		{"beer_abv", record.BeerABV, &r.Beer.ABV},
+		{"beer_ibu", record.BeerIBU, &r.Beer.IBU},
+		{"global_rating_score", record.GlobalRatingScore, &r.Beer.GlobalRatingScore},
+		{"global_weighted_rating_score", record.GlobalWeightedRatingScore, &r.Beer.GlobalWeightedRatingScore},
+	} {
+		if *f.dst, err = parseFloat(f.name, f.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Beer.BID, err = parseUint("bid", record.BID); err != nil {
+		return nil, err
+	}
+	if r.Brewery.BreweryID, err = parseUint("brewery_id", record.BreweryID); err != nil {
+		return nil, err
+	}
+	if r.TotalToasts, err = parseInt("total_toasts", record.TotalToasts); err != nil {
+		return nil, err
+	}
+	if r.TotalComments, err = parseInt("total_comments", record.TotalComments); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
 func saveRecord(
 	ctx context.Context,
 	store storage.Storage,
@@ -249,32 +527,52 @@ func saveRecord(
 	downloader photo.Downloader,
 	saveWEBP bool,
 ) error {
-	createdAt, err := time.Parse("2006-01-02 15:04:05", record.CreatedAt)
+	checkinRecord, err := toCheckinRecord(record)
 	if err != nil {
-		return fmt.Errorf("failed to parse created_at: %w", err)
+		return failures.WithStage(failures.StageParse, err)
 	}
 
-	metadata := &storage.CheckinMetadata{
-		ID:             record.CheckinID,
-		Beer:           record.BeerName,
-		Brewery:        record.BreweryName,
-		BreweryCountry: record.BreweryCountry,
-		Comment:        record.Comment,
-		Rating:         record.RatingScore,
-		Venue:          record.VenueName,
-		City:           formatFromAtHomeVenue(record.VenueCity, record.VenueName),
-		State:          formatFromAtHomeVenue(record.VenueState, record.VenueName),
-		Country:        formatFromAtHomeVenue(record.VenueCountry, record.VenueName),
-		LatLng:         formatFromAtHomeVenue(formatLatLng(record), record.VenueName),
-		Date:           createdAt.Format(time.RFC1123Z),
-		Style:          record.BeerType,
-		ABV:            record.BeerABV,
-	}
+	metadata := checkinRecord.Metadata()
 
 	if saveWEBP {
-		return downloader.DownloadAndSaveWEBP(ctx, store, metadata)
+		err = downloader.DownloadAndSaveWEBP(ctx, store, metadata)
+	} else {
+		err = downloader.DownloadAndSave(ctx, cfg, store, checkinRecord.PhotoURL(), metadata)
 	}
-	return downloader.DownloadAndSave(ctx, cfg, store, record.PhotoURL, metadata)
+	if err != nil {
+		return failures.WithStage(failures.StagePhoto, err)
+	}
+
+	return uploadRecord(ctx, store, checkinRecord)
+}
+
+func saveRecordOnly(ctx context.Context, store storage.RecordStore, record *CSVRecord) error {
+	checkinRecord, err := toCheckinRecord(record)
+	if err != nil {
+		return failures.WithStage(failures.StageParse, err)
+	}
+	return uploadRecord(ctx, store, checkinRecord)
+}
+
+// a record stored from the API is richer than the export, only the fields
+// it is missing are filled in from it. The tombstone of a deleted checkin is
+// kept either way.
+func uploadRecord(ctx context.Context, store storage.RecordStore, record *storage.CheckinRecord) error {
+	stored, err := store.DownloadRecord(ctx, record.CheckinID, record.CreatedAt)
+	switch {
+	case err == nil && stored.Source == storage.RecordSourceAPI:
+		stored.MergeFrom(record)
+		record = stored
+	case err == nil:
+		record.DeletedAt = stored.DeletedAt
+	case !errors.Is(err, storage.ErrNotFound):
+		return failures.WithStage(failures.StageRecord, fmt.Errorf("failed to download record: %w", err))
+	}
+
+	if err := store.UploadRecord(ctx, record); err != nil {
+		return failures.WithStage(failures.StageRecord, fmt.Errorf("failed to upload record: %w", err))
+	}
+	return nil
 }
 
 func saveCSVRecord(ctx context.Context,
@@ -2,100 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
 	"github.com/smallwat3r/untappd-recorder/internal/photo"
 	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/storage/storagetest"
 	"github.com/smallwat3r/untappd-recorder/internal/untappd"
 )
 
-type mockStorage struct {
-	CheckinExistsFunc         func(ctx context.Context, checkinID, createdAt string) (bool, error)
-	CheckinWEBPExistsFunc     func(ctx context.Context, checkinID, createdAt string) (bool, error)
-	UploadJPGFunc             func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	UploadWEBPFunc            func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	DownloadFunc              func(ctx context.Context, fileName string) ([]byte, error)
-	GetLatestCheckinIDFunc    func(ctx context.Context) (uint64, error)
-	UpdateLatestCheckinIDFunc func(ctx context.Context, checkin untappd.Checkin) error
-}
-
-func (m *mockStorage) CheckinExists(
-	ctx context.Context,
-	checkinID, createdAt string,
-) (bool, error) {
-	if m.CheckinExistsFunc != nil {
-		return m.CheckinExistsFunc(ctx, checkinID, createdAt)
-	}
-	return false, nil
-}
-
-func (m *mockStorage) CheckinWEBPExists(
-	ctx context.Context,
-	checkinID, createdAt string,
-) (bool, error) {
-	if m.CheckinWEBPExistsFunc != nil {
-		return m.CheckinWEBPExistsFunc(ctx, checkinID, createdAt)
-	}
-	return false, nil
-}
-
-func (m *mockStorage) GetLatestCheckinID(ctx context.Context) (uint64, error) {
-	if m.GetLatestCheckinIDFunc != nil {
-		return m.GetLatestCheckinIDFunc(ctx)
-	}
-	return 0, nil
-}
-
-func (m *mockStorage) UpdateLatestCheckinID(ctx context.Context, checkin untappd.Checkin) error {
-	if m.UpdateLatestCheckinIDFunc != nil {
-		return m.UpdateLatestCheckinIDFunc(ctx, checkin)
-	}
-	return nil
-}
-
-func (m *mockStorage) UploadJPG(
-	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
-) error {
-	if m.UploadJPGFunc != nil {
-		return m.UploadJPGFunc(ctx, file, metadata)
-	}
-	return nil
-}
-
-func (m *mockStorage) UploadWEBP(
-	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
-) error {
-	if m.UploadWEBPFunc != nil {
-		return m.UploadWEBPFunc(ctx, file, metadata)
-	}
-	return nil
-}
-
-func (m *mockStorage) Download(ctx context.Context, fileName string) ([]byte, error) {
-	if m.DownloadFunc != nil {
-		return m.DownloadFunc(ctx, fileName)
-	}
-	return nil, nil
-}
-
 type mockDownloader struct {
 	DownloadAndSaveFunc func(
 		ctx context.Context,
 		cfg *config.Config,
-		store storage.Storage,
+		store storage.PhotoStore,
 		photoURL string,
 		metadata *storage.CheckinMetadata,
 	) error
 	DownloadAndSaveWEBPFunc func(
 		ctx context.Context,
-		store storage.Storage,
+		store storage.PhotoStore,
+		metadata *storage.CheckinMetadata,
+	) error
+	RewriteMetadataFunc func(
+		ctx context.Context,
+		store storage.PhotoStore,
 		metadata *storage.CheckinMetadata,
 	) error
 }
@@ -103,7 +38,7 @@ type mockDownloader struct {
 func (m *mockDownloader) DownloadAndSave(
 	ctx context.Context,
 	cfg *config.Config,
-	store storage.Storage,
+	store storage.PhotoStore,
 	photoURL string,
 	metadata *storage.CheckinMetadata,
 ) error {
@@ -115,7 +50,7 @@ func (m *mockDownloader) DownloadAndSave(
 
 func (m *mockDownloader) DownloadAndSaveWEBP(
 	ctx context.Context,
-	store storage.Storage,
+	store storage.PhotoStore,
 	metadata *storage.CheckinMetadata,
 ) error {
 	if m.DownloadAndSaveWEBPFunc != nil {
@@ -124,6 +59,17 @@ func (m *mockDownloader) DownloadAndSaveWEBP(
 	return nil
 }
 
+func (m *mockDownloader) RewriteMetadata(
+	ctx context.Context,
+	store storage.PhotoStore,
+	metadata *storage.CheckinMetadata,
+) error {
+	if m.RewriteMetadataFunc != nil {
+		return m.RewriteMetadataFunc(ctx, store, metadata)
+	}
+	return nil
+}
+
 func TestRun(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -144,23 +90,35 @@ func TestRun(t *testing.T) {
 	}
 
 	checkinExistsCalled := false
+	uploadRecordCalled := false
 
-	mockStore := &mockStorage{
+	mockStore := &storagetest.Storage{
 		CheckinExistsFunc: func(
 			ctx context.Context,
-			checkinID, createdAt string,
+			checkinID uint64,
+			createdAt time.Time,
+			photo int,
 		) (bool, error) {
 			checkinExistsCalled = true
 
-			if checkinID != "12345" {
-				t.Errorf("expected checkinID to be 12345, got %s", checkinID)
+			if checkinID != 12345 {
+				t.Errorf("expected checkinID to be 12345, got %d", checkinID)
 			}
-			if createdAt != "2023-01-01 12:00:00" {
+			if !createdAt.Equal(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)) {
 				t.Errorf("expected createdAt to be 2023-01-01 12:00:00, got %s", createdAt)
 			}
 
 			return false, nil
 		},
+		UploadRecordFunc: func(ctx context.Context, record *storage.CheckinRecord) error {
+			uploadRecordCalled = true
+
+			if record.CheckinID != 12345 {
+				t.Errorf("expected record checkinID to be 12345, got %d", record.CheckinID)
+			}
+
+			return nil
+		},
 	}
 
 	downloadAndSaveCalled := false
@@ -169,7 +127,7 @@ func TestRun(t *testing.T) {
 		DownloadAndSaveFunc: func(
 			ctx context.Context,
 			cfg *config.Config,
-			store storage.Storage,
+			store storage.PhotoStore,
 			photoURL string,
 			metadata *storage.CheckinMetadata,
 		) error {
@@ -183,7 +141,7 @@ func TestRun(t *testing.T) {
 		},
 	}
 
-	if err := run(context.Background(), csvPath, mockStore, downloader); err != nil {
+	if err := run(context.Background(), csvPath, false, mockStore, downloader); err != nil {
 		t.Errorf("run() error = %v, wantErr %v", err, false)
 	}
 
@@ -193,21 +151,28 @@ func TestRun(t *testing.T) {
 	if !downloadAndSaveCalled {
 		t.Error("Expected DownloadAndSave to be called, but it was not")
 	}
+	if !uploadRecordCalled {
+		t.Error("Expected UploadRecord to be called, but it was not")
+	}
 
 	// JPG exists, WEBP does not
 	checkinExistsCalled = false
 	downloadAndSaveWEBPCalled := false
-	mockStore = &mockStorage{
+	mockStore = &storagetest.Storage{
 		CheckinExistsFunc: func(
 			ctx context.Context,
-			checkinID, createdAt string,
+			checkinID uint64,
+			createdAt time.Time,
+			photo int,
 		) (bool, error) {
 			checkinExistsCalled = true
 			return true, nil
 		},
 		CheckinWEBPExistsFunc: func(
 			ctx context.Context,
-			checkinID, createdAt string,
+			checkinID uint64,
+			createdAt time.Time,
+			photo int,
 		) (bool, error) {
 			return false, nil
 		},
@@ -215,7 +180,7 @@ func TestRun(t *testing.T) {
 	downloader = &mockDownloader{
 		DownloadAndSaveWEBPFunc: func(
 			ctx context.Context,
-			store storage.Storage,
+			store storage.PhotoStore,
 			metadata *storage.CheckinMetadata,
 		) error {
 			downloadAndSaveWEBPCalled = true
@@ -226,7 +191,7 @@ func TestRun(t *testing.T) {
 		},
 	}
 
-	if err := run(context.Background(), csvPath, mockStore, downloader); err != nil {
+	if err := run(context.Background(), csvPath, false, mockStore, downloader); err != nil {
 		t.Errorf("run() error = %v, wantErr %v", err, false)
 	}
 
@@ -236,4 +201,214 @@ func TestRun(t *testing.T) {
 	if !downloadAndSaveWEBPCalled {
 		t.Error("Expected DownloadAndSaveWEBP to be called, but it was not")
 	}
+
+	// the photo download fails
+	downloader = &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			return errors.New("photo expired")
+		},
+	}
+
+	var kept *storage.Failure
+	mockStore = &storagetest.Storage{
+		UploadFailureFunc: func(ctx context.Context, failure *storage.Failure) error {
+			kept = failure
+			return nil
+		},
+	}
+
+	err := run(context.Background(), csvPath, false, mockStore, downloader)
+	if err == nil || err.Error() != "1 of 1 checkins failed" {
+		t.Errorf("expected the run to fail with 1 of 1 checkins failed, got %v", err)
+	}
+	if kept == nil || kept.CheckinID != 12345 || kept.Stage != "photo" || kept.CSV["photo_url"] != "http://example.com/photo.jpg" {
+		t.Fatalf("expected the row to be kept under failures/, got %+v", kept)
+	}
+
+	// the retry stores it and removes the failure
+	kept.NextAttemptAt = time.Time{}
+	deleted := false
+	uploadRecordCalled = false
+	mockStore = &storagetest.Storage{
+		ListFailuresFunc: func(ctx context.Context, source string) ([]uint64, error) {
+			return []uint64{kept.CheckinID}, nil
+		},
+		DownloadFailureFunc: func(ctx context.Context, source string, checkinID uint64) (*storage.Failure, error) {
+			return kept, nil
+		},
+		DeleteFailureFunc: func(ctx context.Context, source string, checkinID uint64) error {
+			deleted = source == storage.FailureSourceCSV && checkinID == 12345
+			return nil
+		},
+		UploadRecordFunc: func(ctx context.Context, record *storage.CheckinRecord) error {
+			uploadRecordCalled = true
+			return nil
+		},
+	}
+
+	if err := run(context.Background(), "", true, mockStore, &mockDownloader{}); err != nil {
+		t.Errorf("run() retry error = %v", err)
+	}
+	if !uploadRecordCalled || !deleted {
+		t.Errorf("expected the record to be saved and the failure deleted, saved %v, deleted %v", uploadRecordCalled, deleted)
+	}
+}
+
+func TestRun_KeepsAPIRecord(t *testing.T) {
+	tempDir := t.TempDir()
+
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("R2_ACCOUNT_ID", "test-account-id")
+	t.Setenv("R2_ACCESS_KEY_ID", "test-key-id")
+	t.Setenv("R2_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("BUCKET_NAME", "test-bucket")
+	t.Setenv("NUM_WORKERS", "1")
+
+	csvContent := `checkin_id,created_at,photo_url,beer_name,brewery_name,beer_type,beer_abv,beer_ibu,comment,venue_name,venue_city,venue_state,venue_country,venue_lat,venue_lng,rating_score,checkin_url,beer_url,brewery_url,brewery_country,brewery_city,brewery_state,flavor_profiles,purchase_venue,bid,brewery_id,global_rating_score,global_weighted_rating_score,tagged_friends,total_toasts,total_comments
+12345,2023-01-01 12:00:00,http://example.com/photo.jpg,Test Beer,Test Brewery,IPA,5.0,50,Test comment,Test Venue,Test City,Test State,Test Country,1.23,4.56,4.5,http://example.com/checkin,http://example.com/beer,http://example.com/brewery,Brewery Country,Brewery City,Brewery State,Hoppy,Test Store,67890,123,4.0,4.2,"",0,0
+`
+	csvPath := filepath.Join(tempDir, "test.csv")
+	if err := os.WriteFile(csvPath, []byte(csvContent), 0644); err != nil {
+		t.Fatalf("failed to create test CSV file: %v", err)
+	}
+
+	deletedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the record is saved alone when the WEBP is stored, after the WEBP
+	// when only the JPG is
+	for _, webpExists := range []bool{true, false} {
+		var uploaded *storage.CheckinRecord
+		mockStore := &storagetest.Storage{
+			CheckinExistsFunc: func(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error) {
+				return true, nil
+			},
+			CheckinWEBPExistsFunc: func(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error) {
+				return webpExists, nil
+			},
+			DownloadRecordFunc: func(ctx context.Context, checkinID uint64, createdAt time.Time) (*storage.CheckinRecord, error) {
+				return &storage.CheckinRecord{
+					Source:    storage.RecordSourceAPI,
+					CheckinID: checkinID,
+					CreatedAt: createdAt,
+					PhotoURLs: []string{"http://example.com/photo.jpg", "http://example.com/photo2.jpg"},
+					Beer:      storage.BeerRecord{Name: "Test Beer", Label: "beers/67890/label.jpg"},
+					Badges:    []storage.BadgeRecord{{}},
+					DeletedAt: &deletedAt,
+				}, nil
+			},
+			UploadRecordFunc: func(ctx context.Context, record *storage.CheckinRecord) error {
+				uploaded = record
+				return nil
+			},
+		}
+
+		if err := run(context.Background(), csvPath, false, mockStore, &mockDownloader{}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+
+		if uploaded == nil {
+			t.Fatalf("expected the record to be saved, webp exists %v", webpExists)
+		}
+		if uploaded.Source != storage.RecordSourceAPI || len(uploaded.PhotoURLs) != 2 || len(uploaded.Badges) != 1 ||
+			uploaded.Beer.Label == "" || uploaded.DeletedAt == nil {
+			t.Errorf("expected the API record to be kept, webp exists %v, got %+v", webpExists, uploaded)
+		}
+		if len(uploaded.FlavorProfiles) != 1 || uploaded.PurchaseVenue != "Test Store" {
+			t.Errorf("expected the fields of the export to be filled in, webp exists %v, got %+v", webpExists, uploaded)
+		}
+	}
+}
+
+func TestToCheckinRecord(t *testing.T) {
+	record := &CSVRecord{
+		BeerName:          "Test Beer",
+		BreweryName:       "Test Brewery",
+		BeerType:          "IPA",
+		BeerABV:           "5.5",
+		BeerIBU:           "50",
+		Comment:           "Test comment",
+		VenueName:         "Test Venue",
+		VenueCity:         "Test City",
+		VenueLat:          "1.23",
+		VenueLng:          "4.56",
+		RatingScore:       "4.5",
+		CreatedAt:         "2023-01-01 12:00:00",
+		FlavorProfiles:    "hoppy, bitter",
+		PurchaseVenue:     "Test Store",
+		CheckinID:         "12345",
+		BID:               "67890",
+		BreweryID:         "123",
+		GlobalRatingScore: "4.0",
+		TaggedFriends:     "alice,bob",
+		TotalToasts:       "3",
+		TotalComments:     "",
+	}
+
+	r, err := toCheckinRecord(record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r.CheckinID != 12345 || r.Beer.BID != 67890 || r.Brewery.BreweryID != 123 {
+		t.Errorf("unexpected IDs: %+v", r)
+	}
+	if r.Source != storage.RecordSourceCSV {
+		t.Errorf("expected source to be csv, got %s", r.Source)
+	}
+	if r.Beer.IBU != 50 || r.Beer.GlobalRatingScore != 4.0 || r.RatingScore != 4.5 {
+		t.Errorf("unexpected scores: %+v", r.Beer)
+	}
+	if len(r.FlavorProfiles) != 2 || r.FlavorProfiles[1] != "bitter" {
+		t.Errorf("unexpected flavor profiles: %v", r.FlavorProfiles)
+	}
+	if len(r.TaggedFriends) != 2 || r.TotalToasts != 3 || r.TotalComments != 0 {
+		t.Errorf("unexpected social fields: %+v", r)
+	}
+	if r.Venue == nil || r.Venue.Coordinates == nil || r.Venue.Coordinates.Lat != 1.23 {
+		t.Fatalf("unexpected venue: %+v", r.Venue)
+	}
+
+	md := r.Metadata()
+	if md.ID != "12345" || md.Date != "Sun, 01 Jan 2023 12:00:00 +0000" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if md.LatLng != "1.230000,4.560000" {
+		t.Errorf("unexpected latlng: %s", md.LatLng)
+	}
+	if md.TaggedFriends != "alice,bob" || md.Toasts != "3" || md.Comments != "" {
+		t.Errorf("expected the social context in the metadata, got %+v", md)
+	}
+
+	record.BeerIBU = "N/A"
+	if _, err := toCheckinRecord(record); err == nil {
+		t.Error("expected an error for an invalid beer_ibu")
+	}
+}
+
+func TestToCheckinRecord_AtHome(t *testing.T) {
+	r, err := toCheckinRecord(&CSVRecord{
+		CheckinID:    "1",
+		CreatedAt:    "2023-01-01 12:00:00",
+		VenueName:    untappd.VenueUntappdAtHome,
+		VenueCity:    "Somewhere",
+		VenueCountry: "Somewhere",
+		VenueLat:     "1.23",
+		VenueLng:     "4.56",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r.Venue.City != "" || r.Venue.Country != "" || r.Venue.Coordinates != nil {
+		t.Errorf("expected at home venue to have no location, got %+v", r.Venue)
+	}
+	if r.Venue.Type != untappd.VenueTypeHome {
+		t.Errorf("expected at home venue to be a home, got %q", r.Venue.Type)
+	}
 }
@@ -2,46 +2,250 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/enrich"
+	"github.com/smallwat3r/untappd-recorder/internal/failures"
 	"github.com/smallwat3r/untappd-recorder/internal/photo"
 	"github.com/smallwat3r/untappd-recorder/internal/processor"
 	"github.com/smallwat3r/untappd-recorder/internal/storage"
 	"github.com/smallwat3r/untappd-recorder/internal/untappd"
-	"strconv"
-	"sync"
 )
 
 func main() {
-	if err := run(context.Background(), nil, nil); err != nil {
-		log.Fatalf("record failed: %v", err)
+	history := flag.Bool(
+		"history",
+		false,
+		"walk the full checkin history backwards, resuming from the last checkpoint",
+	)
+	replay := flag.Bool(
+		"replay",
+		false,
+		"rebuild the archive from the archived API responses, without calling Untappd",
+	)
+	retry := flag.Bool(
+		"retry",
+		false,
+		"retry the checkins which failed to be stored in previous runs",
+	)
+	flag.Parse()
+
+	var modes []runMode
+	for m, set := range map[runMode]bool{modeHistory: *history, modeReplay: *replay, modeRetry: *retry} {
+		if set {
+			modes = append(modes, m)
+		}
+	}
+
+	mode := modeRecent
+	switch len(modes) {
+	case 0:
+	case 1:
+		mode = modes[0]
+	default:
+		log.Println("-history, -replay and -retry can't be used together")
+		os.Exit(exitConfig)
+	}
+
+	if err := run(context.Background(), mode, nil, nil); err != nil {
+		log.Printf("record failed: %v", err)
+		os.Exit(exitCode(err))
 	}
 	log.Println("Record completed successfully.")
 }
 
-func run(ctx context.Context, store storage.Storage, untappdClient untappd.UntappdClient) error {
+// exit codes, so the scheduler can alert on a revoked token separately from
+// a transient outage which the next run will get past
+const (
+	exitFailure      = 1
+	exitConfig       = 2
+	exitInvalidToken = 3
+	exitRateLimited  = 4
+	exitUnavailable  = 5
+	exitPartial      = 6
+	exitInterrupted  = 7
+)
+
+var errConfig = errors.New("error loading configuration")
+
+// some checkins failed to be stored, the run carried on with the others
+var errCheckinsFailed = errors.New("checkins failed")
+
+// the run was stopped by a signal or RUN_TIMEOUT
+var errInterrupted = errors.New("interrupted")
+
+type runMode int
+
+const (
+	// records the checkins newer than the latest recorded one
+	modeRecent runMode = iota
+	// walks the full checkin history
+	modeHistory
+	// rebuilds the archive from the archived API responses
+	modeReplay
+	// retries the checkins which failed in previous runs
+	modeRetry
+)
+
+func (m runMode) String() string {
+	switch m {
+	case modeHistory:
+		return "history"
+	case modeReplay:
+		return "replay"
+	case modeRetry:
+		return "retry"
+	default:
+		return "recent"
+	}
+}
+
+func exitCode(err error) int {
+	var apiErr *untappd.APIError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, errConfig):
+		return exitConfig
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	case errors.Is(err, errCheckinsFailed):
+		return exitPartial
+	case errors.Is(err, untappd.ErrInvalidToken):
+		return exitInvalidToken
+	case errors.Is(err, untappd.ErrRateLimited):
+		return exitRateLimited
+	case errors.As(err, &apiErr) && apiErr.Temporary(),
+		errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded):
+		return exitUnavailable
+	default:
+		return exitFailure
+	}
+}
+
+func run(
+	ctx context.Context,
+	mode runMode,
+	store storage.Storage,
+	untappdClient untappd.UntappdClient,
+) error {
+	// a stopped container gets SIGTERM, let the checkins in flight finish
+	// and save the progress rather than exiting mid-upload
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cfg, err := config.Load()
 	if err != nil {
-		return fmt.Errorf("error loading configuration: %w", err)
+		return fmt.Errorf("%w: %w", errConfig, err)
 	}
 
+	if cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RunTimeout)
+		defer cancel()
+	}
+	stopLog := context.AfterFunc(ctx, func() {
+		log.Printf("Stopping, waiting up to %s for the checkins in flight\n", cfg.ShutdownGracePeriod)
+	})
+	defer stopLog()
+
 	if store == nil {
-		s, err := storage.NewClient(ctx, cfg)
+		s, err := storage.New(ctx, cfg)
 		if err != nil {
 			return fmt.Errorf("error creating storage client: %w", err)
 		}
 		store = s
 	}
 
-	if untappdClient == nil {
-		untappdClient = untappd.NewClient(cfg)
+	if untappdClient == nil && mode != modeReplay {
+		var opts []untappd.Option
+		if cfg.ArchiveRaw {
+			opts = append(opts, untappd.WithArchive(store.UploadRawResponse))
+		}
+		untappdClient = untappd.NewClient(cfg, opts...)
 	}
 
 	downloader := photo.NewDownloader()
+	counts := &processor.Counts{}
+	startedAt := time.Now().UTC()
+
+	switch mode {
+	case modeHistory:
+		err = runHistory(ctx, store, cfg, untappdClient, downloader, counts)
+	case modeReplay:
+		err = runReplay(ctx, store, cfg, downloader, counts)
+	case modeRetry:
+		err = runRetry(ctx, store, cfg, untappdClient, downloader, counts)
+	default:
+		err = runRecorder(ctx, store, cfg, untappdClient, downloader, counts)
+	}
+
+	log.Printf("Checkins: %s\n", counts)
+	if err == nil && counts.Failed > 0 {
+		err = fmt.Errorf("%w: %d of %d", errCheckinsFailed, counts.Failed, counts.Total())
+	}
+
+	if ctx.Err() != nil {
+		// the progress is saved, the next run carries on from there
+		if err == nil {
+			err = context.Cause(ctx)
+		}
+		err = fmt.Errorf("%w: %w", errInterrupted, err)
+	}
+
+	recordRun(context.WithoutCancel(ctx), store, mode, startedAt, counts, err)
+	return err
+}
+
+// lets the checkins in flight finish on shutdown, and stops at the first
+// failed one when FAIL_FAST is set
+func processOptions(cfg *config.Config) []processor.Option {
+	opts := []processor.Option{processor.GracePeriod(cfg.ShutdownGracePeriod)}
+	if cfg.FailFast {
+		opts = append(opts, processor.FailFast())
+	}
+	return opts
+}
+
+// keeps a summary of the run in the state, best effort
+func recordRun(
+	ctx context.Context,
+	store storage.StateStore,
+	mode runMode,
+	startedAt time.Time,
+	counts *processor.Counts,
+	runErr error,
+) {
+	run := storage.RunStats{
+		Mode:       mode.String(),
+		StartedAt:  startedAt,
+		FinishedAt: time.Now().UTC(),
+		Checkins:   counts.Succeeded,
+		Failed:     counts.Failed,
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
 
-	return runRecorder(ctx, store, cfg, untappdClient, downloader)
+	err := store.UpdateState(ctx, func(state *storage.State) error {
+		state.AddRun(run)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to record the run: %v", err)
+	}
 }
 
 func runRecorder(
@@ -50,22 +254,172 @@ func runRecorder(
 	cfg *config.Config,
 	untappdClient untappd.UntappdClient,
 	downloader photo.Downloader,
+	counts *processor.Counts,
 ) error {
 	latestCheckinID, err := store.GetLatestCheckinID(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get latest checkin ID: %w", err)
 	}
 
-	proc := newCheckinProcessor(store, cfg, downloader)
-	return untappdClient.FetchCheckins(ctx, latestCheckinID, proc)
+	enricher := newEnricher(store, cfg, untappdClient)
+	proc := newCheckinProcessor(store, cfg, downloader, enricher, counts)
+
+	// the rate limit is only an error when it stopped the run before any page
+	// was processed
+	progressed := false
+	err = untappdClient.FetchCheckins(ctx, latestCheckinID, func(ctx context.Context, checkins []untappd.Checkin) error {
+		progressed = true
+		return proc(ctx, checkins)
+	})
+	if errors.Is(err, untappd.ErrRateLimited) && progressed {
+		// no calls left for the re-sync either, the next run carries on
+		log.Printf("Stopping at the rate limit: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if cfg.ResyncDays > 0 {
+		err := runResync(ctx, store, cfg, untappdClient, downloader, enricher, counts, time.Now().UTC())
+		if errors.Is(err, untappd.ErrRateLimited) {
+			// the new checkins are recorded, the window is walked again by
+			// the next run
+			log.Printf("Stopping the re-sync at the rate limit: %v\n", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// archives the checkins from the newest to the oldest, 50 per API call. The
+// progress is checkpointed after each page, so a run stopped by the rate
+// limit picks up where it left off.
+func runHistory(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	untappdClient untappd.UntappdClient,
+	downloader photo.Downloader,
+	counts *processor.Counts,
+) error {
+	progress, err := store.GetHistoryProgress(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get history progress: %w", err)
+	}
+
+	if progress.Complete {
+		log.Printf("History already archived (%d checkins)\n", progress.Checkins)
+		return nil
+	}
+
+	if progress.MaxID != 0 {
+		log.Printf("Resuming history from checkin %d\n", progress.MaxID)
+	}
+
+	enricher := newEnricher(store, cfg, untappdClient)
+	proc := newHistoryProcessor(store, cfg, downloader, enricher, progress, counts)
+	err = untappdClient.FetchHistory(ctx, progress.MaxID, proc)
+	if errors.Is(err, untappd.ErrRateLimited) {
+		// expected on large histories, the checkpoint is saved
+		log.Printf("Stopping history at checkin %d: %v\n", progress.MaxID, err)
+		return nil
+	}
+	return err
+}
+
+func newHistoryProcessor(
+	store storage.Storage,
+	cfg *config.Config,
+	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+	progress *storage.HistoryProgress,
+	counts *processor.Counts,
+) func(context.Context, []untappd.Checkin, uint64) error {
+	return func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		firstPage := progress.MaxID == 0
+
+		log.Printf("Processing %d checkins from history\n", len(checkins))
+		batch := processCheckins(ctx, store, cfg, checkins, downloader, enricher, counts)
+
+		// saved even when the run is stopped
+		persistCtx := context.WithoutCancel(ctx)
+		clearFailures(persistCtx, store, batch)
+
+		// the first page holds the newest checkin, seed the latest checkin
+		// so the regular mode carries on from there
+		settled := batch.settled()
+		if firstPage {
+			if latest, ok := settledUpTo(checkins, settled); ok {
+				if err := seedLatestCheckinID(persistCtx, store, latest); err != nil {
+					log.Printf("failed to update latest checkin ID: %v\n", err)
+				}
+			}
+		}
+
+		// the checkpoint stays on the page, the next run fetches it again
+		if left := len(checkins) - len(settled); left > 0 {
+			err := ctx.Err()
+			if err == nil {
+				err = errors.New("stopped after a failed checkin")
+			}
+			return fmt.Errorf("%d checkins of the page left: %w", left, err)
+		}
+
+		progress.MaxID = nextMaxID
+		progress.Complete = nextMaxID == 0
+		progress.Checkins += len(checkins)
+		progress.UpdatedAt = time.Now().UTC()
+
+		if err := store.UpdateHistoryProgress(persistCtx, progress); err != nil {
+			return fmt.Errorf("failed to update history progress: %w", err)
+		}
+
+		if progress.Complete {
+			log.Printf("Reached the first checkin, history archived (%d checkins)\n", progress.Checkins)
+		}
+		return nil
+	}
+}
+
+func seedLatestCheckinID(ctx context.Context, store storage.StateStore, checkin untappd.Checkin) error {
+	latest, err := store.GetLatestCheckinID(ctx)
+	if err != nil {
+		return err
+	}
+	if latest != 0 {
+		return nil
+	}
+	return store.UpdateLatestCheckinID(ctx, checkin)
+}
+
+// nil when enrichment is disabled
+func newEnricher(
+	store storage.ProfileStore,
+	cfg *config.Config,
+	untappdClient untappd.UntappdClient,
+) *enrich.Enricher {
+	if !cfg.EnrichBeers && !cfg.EnrichBreweries {
+		return nil
+	}
+	return enrich.New(store, untappdClient)
 }
 
 func newCheckinProcessor(
 	store storage.Storage,
 	cfg *config.Config,
 	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+	counts *processor.Counts,
 ) func(context.Context, []untappd.Checkin) error {
-	var once sync.Once
+	// set once a checkin was neither stored nor kept under failures/, the
+	// newer batches can't move the cursor past it either
+	var blocked bool
 
 	return func(ctx context.Context, checkins []untappd.Checkin) error {
 		if ctx.Err() != nil {
@@ -78,34 +432,140 @@ func newCheckinProcessor(
 		}
 
 		log.Printf("Processing %d checkins\n", len(checkins))
-		processCheckins(ctx, store, cfg, checkins, downloader)
+		batch := processCheckins(ctx, store, cfg, checkins, downloader, enricher, counts)
 
-		// first element should be newest, update once per FetchCheckins cycle
-		once.Do(func() {
-			if err := store.UpdateLatestCheckinID(ctx, checkins[0]); err != nil {
-				log.Printf("failed to update latest checkin ID: %v\n", err)
-			}
-		})
+		// saved even when the run is stopped
+		persistCtx := context.WithoutCancel(ctx)
+		clearFailures(persistCtx, store, batch)
+
+		if blocked {
+			return nil
+		}
+		settled := batch.settled()
+		blocked = len(settled) < len(checkins)
+
+		// the failed checkins are retried from failures/, the next run
+		// fetches the ones after the cursor again
+		latest, ok := settledUpTo(checkins, settled)
+		if !ok {
+			log.Printf("Not moving the latest checkin, %d checkins not stored\n", len(checkins))
+			return nil
+		}
+		if err := store.UpdateLatestCheckinID(persistCtx, latest); err != nil {
+			log.Printf("failed to update latest checkin ID: %v\n", err)
+		}
 		return nil
 	}
 }
 
+// outcome of storing a batch of checkins. The ones left unprocessed, e.g. as
+// the run was stopped, and the failed ones which could not be kept are in
+// neither.
+type batchResult struct {
+	stored map[uint64]bool
+	// failed, and kept under failures/ for a retry
+	kept map[uint64]bool
+}
+
+func newBatchResult() *batchResult {
+	return &batchResult{stored: make(map[uint64]bool), kept: make(map[uint64]bool)}
+}
+
+// the checkins stored or kept for a retry, which the cursor can move past
+func (b *batchResult) settled() map[uint64]bool {
+	settled := maps.Clone(b.stored)
+	maps.Copy(settled, b.kept)
+	return settled
+}
+
 func processCheckins(
 	ctx context.Context,
 	store storage.Storage,
 	cfg *config.Config,
 	checkins []untappd.Checkin,
 	downloader photo.Downloader,
-) {
-	processor.Process(ctx, checkins, cfg.NumWorkers, func(
+	enricher *enrich.Enricher,
+	counts *processor.Counts,
+) *batchResult {
+	var mu sync.Mutex
+	batch := newBatchResult()
+
+	result := processor.Process(ctx, checkins, cfg.NumWorkers, func(
 		ctx context.Context,
 		c untappd.Checkin,
-	) {
+	) error {
 		log.Printf("Processing checkin %d", c.CheckinID)
-		if err := saveCheckin(ctx, store, cfg, c, downloader); err != nil {
+		if err := saveCheckin(ctx, store, cfg, c, downloader, enricher); err != nil {
 			log.Printf("failed to save checkin %d: %v", c.CheckinID, err)
+			if addFailure(ctx, store, c, err) {
+				mu.Lock()
+				batch.kept[c.CheckinID] = true
+				mu.Unlock()
+			}
+			return err
 		}
-	})
+
+		mu.Lock()
+		batch.stored[c.CheckinID] = true
+		mu.Unlock()
+		return nil
+	}, processOptions(cfg)...)
+
+	counts.Add(result.Counts)
+	return batch
+}
+
+// keeps the checkin under failures/ for the retry mode, best effort. Reports
+// whether it was kept.
+func addFailure(ctx context.Context, store storage.FailureStore, checkin untappd.Checkin, err error) bool {
+	failure := &storage.Failure{
+		CheckinID: checkin.CheckinID,
+		Source:    storage.FailureSourceAPI,
+		Checkin:   &checkin,
+	}
+	if err := failures.Add(context.WithoutCancel(ctx), store, failure, err, time.Now().UTC()); err != nil {
+		log.Printf("failed to keep the failure of checkin %d: %v", checkin.CheckinID, err)
+		return false
+	}
+	return true
+}
+
+// the newest of the checkins, newest first, such that it and all the older
+// ones are settled. false when the oldest one is not.
+func settledUpTo(checkins []untappd.Checkin, settled map[uint64]bool) (untappd.Checkin, bool) {
+	for i := len(checkins) - 1; i >= 0; i-- {
+		if !settled[checkins[i].CheckinID] {
+			if i == len(checkins)-1 {
+				return untappd.Checkin{}, false
+			}
+			return checkins[i+1], true
+		}
+	}
+	if len(checkins) == 0 {
+		return untappd.Checkin{}, false
+	}
+	return checkins[0], true
+}
+
+// removes the failures/ entries of the checkins now stored, best effort
+func clearFailures(ctx context.Context, store storage.FailureStore, batch *batchResult) {
+	if len(batch.stored) == 0 {
+		return
+	}
+
+	ids, err := store.ListFailures(ctx, storage.FailureSourceAPI)
+	if err != nil {
+		log.Printf("failed to list failures: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if !batch.stored[id] {
+			continue
+		}
+		if err := store.DeleteFailure(ctx, storage.FailureSourceAPI, id); err != nil {
+			log.Printf("failed to delete the failure of checkin %d: %v", id, err)
+		}
+	}
 }
 
 func saveCheckin(
@@ -114,28 +574,164 @@ func saveCheckin(
 	cfg *config.Config,
 	checkin untappd.Checkin,
 	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+) error {
+	record, err := storage.RecordFromCheckin(checkin)
+	if err != nil {
+		return failures.WithStage(failures.StageParse, fmt.Errorf("failed to build record: %w", err))
+	}
+	return saveRecord(ctx, store, cfg, record, downloader, enricher, keepPhotos)
+}
+
+// how saveRecord handles the photos already stored
+type photoPolicy int
+
+const (
+	// stored photos are skipped
+	keepPhotos photoPolicy = iota
+	// photos are downloaded again, e.g. when they changed on Untappd
+	refetchPhotos
+	// the metadata of the stored photos is rewritten, without downloading
+	// them again
+	restampPhotos
+)
+
+// stores the photos and the record of a checkin
+func saveRecord(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	record *storage.CheckinRecord,
+	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+	policy photoPolicy,
+) error {
+	enrichRecord(ctx, cfg, enricher, record)
+	saveBadges(ctx, store, record)
+
+	// checkins without photo get the placeholder
+	photoURLs := record.PhotoURLs
+	if len(photoURLs) == 0 {
+		photoURLs = []string{""}
+	}
+
+	var errs []error
+	for i, photoURL := range photoURLs {
+		metadata := record.Metadata()
+		metadata.Photo = i + 1
+
+		var err error
+		switch policy {
+		case refetchPhotos:
+			err = downloader.DownloadAndSave(ctx, cfg, store, photoURL, metadata)
+		case restampPhotos:
+			err = restampPhoto(ctx, store, cfg, record, photoURL, metadata, downloader)
+		default:
+			err = savePhoto(ctx, store, cfg, record, photoURL, metadata, downloader)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("photo %d: %w", metadata.Photo, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return failures.WithStage(failures.StagePhoto, err)
+	}
+
+	if err := store.UploadRecord(ctx, record); err != nil {
+		return failures.WithStage(failures.StageRecord, fmt.Errorf("failed to upload record: %w", err))
+	}
+
+	return nil
+}
+
+// enrichment is best effort, the checkin is archived either way
+func enrichRecord(
+	ctx context.Context,
+	cfg *config.Config,
+	enricher *enrich.Enricher,
+	record *storage.CheckinRecord,
+) {
+	if enricher == nil {
+		return
+	}
+
+	if cfg.EnrichBeers {
+		if err := enricher.Beer(ctx, record); err != nil {
+			log.Printf("failed to enrich beer of checkin %d: %v", record.CheckinID, err)
+		}
+	}
+	if cfg.EnrichBreweries {
+		if err := enricher.Brewery(ctx, record); err != nil {
+			log.Printf("failed to enrich brewery of checkin %d: %v", record.CheckinID, err)
+		}
+	}
+}
+
+// archives the badges earned with the checkin, best effort like the
+// enrichment. Badge images are shared by every earn so only fetched once.
+func saveBadges(ctx context.Context, store storage.ProfileStore, record *storage.CheckinRecord) {
+	for i := range record.Badges {
+		b := &record.Badges[i]
+		key := b.ImageArchiveKey()
+		if key == "" {
+			continue
+		}
+		if err := saveBadgeImage(ctx, store, key, b.ImageURL); err != nil {
+			log.Printf("failed to archive image of badge %d: %v", b.BadgeID, err)
+			continue
+		}
+		b.Image = key
+	}
+
+	for _, earn := range record.BadgeEarns() {
+		if err := store.UploadBadgeEarn(ctx, earn); err != nil {
+			log.Printf("failed to record badge %d of checkin %d: %v", earn.BadgeID, earn.CheckinID, err)
+		}
+	}
+}
+
+func saveBadgeImage(ctx context.Context, store storage.ProfileStore, key, imageURL string) error {
+	exists, err := store.ImageExists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	b, err := photo.Fetch(ctx, imageURL)
+	if err != nil {
+		return err
+	}
+	return store.UploadBadgeImage(ctx, key, b)
+}
+
+// stores a photo of the checkin, only filling in what is missing from a
+// previous run
+func savePhoto(
+	ctx context.Context,
+	store storage.PhotoStore,
+	cfg *config.Config,
+	record *storage.CheckinRecord,
+	photoURL string,
+	metadata *storage.CheckinMetadata,
+	downloader photo.Downloader,
 ) error {
-	photoURL := ""
-	if len(checkin.Media.Items) > 0 {
-		photoURL = checkin.Media.Items[0].Photo.PhotoImgOg
-	}
-
-	metadata := &storage.CheckinMetadata{
-		ID:             strconv.FormatUint(checkin.CheckinID, 10),
-		Beer:           checkin.Beer.BeerName,
-		Brewery:        checkin.Brewery.BreweryName,
-		BreweryCountry: checkin.Brewery.BreweryCountry,
-		Comment:        checkin.CheckinComment,
-		Rating:         fmt.Sprintf("%.2f", checkin.RatingScore),
-		Venue:          checkin.Venue.Name(),
-		City:           checkin.Venue.City(),
-		State:          checkin.Venue.State(),
-		Country:        checkin.Venue.Country(),
-		LatLng:         checkin.Venue.LatLng(),
-		Date:           checkin.CreatedAt,
-		Style:          checkin.Beer.BeerStyle,
-		ABV:            fmt.Sprintf("%.2f", checkin.Beer.BeerABV),
-	}
-
-	return downloader.DownloadAndSave(ctx, cfg, store, photoURL, metadata)
+	exists, err := store.CheckinExists(ctx, record.CheckinID, record.CreatedAt, metadata.Photo)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return downloader.DownloadAndSave(ctx, cfg, store, photoURL, metadata)
+	}
+
+	webpExists, err := store.CheckinWEBPExists(ctx, record.CheckinID, record.CreatedAt, metadata.Photo)
+	if err != nil {
+		return err
+	}
+	if webpExists {
+		return nil
+	}
+
+	return downloader.DownloadAndSaveWEBP(ctx, store, metadata)
 }
@@ -2,119 +2,97 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
 	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/storage/storagetest"
 	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd/untappdtest"
 )
 
-type mockStorage struct {
-	GetLatestCheckinIDFunc    func(ctx context.Context) (uint64, error)
-	UpdateLatestCheckinIDFunc func(ctx context.Context, checkin untappd.Checkin) error
-	UploadJPGFunc             func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	UploadWEBPFunc            func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	DownloadFunc              func(ctx context.Context, fileName string) ([]byte, error)
-	CheckinExistsFunc         func(ctx context.Context, checkinID, createdAt string) (bool, error)
-	CheckinWEBPExistsFunc     func(ctx context.Context, checkinID, createdAt string) (bool, error)
-}
-
-func (m *mockStorage) GetLatestCheckinID(ctx context.Context) (uint64, error) {
-	if m.GetLatestCheckinIDFunc != nil {
-		return m.GetLatestCheckinIDFunc(ctx)
-	}
-	return 0, nil
-}
-
-func (m *mockStorage) UpdateLatestCheckinID(
-	ctx context.Context,
-	checkin untappd.Checkin,
-) error {
-	if m.UpdateLatestCheckinIDFunc != nil {
-		return m.UpdateLatestCheckinIDFunc(ctx, checkin)
-	}
-	return nil
+type mockUntappdClient struct {
+	FetchCheckinsFunc func(
+		ctx context.Context,
+		sinceID uint64,
+		checkinProcessor func(context.Context, []untappd.Checkin) error,
+	) error
+	FetchHistoryFunc func(
+		ctx context.Context,
+		maxID uint64,
+		pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+	) error
+	FetchBeerFunc    func(ctx context.Context, bid uint64) (*untappd.BeerInfo, error)
+	FetchBreweryFunc func(ctx context.Context, breweryID uint64) (*untappd.BreweryInfo, error)
 }
 
-func (m *mockStorage) UploadJPG(
+func (m *mockUntappdClient) FetchCheckins(
 	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
+	sinceID uint64,
+	checkinProcessor func(context.Context, []untappd.Checkin) error,
 ) error {
-	if m.UploadJPGFunc != nil {
-		return m.UploadJPGFunc(ctx, file, metadata)
+	if m.FetchCheckinsFunc != nil {
+		return m.FetchCheckinsFunc(ctx, sinceID, checkinProcessor)
 	}
 	return nil
 }
 
-func (m *mockStorage) UploadWEBP(
+func (m *mockUntappdClient) FetchHistory(
 	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
+	maxID uint64,
+	pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
 ) error {
-	if m.UploadWEBPFunc != nil {
-		return m.UploadWEBPFunc(ctx, file, metadata)
+	if m.FetchHistoryFunc != nil {
+		return m.FetchHistoryFunc(ctx, maxID, pageProcessor)
 	}
 	return nil
 }
 
-func (m *mockStorage) Download(ctx context.Context, fileName string) ([]byte, error) {
-	if m.DownloadFunc != nil {
-		return m.DownloadFunc(ctx, fileName)
+func (m *mockUntappdClient) FetchBeer(ctx context.Context, bid uint64) (*untappd.BeerInfo, error) {
+	if m.FetchBeerFunc != nil {
+		return m.FetchBeerFunc(ctx, bid)
 	}
-	return nil, nil
+	return &untappd.BeerInfo{BID: bid}, nil
 }
 
-func (m *mockStorage) CheckinExists(
+func (m *mockUntappdClient) FetchBrewery(
 	ctx context.Context,
-	checkinID, createdAt string,
-) (bool, error) {
-	if m.CheckinExistsFunc != nil {
-		return m.CheckinExistsFunc(ctx, checkinID, createdAt)
+	breweryID uint64,
+) (*untappd.BreweryInfo, error) {
+	if m.FetchBreweryFunc != nil {
+		return m.FetchBreweryFunc(ctx, breweryID)
 	}
-	return false, nil
-}
-
-func (m *mockStorage) CheckinWEBPExists(
-	ctx context.Context,
-	checkinID, createdAt string,
-) (bool, error) {
-	if m.CheckinWEBPExistsFunc != nil {
-		return m.CheckinWEBPExistsFunc(ctx, checkinID, createdAt)
-	}
-	return false, nil
-}
-
-type mockUntappdClient struct {
-	FetchCheckinsFunc func(
-		ctx context.Context,
-		sinceID uint64,
-		checkinProcessor func(context.Context, []untappd.Checkin) error,
-	) error
-}
-
-func (m *mockUntappdClient) FetchCheckins(
-	ctx context.Context,
-	sinceID uint64,
-	checkinProcessor func(context.Context, []untappd.Checkin) error,
-) error {
-	if m.FetchCheckinsFunc != nil {
-		return m.FetchCheckinsFunc(ctx, sinceID, checkinProcessor)
-	}
-	return nil
+	return &untappd.BreweryInfo{BreweryID: breweryID}, nil
 }
 
 type mockDownloader struct {
 	DownloadAndSaveFunc func(
 		ctx context.Context,
 		cfg *config.Config,
-		store storage.Storage,
+		store storage.PhotoStore,
 		photoURL string,
 		metadata *storage.CheckinMetadata,
 	) error
 	DownloadAndSaveWEBPFunc func(
 		ctx context.Context,
-		store storage.Storage,
+		store storage.PhotoStore,
+		metadata *storage.CheckinMetadata,
+	) error
+	RewriteMetadataFunc func(
+		ctx context.Context,
+		store storage.PhotoStore,
 		metadata *storage.CheckinMetadata,
 	) error
 }
@@ -122,7 +100,7 @@ type mockDownloader struct {
 func (m *mockDownloader) DownloadAndSave(
 	ctx context.Context,
 	cfg *config.Config,
-	store storage.Storage,
+	store storage.PhotoStore,
 	photoURL string,
 	metadata *storage.CheckinMetadata,
 ) error {
@@ -134,7 +112,7 @@ func (m *mockDownloader) DownloadAndSave(
 
 func (m *mockDownloader) DownloadAndSaveWEBP(
 	ctx context.Context,
-	store storage.Storage,
+	store storage.PhotoStore,
 	metadata *storage.CheckinMetadata,
 ) error {
 	if m.DownloadAndSaveWEBPFunc != nil {
@@ -143,6 +121,17 @@ func (m *mockDownloader) DownloadAndSaveWEBP(
 	return nil
 }
 
+func (m *mockDownloader) RewriteMetadata(
+	ctx context.Context,
+	store storage.PhotoStore,
+	metadata *storage.CheckinMetadata,
+) error {
+	if m.RewriteMetadataFunc != nil {
+		return m.RewriteMetadataFunc(ctx, store, metadata)
+	}
+	return nil
+}
+
 func TestRun_ProcessCheckins(t *testing.T) {
 	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
 	t.Setenv("R2_ACCOUNT_ID", "test-account-id")
@@ -152,8 +141,9 @@ func TestRun_ProcessCheckins(t *testing.T) {
 	t.Setenv("NUM_WORKERS", "1")
 
 	updateLatestCheckinIDCalled := false
+	uploadRecordCalled := false
 
-	mockStore := &mockStorage{
+	mockStore := &storagetest.Storage{
 		UpdateLatestCheckinIDFunc: func(
 			ctx context.Context,
 			checkin untappd.Checkin,
@@ -168,13 +158,26 @@ func TestRun_ProcessCheckins(t *testing.T) {
 		},
 	}
 
+	mockStore.UploadRecordFunc = func(
+		ctx context.Context,
+		record *storage.CheckinRecord,
+	) error {
+		uploadRecordCalled = true
+
+		if record.CheckinID != 54321 {
+			t.Errorf("expected record checkinID to be 54321, got %d", record.CheckinID)
+		}
+
+		return nil
+	}
+
 	downloadAndSaveCalled := false
 
 	mockDownloader := &mockDownloader{
 		DownloadAndSaveFunc: func(
 			ctx context.Context,
 			cfg *config.Config,
-			store storage.Storage,
+			store storage.PhotoStore,
 			photoURL string,
 			metadata *storage.CheckinMetadata,
 		) error {
@@ -195,7 +198,7 @@ func TestRun_ProcessCheckins(t *testing.T) {
 			checkinProcessor func(context.Context, []untappd.Checkin) error,
 		) error {
 			checkins := []untappd.Checkin{
-				{CheckinID: 54321},
+				{CheckinID: 54321, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
 			}
 			return checkinProcessor(ctx, checkins)
 		},
@@ -212,6 +215,7 @@ func TestRun_ProcessCheckins(t *testing.T) {
 		cfg,
 		mockUntappd,
 		mockDownloader,
+		&processor.Counts{},
 	); err != nil {
 		t.Errorf("runRecorder() error = %v, wantErr %v", err, false)
 	}
@@ -223,6 +227,277 @@ func TestRun_ProcessCheckins(t *testing.T) {
 	if !downloadAndSaveCalled {
 		t.Error("expected DownloadAndSave to be called, but it was not")
 	}
+
+	if !uploadRecordCalled {
+		t.Error("expected UploadRecord to be called, but it was not")
+	}
+}
+
+func TestRunRecorder_FailedCheckinCursor(t *testing.T) {
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("R2_ACCOUNT_ID", "test-account-id")
+	t.Setenv("R2_ACCESS_KEY_ID", "test-key-id")
+	t.Setenv("R2_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("BUCKET_NAME", "test-bucket")
+	t.Setenv("NUM_WORKERS", "1")
+
+	state := &storage.State{}
+	var cursors []uint64
+
+	mockStore := newFailuresStorage(state)
+	mockStore.UpdateLatestCheckinIDFunc = func(ctx context.Context, checkin untappd.Checkin) error {
+		cursors = append(cursors, checkin.CheckinID)
+		return nil
+	}
+
+	failing := "20"
+	mockDownloader := &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			if metadata.ID == failing {
+				return errors.New("photo expired")
+			}
+			return nil
+		},
+	}
+
+	checkinAt := func(id uint64) untappd.Checkin {
+		return untappd.Checkin{CheckinID: id, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"}
+	}
+	mockUntappd := &mockUntappdClient{
+		FetchCheckinsFunc: func(
+			ctx context.Context,
+			sinceID uint64,
+			checkinProcessor func(context.Context, []untappd.Checkin) error,
+		) error {
+			if err := checkinProcessor(ctx, []untappd.Checkin{
+				checkinAt(30), checkinAt(20), checkinAt(10),
+			}); err != nil {
+				return err
+			}
+			return checkinProcessor(ctx, []untappd.Checkin{checkinAt(40)})
+		},
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	err = runRecorder(context.Background(), mockStore, cfg, mockUntappd, mockDownloader, &processor.Counts{})
+	if err != nil {
+		t.Fatalf("runRecorder() error = %v", err)
+	}
+
+	// kept under failures/, the failed checkin no longer holds the cursor
+	if !slices.Equal(cursors, []uint64{30, 40}) {
+		t.Errorf("expected the cursor to move to 30 then 40, got %v", cursors)
+	}
+	failure := mockStore.failures[20]
+	if failure == nil || failure.Stage != "photo" || failure.Checkin == nil || failure.Checkin.CheckinID != 20 {
+		t.Errorf("expected checkin 20 to be kept under failures/, got %+v", failure)
+	}
+
+	// stored by a later run, it is removed from failures/
+	failing = ""
+	cursors = nil
+	err = runRecorder(context.Background(), mockStore, cfg, mockUntappd, mockDownloader, &processor.Counts{})
+	if err != nil {
+		t.Fatalf("runRecorder() error = %v", err)
+	}
+	if len(mockStore.failures) != 0 {
+		t.Errorf("expected the failure to be removed, got %+v", mockStore.failures)
+	}
+
+	// a failure which can't be kept holds the cursor, even for the newer
+	// batch
+	failing = "20"
+	cursors = nil
+	mockStore.UploadFailureFunc = func(ctx context.Context, failure *storage.Failure) error {
+		return errors.New("storage unavailable")
+	}
+	err = runRecorder(context.Background(), mockStore, cfg, mockUntappd, mockDownloader, &processor.Counts{})
+	if err != nil {
+		t.Fatalf("runRecorder() error = %v", err)
+	}
+	if !slices.Equal(cursors, []uint64{10}) {
+		t.Errorf("expected the cursor to move to 10 only, got %v", cursors)
+	}
+}
+
+func TestRunRecorder_RateLimited(t *testing.T) {
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("R2_ACCOUNT_ID", "test-account-id")
+	t.Setenv("R2_ACCESS_KEY_ID", "test-key-id")
+	t.Setenv("R2_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("BUCKET_NAME", "test-bucket")
+	t.Setenv("RESYNC_DAYS", "7")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		checkins []untappd.Checkin
+		wantErr  bool
+	}{
+		{"after a processed page", []untappd.Checkin{{CheckinID: 10, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"}}, false},
+		{"before any page", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []uint64
+			mockStore := newFailuresStorage(&storage.State{})
+			mockStore.UpdateLatestCheckinIDFunc = func(ctx context.Context, checkin untappd.Checkin) error {
+				stored = append(stored, checkin.CheckinID)
+				return nil
+			}
+
+			resynced := false
+			mockUntappd := &mockUntappdClient{
+				FetchCheckinsFunc: func(
+					ctx context.Context,
+					sinceID uint64,
+					checkinProcessor func(context.Context, []untappd.Checkin) error,
+				) error {
+					if tt.checkins != nil {
+						if err := checkinProcessor(ctx, tt.checkins); err != nil {
+							return err
+						}
+					}
+					return &untappd.RateLimitError{RetryAfter: time.Hour}
+				},
+				FetchHistoryFunc: func(
+					ctx context.Context,
+					maxID uint64,
+					pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+				) error {
+					resynced = true
+					return nil
+				},
+			}
+
+			err := runRecorder(context.Background(), mockStore, cfg, mockUntappd, &mockDownloader{}, &processor.Counts{})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("runRecorder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && exitCode(err) != exitRateLimited {
+				t.Errorf("expected exit code %d, got %d", exitRateLimited, exitCode(err))
+			}
+			if !tt.wantErr && !slices.Equal(stored, []uint64{10}) {
+				t.Errorf("expected the cursor to move to 10, got %v", stored)
+			}
+			// no calls left for the re-sync
+			if resynced {
+				t.Error("expected the re-sync to be skipped")
+			}
+		})
+	}
+}
+
+// mock storage keeping the state and the failures in memory
+type failuresStorage struct {
+	*storagetest.Storage
+	failures map[uint64]*storage.Failure
+}
+
+func newFailuresStorage(state *storage.State) *failuresStorage {
+	s := &failuresStorage{failures: make(map[uint64]*storage.Failure)}
+	s.Storage = &storagetest.Storage{
+		GetStateFunc: func(ctx context.Context) (*storage.State, error) {
+			return state, nil
+		},
+		UpdateStateFunc: func(ctx context.Context, update func(*storage.State) error) error {
+			return update(state)
+		},
+		UploadFailureFunc: func(ctx context.Context, failure *storage.Failure) error {
+			s.failures[failure.CheckinID] = failure
+			return nil
+		},
+		DownloadFailureFunc: func(ctx context.Context, source string, checkinID uint64) (*storage.Failure, error) {
+			if failure, ok := s.failures[checkinID]; ok {
+				return failure, nil
+			}
+			return nil, storage.ErrNotFound
+		},
+		ListFailuresFunc: func(ctx context.Context, source string) ([]uint64, error) {
+			return slices.Collect(maps.Keys(s.failures)), nil
+		},
+		DeleteFailureFunc: func(ctx context.Context, source string, checkinID uint64) error {
+			delete(s.failures, checkinID)
+			return nil
+		},
+	}
+	return s
+}
+
+func TestRunRetry(t *testing.T) {
+	mockStore := newFailuresStorage(&storage.State{})
+	mockStore.failures[20] = &storage.Failure{
+		CheckinID: 20,
+		Source:    storage.FailureSourceAPI,
+		Attempts:  1,
+		Checkin:   &untappd.Checkin{CheckinID: 20, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
+	}
+	// not due yet
+	mockStore.failures[30] = &storage.Failure{
+		CheckinID:     30,
+		Source:        storage.FailureSourceAPI,
+		NextAttemptAt: time.Now().Add(time.Hour),
+	}
+
+	var savedIDs []uint64
+	mockStore.UploadRecordFunc = func(ctx context.Context, record *storage.CheckinRecord) error {
+		savedIDs = append(savedIDs, record.CheckinID)
+		return nil
+	}
+
+	counts := &processor.Counts{}
+	err := runRetry(context.Background(), mockStore, &config.Config{NumWorkers: 1}, nil, &mockDownloader{}, counts)
+	if err != nil {
+		t.Fatalf("runRetry() error = %v", err)
+	}
+
+	if !slices.Equal(savedIDs, []uint64{20}) {
+		t.Errorf("expected checkin 20 to be saved, got %v", savedIDs)
+	}
+	if want := (processor.Counts{Succeeded: 1, Skipped: 1}); *counts != want {
+		t.Errorf("expected %v, got %v", want, *counts)
+	}
+	if _, ok := mockStore.failures[20]; ok || len(mockStore.failures) != 1 {
+		t.Errorf("expected only checkin 30 to be left, got %+v", mockStore.failures)
+	}
+}
+
+func TestSettledUpTo(t *testing.T) {
+	checkins := []untappd.Checkin{{CheckinID: 3}, {CheckinID: 2}, {CheckinID: 1}}
+
+	tests := []struct {
+		name    string
+		settled map[uint64]bool
+		want    uint64
+		wantOK  bool
+	}{
+		{"all settled", map[uint64]bool{3: true, 2: true, 1: true}, 3, true},
+		{"newest not settled", map[uint64]bool{2: true, 1: true}, 2, true},
+		{"gap", map[uint64]bool{3: true, 1: true}, 1, true},
+		{"oldest not settled", map[uint64]bool{3: true, 2: true}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := settledUpTo(checkins, tt.settled)
+			if ok != tt.wantOK || got.CheckinID != tt.want {
+				t.Errorf("settledUpTo() = %d, %v, want %d, %v", got.CheckinID, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
 }
 
 func TestRun(t *testing.T) {
@@ -235,7 +510,7 @@ func TestRun(t *testing.T) {
 
 	getLatestCheckinIDCalled := false
 
-	mockStore := &mockStorage{
+	mockStore := &storagetest.Storage{
 		GetLatestCheckinIDFunc: func(ctx context.Context) (uint64, error) {
 			getLatestCheckinIDCalled = true
 			return 123, nil
@@ -262,6 +537,7 @@ func TestRun(t *testing.T) {
 
 	if err := run(
 		context.Background(),
+		modeRecent,
 		mockStore,
 		mockUntappd,
 	); err != nil {
@@ -276,3 +552,619 @@ func TestRun(t *testing.T) {
 		t.Error("expected FetchCheckins to be called, but it was not")
 	}
 }
+
+func TestRunHistory(t *testing.T) {
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("R2_ACCOUNT_ID", "test-account-id")
+	t.Setenv("R2_ACCESS_KEY_ID", "test-key-id")
+	t.Setenv("R2_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("BUCKET_NAME", "test-bucket")
+	t.Setenv("NUM_WORKERS", "1")
+
+	var checkpoints []storage.HistoryProgress
+	var savedIDs []uint64
+
+	mockStore := &storagetest.Storage{
+		GetHistoryProgressFunc: func(ctx context.Context) (*storage.HistoryProgress, error) {
+			return &storage.HistoryProgress{MaxID: 200, Checkins: 50}, nil
+		},
+		UpdateHistoryProgressFunc: func(
+			ctx context.Context,
+			progress *storage.HistoryProgress,
+		) error {
+			checkpoints = append(checkpoints, *progress)
+			return nil
+		},
+		UpdateLatestCheckinIDFunc: func(ctx context.Context, checkin untappd.Checkin) error {
+			t.Error("expected the latest checkin to be left alone when resuming")
+			return nil
+		},
+		UploadRecordFunc: func(ctx context.Context, record *storage.CheckinRecord) error {
+			savedIDs = append(savedIDs, record.CheckinID)
+			return nil
+		},
+	}
+
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			if maxID != 200 {
+				t.Errorf("expected maxID to be 200, got %d", maxID)
+			}
+
+			if err := pageProcessor(ctx, []untappd.Checkin{
+				{CheckinID: 200, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
+			}, 150); err != nil {
+				return err
+			}
+			return pageProcessor(ctx, []untappd.Checkin{
+				{CheckinID: 150, CreatedAt: "Fri, 31 Oct 2025 00:00:00 +0000"},
+			}, 0)
+		},
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if err := runHistory(
+		context.Background(),
+		mockStore,
+		cfg,
+		mockUntappd,
+		&mockDownloader{},
+		&processor.Counts{},
+	); err != nil {
+		t.Fatalf("runHistory() error = %v", err)
+	}
+
+	if len(savedIDs) != 2 {
+		t.Errorf("expected 2 checkins to be saved, got %v", savedIDs)
+	}
+
+	if len(checkpoints) != 2 {
+		t.Fatalf("expected 2 checkpoints, got %d", len(checkpoints))
+	}
+	if checkpoints[0].MaxID != 150 || checkpoints[0].Complete {
+		t.Errorf("unexpected first checkpoint: %+v", checkpoints[0])
+	}
+	if checkpoints[1].MaxID != 0 || !checkpoints[1].Complete || checkpoints[1].Checkins != 52 {
+		t.Errorf("unexpected last checkpoint: %+v", checkpoints[1])
+	}
+}
+
+func TestRunHistory_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var savedIDs []uint64
+	mockStore := &storagetest.Storage{
+		UpdateHistoryProgressFunc: func(ctx context.Context, progress *storage.HistoryProgress) error {
+			t.Errorf("expected the checkpoint to stay on the page, got %+v", progress)
+			return nil
+		},
+		UpdateLatestCheckinIDFunc: func(ctx context.Context, checkin untappd.Checkin) error {
+			t.Errorf("expected the latest checkin to be left alone, got %d", checkin.CheckinID)
+			return nil
+		},
+		UploadRecordFunc: func(ctx context.Context, record *storage.CheckinRecord) error {
+			if ctx.Err() != nil {
+				t.Errorf("expected the checkin in flight to finish, got %v", ctx.Err())
+			}
+			savedIDs = append(savedIDs, record.CheckinID)
+			return nil
+		},
+	}
+
+	// stopped while the first checkin is in flight
+	downloader := &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			cancel()
+			return nil
+		},
+	}
+
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			return pageProcessor(ctx, []untappd.Checkin{
+				{CheckinID: 30, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
+				{CheckinID: 20, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
+				{CheckinID: 10, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"},
+			}, 10)
+		},
+	}
+
+	counts := &processor.Counts{}
+	cfg := &config.Config{NumWorkers: 1, ShutdownGracePeriod: time.Minute}
+	err := runHistory(ctx, mockStore, cfg, mockUntappd, downloader, counts)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the run to be cancelled, got %v", err)
+	}
+
+	if !slices.Equal(savedIDs, []uint64{30}) {
+		t.Errorf("expected only checkin 30 to be saved, got %v", savedIDs)
+	}
+	if want := (processor.Counts{Succeeded: 1, Skipped: 2}); *counts != want {
+		t.Errorf("expected %v, got %v", want, *counts)
+	}
+}
+
+func TestRunHistory_Complete(t *testing.T) {
+	mockStore := &storagetest.Storage{
+		GetHistoryProgressFunc: func(ctx context.Context) (*storage.HistoryProgress, error) {
+			return &storage.HistoryProgress{Complete: true}, nil
+		},
+	}
+
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			t.Error("expected FetchHistory not to be called once the history is archived")
+			return nil
+		},
+	}
+
+	err := runHistory(context.Background(), mockStore, &config.Config{}, mockUntappd, &mockDownloader{}, &processor.Counts{})
+	if err != nil {
+		t.Fatalf("runHistory() error = %v", err)
+	}
+}
+
+func TestRunHistory_RateLimited(t *testing.T) {
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			return &untappd.RateLimitError{RetryAfter: time.Hour}
+		},
+	}
+
+	err := runHistory(context.Background(), &storagetest.Storage{}, &config.Config{}, mockUntappd, &mockDownloader{}, &processor.Counts{})
+	if err != nil {
+		t.Fatalf("expected the rate limit to pause the history, got %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"config", fmt.Errorf("%w: missing UNTAPPD_ACCESS_TOKEN", errConfig), exitConfig},
+		{"checkins failed", fmt.Errorf("%w: 1 of 3", errCheckinsFailed), exitPartial},
+		{"interrupted", fmt.Errorf("%w: %w", errInterrupted, &net.OpError{Err: context.Canceled}), exitInterrupted},
+		{
+			"invalid token",
+			fmt.Errorf("fetch: %w", &untappd.APIError{
+				StatusCode: 500,
+				Meta:       untappd.Meta{ErrorType: "invalid_auth"},
+			}),
+			exitInvalidToken,
+		},
+		{"rate limited", &untappd.RateLimitError{RetryAfter: time.Hour}, exitRateLimited},
+		{"server error", &untappd.APIError{StatusCode: 503}, exitUnavailable},
+		{"timeout", context.DeadlineExceeded, exitUnavailable},
+		{"other", errors.New("boom"), exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_EndToEnd(t *testing.T) {
+	srv := untappdtest.NewServer()
+	defer srv.Close()
+
+	start := time.Date(2025, 11, 1, 18, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		c := srv.NewCheckin(uint64(i), start.AddDate(0, 0, i))
+		// the beer label stands in for the missing photo
+		if i == 3 {
+			c.Media.Items = nil
+		}
+		srv.AddCheckins(c)
+	}
+
+	root := t.TempDir()
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("UNTAPPD_BASE_URL", srv.BaseURL())
+	t.Setenv("STORAGE_PROVIDER", "local")
+	t.Setenv("LOCAL_STORAGE_PATH", root)
+	t.Setenv("NUM_WORKERS", "1")
+	t.Setenv("PLACEHOLDER_MODE", "label")
+	t.Setenv("ENRICH_BEERS", "true")
+	t.Setenv("ENRICH_BREWERIES", "true")
+
+	// a cold start walks the history, then the regular mode picks up new
+	// checkins from the latest one
+	if err := run(context.Background(), modeHistory, nil, nil); err != nil {
+		t.Fatalf("run() history error = %v", err)
+	}
+
+	next := srv.NewCheckin(4, start.AddDate(0, 0, 4))
+	next.Media.Items = append(next.Media.Items, untappd.MediaItem{
+		Photo: untappd.Photo{PhotoImgOg: srv.PhotoURL("4-2.jpg")},
+	})
+	next.Badges.Items = []untappd.Badge{{
+		BadgeID:    77,
+		BadgeName:  "Hopped Up",
+		BadgeImage: untappd.BadgeImage{Lg: srv.PhotoURL("badges/77.png")},
+	}}
+	srv.AddCheckins(next)
+	if err := run(context.Background(), modeRecent, nil, nil); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	for _, key := range []string{
+		"2025/11/02/1.jpg",
+		"2025/11/02/1.json",
+		"2025/11/02/WEBP/1.webp",
+		"2025/11/04/3.jpg",
+		"2025/11/05/4.jpg",
+		"2025/11/05/4.json",
+		"2025/11/05/4-2.jpg",
+		"2025/11/05/WEBP/4-2.webp",
+		"beers/40.json",
+		"breweries/400.json",
+		"breweries/400.jpeg",
+		"labels/30.jpeg",
+		"badges/77.png",
+		"badges/77/4.json",
+		"state.json",
+	} {
+		if _, err := os.Stat(filepath.Join(root, key)); err != nil {
+			t.Errorf("expected %s to be stored: %v", key, err)
+		}
+	}
+
+	store, err := storage.NewLocalClient(root)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	latest, err := store.GetLatestCheckinID(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get latest checkin: %v", err)
+	}
+	if latest != 4 {
+		t.Errorf("expected the latest checkin to be 4, got %d", latest)
+	}
+
+	state, err := store.GetState(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	if !state.History.Complete || state.Runs != 2 {
+		t.Errorf("expected the history and 2 runs in the state, got %+v", state)
+	}
+	if run := state.LastRun; run == nil || run.Mode != "recent" || run.Checkins != 1 || run.Error != "" {
+		t.Errorf("expected the last run to have recorded checkin 4, got %+v", run)
+	}
+
+	record, err := store.DownloadRecord(context.Background(), 4, start.AddDate(0, 0, 4))
+	if err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+	if record.Beer.Description != "Description of Beer 4" || record.Beer.RatingCount == 0 {
+		t.Errorf("expected the beer to be enriched, got %+v", record.Beer)
+	}
+	if record.Brewery.Type != "Micro Brewery" || record.Brewery.Profile != "breweries/400.json" ||
+		record.Brewery.Logo != "breweries/400.jpeg" {
+		t.Errorf("expected the brewery to be enriched, got %+v", record.Brewery)
+	}
+
+	if len(record.Badges) != 1 || record.Badges[0].Image != "badges/77.png" {
+		t.Errorf("expected the badge to be recorded, got %+v", record.Badges)
+	}
+
+	md, err := store.DownloadMetadata(context.Background(), "2025/11/05/4.jpg")
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if md.BreweryProfile != "breweries/400.json" {
+		t.Errorf("expected the photo to reference the brewery profile, got %q", md.BreweryProfile)
+	}
+}
+
+func TestRun_RetryExpiredPhoto(t *testing.T) {
+	srv := untappdtest.NewServer()
+	defer srv.Close()
+
+	// the CDN no longer serves the photo URL kept with the failure
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "expired", http.StatusForbidden)
+	}))
+	defer cdn.Close()
+
+	checkin := srv.NewCheckin(1, time.Date(2025, 11, 2, 18, 0, 0, 0, time.UTC))
+	srv.AddCheckins(checkin)
+	kept := checkin
+	kept.Media.Items = []untappd.MediaItem{{Photo: untappd.Photo{PhotoImgOg: cdn.URL + "/1.jpg"}}}
+
+	root := t.TempDir()
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("UNTAPPD_BASE_URL", srv.BaseURL())
+	t.Setenv("STORAGE_PROVIDER", "local")
+	t.Setenv("LOCAL_STORAGE_PATH", root)
+	t.Setenv("NUM_WORKERS", "1")
+
+	store, err := storage.NewLocalClient(root)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	err = store.UploadFailure(context.Background(), &storage.Failure{
+		CheckinID: 1,
+		Source:    storage.FailureSourceAPI,
+		Stage:     "photo",
+		Attempts:  1,
+		Checkin:   &kept,
+	})
+	if err != nil {
+		t.Fatalf("failed to store the failure: %v", err)
+	}
+
+	if err := run(context.Background(), modeRetry, nil, nil); err != nil {
+		t.Fatalf("run() retry error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "2025/11/02/1.jpg")); err != nil {
+		t.Errorf("expected the photo to be stored from its fresh URL: %v", err)
+	}
+	ids, err := store.ListFailures(context.Background(), storage.FailureSourceAPI)
+	if err != nil {
+		t.Fatalf("failed to list failures: %v", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected the failure to be cleared, got %v", ids)
+	}
+}
+
+func TestArchiveClient(t *testing.T) {
+	// two responses of 40 checkins, the second one fetching checkin 40
+	// again after it was edited
+	response := func(from, to uint64, comment string) *untappd.RawResponse {
+		var items []untappd.Checkin
+		for id := to; id >= from; id-- {
+			items = append(items, untappd.Checkin{CheckinID: id, CheckinComment: comment})
+		}
+		body, err := json.Marshal(map[string]any{"response": map[string]any{"items": items}})
+		if err != nil {
+			t.Fatalf("failed to encode response: %v", err)
+		}
+		return &untappd.RawResponse{Method: untappd.CheckinsMethod, Body: body}
+	}
+	responses := map[string]*untappd.RawResponse{
+		"1": response(1, 40, "first"),
+		"2": response(40, 80, "edited"),
+	}
+
+	listed, downloaded := 0, 0
+	mockStore := &storagetest.Storage{
+		ListRawResponsesFunc: func(ctx context.Context, method string) ([]string, error) {
+			listed++
+			return []string{"1", "2"}, nil
+		},
+		DownloadRawResponseFunc: func(ctx context.Context, key string) (*untappd.RawResponse, error) {
+			downloaded++
+			return responses[key], nil
+		},
+	}
+
+	ctx := context.Background()
+	archive, err := newArchiveClient(ctx, mockStore)
+	if err != nil {
+		t.Fatalf("newArchiveClient() error = %v", err)
+	}
+
+	var pages [][]untappd.Checkin
+	var nextMaxIDs []uint64
+	fetchPage := func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error {
+		pages = append(pages, checkins)
+		nextMaxIDs = append(nextMaxIDs, nextMaxID)
+		return nil
+	}
+	if err := archive.FetchHistory(ctx, 0, fetchPage); err != nil {
+		t.Fatalf("FetchHistory() error = %v", err)
+	}
+	// resuming from a checkpoint
+	if err := archive.FetchHistory(ctx, 31, fetchPage); err != nil {
+		t.Fatalf("FetchHistory() error = %v", err)
+	}
+
+	var recent []untappd.Checkin
+	err = archive.FetchCheckins(ctx, 75, func(ctx context.Context, checkins []untappd.Checkin) error {
+		recent = checkins
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FetchCheckins() error = %v", err)
+	}
+
+	// the archive is read once, the pages are served from memory
+	if listed != 1 || downloaded != 2 {
+		t.Errorf("expected the archive to be read once, got %d lists and %d downloads", listed, downloaded)
+	}
+	if len(pages) != 3 || len(pages[0]) != replayPageSize || pages[0][0].CheckinID != 80 {
+		t.Fatalf("expected the history to be served newest first by page, got %d pages", len(pages))
+	}
+	if !slices.Equal(nextMaxIDs, []uint64{31, 0, 0}) {
+		t.Errorf("expected the pages to end at checkin 31 then the first one, got %v", nextMaxIDs)
+	}
+	if len(pages[2]) != 30 || pages[2][0].CheckinID != 30 {
+		t.Errorf("expected the checkins before 31 from the checkpoint, got %d", len(pages[2]))
+	}
+	if pages[0][40].CheckinID != 40 || pages[0][40].CheckinComment != "edited" {
+		t.Errorf("expected checkin 40 as last fetched, got %+v", pages[0][40])
+	}
+	if len(recent) != 5 || recent[len(recent)-1].CheckinID != 76 {
+		t.Errorf("expected the checkins after 75, got %d", len(recent))
+	}
+}
+
+func TestRun_Replay(t *testing.T) {
+	srv := untappdtest.NewServer()
+
+	start := time.Date(2025, 11, 1, 18, 0, 0, 0, time.UTC)
+	for i := 1; i <= 2; i++ {
+		srv.AddCheckins(srv.NewCheckin(uint64(i), start.AddDate(0, 0, i)))
+	}
+
+	root := t.TempDir()
+	t.Setenv("UNTAPPD_ACCESS_TOKEN", "test-token")
+	t.Setenv("UNTAPPD_BASE_URL", srv.BaseURL())
+	t.Setenv("STORAGE_PROVIDER", "local")
+	t.Setenv("LOCAL_STORAGE_PATH", root)
+	t.Setenv("NUM_WORKERS", "1")
+	t.Setenv("ENRICH_BEERS", "true")
+	t.Setenv("ENRICH_BREWERIES", "true")
+
+	if err := run(context.Background(), modeHistory, nil, nil); err != nil {
+		t.Fatalf("run() history error = %v", err)
+	}
+
+	pages, err := filepath.Glob(filepath.Join(root, "raw", "user", "checkins", "*.json"))
+	if err != nil || len(pages) != 1 {
+		t.Fatalf("expected the history page to be archived, got %v (%v)", pages, err)
+	}
+	for _, dir := range []string{"beer/info/10", "brewery/info/100"} {
+		if _, err := os.Stat(filepath.Join(root, "raw", dir)); err != nil {
+			t.Errorf("expected raw/%s to be archived: %v", dir, err)
+		}
+	}
+
+	// the replay rebuilds what is lost without calling Untappd
+	srv.Close()
+	for _, key := range []string{"2025/11/02/1.json", "2025/11/02/WEBP/1.webp"} {
+		if err := os.Remove(filepath.Join(root, key)); err != nil {
+			t.Fatalf("failed to remove %s: %v", key, err)
+		}
+	}
+
+	if err := run(context.Background(), modeReplay, nil, nil); err != nil {
+		t.Fatalf("run() replay error = %v", err)
+	}
+
+	for _, key := range []string{"2025/11/02/1.json", "2025/11/02/WEBP/1.webp", "2025/11/03/2.json"} {
+		if _, err := os.Stat(filepath.Join(root, key)); err != nil {
+			t.Errorf("expected %s to be rebuilt: %v", key, err)
+		}
+	}
+
+	store, err := storage.NewLocalClient(root)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	record, err := store.DownloadRecord(context.Background(), 1, start.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+	if record.Comment != "Checkin 1" || record.Beer.Description != "Description of Beer 1" {
+		t.Errorf("expected the record to be rebuilt from the archive, got %+v", record)
+	}
+
+	// a missing photo can no longer be downloaded, the checkin is kept for
+	// a retry
+	if err := os.Remove(filepath.Join(root, "2025/11/03/2.jpg")); err != nil {
+		t.Fatalf("failed to remove photo: %v", err)
+	}
+	if err := run(context.Background(), modeReplay, nil, nil); !errors.Is(err, errCheckinsFailed) {
+		t.Fatalf("expected the replay to fail, got %v", err)
+	}
+	failure, err := store.DownloadFailure(context.Background(), storage.FailureSourceAPI, 2)
+	if err != nil {
+		t.Fatalf("expected the failure to be kept: %v", err)
+	}
+	if failure.Stage != "photo" || failure.Checkin == nil || failure.Checkin.CheckinID != 2 {
+		t.Errorf("unexpected failure: %+v", failure)
+	}
+}
+
+func TestSaveCheckin_MultiplePhotos(t *testing.T) {
+	checkin := untappd.Checkin{CheckinID: 54321, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"}
+	for _, u := range []string{"https://img/1.jpg", "https://img/2.jpg", "https://img/3.jpg"} {
+		checkin.Media.Items = append(checkin.Media.Items, untappd.MediaItem{
+			Photo: untappd.Photo{PhotoImgOg: u},
+		})
+	}
+
+	// first photo fully archived, second missing its webp, third missing
+	mockStore := &storagetest.Storage{
+		CheckinExistsFunc: func(
+			ctx context.Context,
+			checkinID uint64,
+			createdAt time.Time,
+			photo int,
+		) (bool, error) {
+			return photo < 3, nil
+		},
+		CheckinWEBPExistsFunc: func(
+			ctx context.Context,
+			checkinID uint64,
+			createdAt time.Time,
+			photo int,
+		) (bool, error) {
+			return photo == 1, nil
+		},
+	}
+
+	var downloaded []string
+	var converted []int
+	mockDownloader := &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			if metadata.Photo != 3 {
+				t.Errorf("expected only photo 3 to be downloaded, got %d", metadata.Photo)
+			}
+			downloaded = append(downloaded, photoURL)
+			return nil
+		},
+		DownloadAndSaveWEBPFunc: func(
+			ctx context.Context,
+			store storage.PhotoStore,
+			metadata *storage.CheckinMetadata,
+		) error {
+			converted = append(converted, metadata.Photo)
+			return nil
+		},
+	}
+
+	if err := saveCheckin(context.Background(), mockStore, &config.Config{}, checkin, mockDownloader, nil); err != nil {
+		t.Fatalf("saveCheckin() error = %v", err)
+	}
+
+	if len(downloaded) != 1 || downloaded[0] != "https://img/3.jpg" {
+		t.Errorf("expected the third photo to be downloaded, got %v", downloaded)
+	}
+	if len(converted) != 1 || converted[0] != 2 {
+		t.Errorf("expected the second photo to be converted, got %v", converted)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/enrich"
+	"github.com/smallwat3r/untappd-recorder/internal/photo"
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// checkins per page served by the archive, as when walking the history
+const replayPageSize = 50
+
+// rebuilds the photos, records and badge indexes from the archived API
+// responses, e.g. after a change of the metadata schema. Untappd is not
+// called, only the photos missing from the storage are downloaded.
+func runReplay(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	downloader photo.Downloader,
+	counts *processor.Counts,
+) error {
+	archive, err := newArchiveClient(ctx, store)
+	if err != nil {
+		return fmt.Errorf("failed to load the archived checkins: %w", err)
+	}
+	enricher := newEnricher(store, cfg, archive)
+
+	replayed := 0
+	err = archive.FetchHistory(ctx, 0, func(
+		ctx context.Context,
+		checkins []untappd.Checkin,
+		nextMaxID uint64,
+	) error {
+		result := processor.Process(ctx, checkins, cfg.NumWorkers, func(
+			ctx context.Context,
+			c untappd.Checkin,
+		) error {
+			log.Printf("Replaying checkin %d", c.CheckinID)
+			err := replayCheckin(ctx, store, cfg, c, downloader, enricher)
+			if err != nil {
+				log.Printf("failed to replay checkin %d: %v", c.CheckinID, err)
+				addFailure(ctx, store, c, err)
+			}
+			return err
+		}, processOptions(cfg)...)
+		counts.Add(result.Counts)
+		replayed += len(checkins)
+		return ctx.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay checkins: %w", err)
+	}
+
+	log.Printf("Replayed %d checkins\n", replayed)
+	return nil
+}
+
+func replayCheckin(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	checkin untappd.Checkin,
+	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+) error {
+	record, err := storage.RecordFromCheckin(checkin)
+	if err != nil {
+		return fmt.Errorf("failed to build record: %w", err)
+	}
+
+	// keep what the API responses don't have, such as the CSV fields or the
+	// tombstone of a deleted checkin
+	old, err := store.DownloadRecord(ctx, record.CheckinID, record.CreatedAt)
+	switch {
+	case err == nil:
+		record.MergeFrom(old)
+		record.DeletedAt = old.DeletedAt
+	case !errors.Is(err, storage.ErrNotFound):
+		return fmt.Errorf("failed to download record: %w", err)
+	}
+
+	return saveRecord(ctx, store, cfg, record, downloader, enricher, restampPhotos)
+}
+
+// rewrites the metadata of a stored photo. Placeholders are rendered again
+// and missing photos downloaded.
+func restampPhoto(
+	ctx context.Context,
+	store storage.PhotoStore,
+	cfg *config.Config,
+	record *storage.CheckinRecord,
+	photoURL string,
+	metadata *storage.CheckinMetadata,
+	downloader photo.Downloader,
+) error {
+	if photoURL == "" {
+		return downloader.DownloadAndSave(ctx, cfg, store, photoURL, metadata)
+	}
+
+	exists, err := store.CheckinExists(ctx, record.CheckinID, record.CreatedAt, metadata.Photo)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return downloader.DownloadAndSave(ctx, cfg, store, photoURL, metadata)
+	}
+
+	return downloader.RewriteMetadata(ctx, store, metadata)
+}
+
+// serves the checkins, beers and breweries of the archived API responses, as
+// the Untappd client would. The checkins are loaded once, the pages are served
+// from memory.
+type archiveClient struct {
+	store storage.RawStore
+	// all the archived checkins, newest first
+	checkins []untappd.Checkin
+}
+
+func newArchiveClient(ctx context.Context, store storage.RawStore) (*archiveClient, error) {
+	checkins, err := archivedCheckins(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveClient{store: store, checkins: checkins}, nil
+}
+
+// all the archived checkins, newest first. A checkin fetched several times is
+// served as last fetched.
+func archivedCheckins(ctx context.Context, store storage.RawStore) ([]untappd.Checkin, error) {
+	keys, err := store.ListRawResponses(ctx, untappd.CheckinsMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint64]untappd.Checkin)
+	for _, key := range keys {
+		raw, err := store.DownloadRawResponse(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		checkins, err := raw.Checkins()
+		if err != nil {
+			log.Printf("skipping archived response %s: %v", key, err)
+			continue
+		}
+		for _, checkin := range checkins {
+			byID[checkin.CheckinID] = checkin
+		}
+	}
+
+	checkins := make([]untappd.Checkin, 0, len(byID))
+	for _, checkin := range byID {
+		checkins = append(checkins, checkin)
+	}
+	slices.SortFunc(checkins, func(a, b untappd.Checkin) int {
+		switch {
+		case a.CheckinID > b.CheckinID:
+			return -1
+		case a.CheckinID < b.CheckinID:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return checkins, nil
+}
+
+func (c *archiveClient) FetchCheckins(
+	ctx context.Context,
+	sinceID uint64,
+	checkinProcessor func(context.Context, []untappd.Checkin) error,
+) error {
+	checkins := c.checkins
+	n := slices.IndexFunc(checkins, func(c untappd.Checkin) bool {
+		return c.CheckinID <= sinceID
+	})
+	if n >= 0 {
+		checkins = checkins[:n]
+	}
+	if len(checkins) == 0 {
+		return nil
+	}
+	return checkinProcessor(ctx, checkins)
+}
+
+func (c *archiveClient) FetchHistory(
+	ctx context.Context,
+	maxID uint64,
+	pageProcessor func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error,
+) error {
+	checkins := c.checkins
+
+	// max_id is exclusive, as on the API
+	if maxID != 0 {
+		n := slices.IndexFunc(checkins, func(c untappd.Checkin) bool {
+			return c.CheckinID < maxID
+		})
+		if n < 0 {
+			return nil
+		}
+		checkins = checkins[n:]
+	}
+
+	for start := 0; start < len(checkins); start += replayPageSize {
+		page := checkins[start:min(start+replayPageSize, len(checkins))]
+
+		var nextMaxID uint64
+		if start+len(page) < len(checkins) {
+			nextMaxID = page[len(page)-1].CheckinID
+		}
+		if err := pageProcessor(ctx, page, nextMaxID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the last archived response of the method, storage.ErrNotFound when there
+// is none. Only called for the beers and breweries without a cached profile,
+// so once per beer or brewery.
+func (c *archiveClient) latest(ctx context.Context, method string) (*untappd.RawResponse, error) {
+	keys, err := c.store.ListRawResponses(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no archived response for %s: %w", method, storage.ErrNotFound)
+	}
+	return c.store.DownloadRawResponse(ctx, keys[len(keys)-1])
+}
+
+func (c *archiveClient) FetchBeer(ctx context.Context, bid uint64) (*untappd.BeerInfo, error) {
+	raw, err := c.latest(ctx, "/beer/info/"+strconv.FormatUint(bid, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch beer %d: %w", bid, err)
+	}
+	return raw.Beer()
+}
+
+func (c *archiveClient) FetchBrewery(ctx context.Context, breweryID uint64) (*untappd.BreweryInfo, error) {
+	raw, err := c.latest(ctx, "/brewery/info/"+strconv.FormatUint(breweryID, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch brewery %d: %w", breweryID, err)
+	}
+	return raw.Brewery()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/enrich"
+	"github.com/smallwat3r/untappd-recorder/internal/failures"
+	"github.com/smallwat3r/untappd-recorder/internal/photo"
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// stops the walk through the history once past the re-sync window
+var errResyncWindowDone = errors.New("past the re-sync window")
+
+// re-fetches the checkins of the last RESYNC_DAYS days, which the latest
+// checkin cursor never sees again. Checkins edited since they were stored
+// are rewritten, and the stored ones Untappd no longer returns are marked
+// as deleted.
+func runResync(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	untappdClient untappd.UntappdClient,
+	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+	counts *processor.Counts,
+	now time.Time,
+) error {
+	since := now.AddDate(0, 0, -cfg.ResyncDays)
+
+	var checkins []untappd.Checkin
+	// only a walk covering the whole window can tell deleted checkins apart
+	complete := false
+
+	err := untappdClient.FetchHistory(ctx, 0, func(
+		ctx context.Context,
+		page []untappd.Checkin,
+		nextMaxID uint64,
+	) error {
+		for _, c := range page {
+			createdAt, err := time.Parse(time.RFC1123Z, c.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("parse checkin date %q: %w", c.CreatedAt, err)
+			}
+			if createdAt.Before(since) {
+				complete = true
+				return errResyncWindowDone
+			}
+			checkins = append(checkins, c)
+		}
+		complete = nextMaxID == 0
+		return nil
+	})
+	// the checkins fetched before the walk stopped are still compared
+	var fetchErr error
+	if err != nil && !errors.Is(err, errResyncWindowDone) {
+		fetchErr = fmt.Errorf("failed to re-sync checkins: %w", err)
+		complete = false
+	}
+
+	log.Printf("Re-syncing %d checkins from the last %d days\n", len(checkins), cfg.ResyncDays)
+
+	seen := make(map[uint64]bool, len(checkins))
+	for _, c := range checkins {
+		seen[c.CheckinID] = true
+	}
+
+	result := processor.Process(ctx, checkins, cfg.NumWorkers, func(ctx context.Context, c untappd.Checkin) error {
+		changed, err := resyncCheckin(ctx, store, cfg, c, downloader, enricher)
+		if err != nil {
+			log.Printf("failed to re-sync checkin %d: %v", c.CheckinID, err)
+			addFailure(ctx, store, c, err)
+			return err
+		}
+		if !changed {
+			return processor.ErrSkipped
+		}
+		return nil
+	}, processOptions(cfg)...)
+	counts.Add(result.Counts)
+
+	deleted := 0
+	// a stopped run did not compare all the checkins of the window
+	if complete && ctx.Err() == nil {
+		deleted, err = markDeleted(ctx, store, since, now, seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Re-sync done, %d checkins updated, %d marked as deleted\n", result.Succeeded, deleted)
+	return fetchErr
+}
+
+// stores the checkin again when it changed since it was stored, reports
+// whether it did
+func resyncCheckin(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	checkin untappd.Checkin,
+	downloader photo.Downloader,
+	enricher *enrich.Enricher,
+) (bool, error) {
+	record, err := storage.RecordFromCheckin(checkin)
+	if err != nil {
+		return false, fmt.Errorf("failed to build record: %w", err)
+	}
+
+	old, err := store.DownloadRecord(ctx, record.CheckinID, record.CreatedAt)
+	if errors.Is(err, storage.ErrNotFound) {
+		// never stored, e.g. a previous run failed on it
+		return true, saveRecord(ctx, store, cfg, record, downloader, enricher, keepPhotos)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to download record: %w", err)
+	}
+
+	if !record.ChangedFrom(old) {
+		return false, nil
+	}
+
+	log.Printf("Checkin %d changed on Untappd, updating it", record.CheckinID)
+	record.MergeFrom(old)
+	// the photos are stored again to refresh their embedded metadata
+	if err := saveRecord(ctx, store, cfg, record, downloader, enricher, refetchPhotos); err != nil {
+		return true, err
+	}
+
+	// removed photos, the first one is kept for the placeholder
+	for photo := max(len(record.PhotoURLs), 1) + 1; photo <= len(old.PhotoURLs); photo++ {
+		log.Printf("Photo %d of checkin %d was removed on Untappd, deleting it", photo, record.CheckinID)
+		if err := store.DeletePhoto(ctx, record.CheckinID, record.CreatedAt, photo); err != nil {
+			return true, failures.WithStage(failures.StagePhoto, fmt.Errorf("failed to delete photo %d: %w", photo, err))
+		}
+	}
+	return true, nil
+}
+
+// marks the stored checkins of the window Untappd did not return as deleted,
+// returns how many were
+func markDeleted(
+	ctx context.Context,
+	store storage.RecordStore,
+	since time.Time,
+	now time.Time,
+	seen map[uint64]bool,
+) (int, error) {
+	deleted := 0
+	for day := since.Truncate(24 * time.Hour); !day.After(now); day = day.AddDate(0, 0, 1) {
+		ids, err := store.ListRecords(ctx, day)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list records: %w", err)
+		}
+
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+
+			record, err := store.DownloadRecord(ctx, id, day)
+			if err != nil {
+				log.Printf("failed to download record of checkin %d: %v", id, err)
+				continue
+			}
+			// the first day of the window is only partly covered
+			if record.DeletedAt != nil || record.CreatedAt.Before(since) {
+				continue
+			}
+
+			log.Printf("Checkin %d no longer exists on Untappd, marking it as deleted", id)
+			deletedAt := now
+			record.DeletedAt = &deletedAt
+			if err := store.UploadRecord(ctx, record); err != nil {
+				log.Printf("failed to mark checkin %d as deleted: %v", id, err)
+				continue
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
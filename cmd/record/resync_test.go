@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+func TestRunResync(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	checkin := func(id uint64, createdAt time.Time, comment string) untappd.Checkin {
+		return untappd.Checkin{
+			CheckinID:      id,
+			CheckinComment: comment,
+			CreatedAt:      createdAt.Format(time.RFC1123Z),
+		}
+	}
+	unchanged := checkin(1, now.AddDate(0, 0, -1), "Nice")
+	edited := checkin(2, now.AddDate(0, 0, -2), "Nice")
+	deleted := checkin(3, now.AddDate(0, 0, -2).Add(time.Hour), "Gone")
+	// on the first day of the window, but before its start
+	older := checkin(4, now.AddDate(0, 0, -3).Add(-time.Hour), "Old")
+	missing := checkin(5, now.Add(-time.Hour), "Missed")
+
+	store, err := storage.NewLocalClient(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	for _, c := range []untappd.Checkin{unchanged, edited, deleted, older} {
+		record, err := storage.RecordFromCheckin(c)
+		if err != nil {
+			t.Fatalf("failed to build record: %v", err)
+		}
+		if err := store.UploadRecord(ctx, record); err != nil {
+			t.Fatalf("failed to upload record: %v", err)
+		}
+	}
+
+	edited.CheckinComment = "Even better the second time"
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			if maxID != 0 {
+				t.Errorf("expected the walk to start from the newest checkin, got %d", maxID)
+			}
+			return pageProcessor(ctx, []untappd.Checkin{missing, unchanged, edited, older}, older.CheckinID)
+		},
+	}
+
+	var saved []string
+	mockDownloader := &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			saved = append(saved, metadata.ID)
+			return nil
+		},
+	}
+
+	cfg := &config.Config{ResyncDays: 3, NumWorkers: 1}
+	counts := &processor.Counts{}
+	if err := runResync(ctx, store, cfg, mockUntappd, mockDownloader, nil, counts, now); err != nil {
+		t.Fatalf("runResync() error = %v", err)
+	}
+
+	if want := (processor.Counts{Succeeded: 2, Skipped: 1}); *counts != want {
+		t.Errorf("expected %v, got %v", want, *counts)
+	}
+
+	if len(saved) != 2 || saved[0] != "5" || saved[1] != "2" {
+		t.Errorf("expected the photos of checkins 5 and 2 to be saved, got %v", saved)
+	}
+
+	download := func(c untappd.Checkin) *storage.CheckinRecord {
+		createdAt, _ := time.Parse(time.RFC1123Z, c.CreatedAt)
+		record, err := store.DownloadRecord(ctx, c.CheckinID, createdAt)
+		if err != nil {
+			t.Fatalf("failed to read record %d: %v", c.CheckinID, err)
+		}
+		return record
+	}
+
+	if record := download(edited); record.Comment != "Even better the second time" || record.DeletedAt != nil {
+		t.Errorf("expected the edited checkin to be rewritten, got %+v", record)
+	}
+	if record := download(deleted); record.DeletedAt == nil || !record.DeletedAt.Equal(now) {
+		t.Errorf("expected the deleted checkin to be tombstoned, got %v", record.DeletedAt)
+	}
+	for _, c := range []untappd.Checkin{unchanged, older, missing} {
+		if record := download(c); record.DeletedAt != nil {
+			t.Errorf("expected checkin %d not to be tombstoned", c.CheckinID)
+		}
+	}
+}
+
+func TestRunResync_IncompleteWalk(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	store, err := storage.NewLocalClient(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	stored := &storage.CheckinRecord{CheckinID: 1, CreatedAt: now.AddDate(0, 0, -1)}
+	if err := store.UploadRecord(ctx, stored); err != nil {
+		t.Fatalf("failed to upload record: %v", err)
+	}
+
+	// the rate limit stops the walk after a first page, before it reaches
+	// the stored checkin
+	missing := untappd.Checkin{CheckinID: 2, CreatedAt: now.Add(-time.Hour).Format(time.RFC1123Z)}
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			if err := pageProcessor(ctx, []untappd.Checkin{missing}, missing.CheckinID); err != nil {
+				return err
+			}
+			return untappd.ErrRateLimited
+		},
+	}
+
+	cfg := &config.Config{ResyncDays: 3}
+	counts := &processor.Counts{}
+	err = runResync(ctx, store, cfg, mockUntappd, &mockDownloader{}, nil, counts, now)
+	if !errors.Is(err, untappd.ErrRateLimited) {
+		t.Fatalf("expected the rate limit to be returned, got %v", err)
+	}
+
+	if counts.Succeeded != 1 {
+		t.Errorf("expected the fetched checkin to be stored, got %v", *counts)
+	}
+	if _, err := store.DownloadRecord(ctx, missing.CheckinID, now.Add(-time.Hour)); err != nil {
+		t.Errorf("expected the fetched checkin to be stored: %v", err)
+	}
+
+	record, err := store.DownloadRecord(ctx, 1, stored.CreatedAt)
+	if err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+	if record.DeletedAt != nil {
+		t.Error("expected no tombstone when the window was not fully walked")
+	}
+}
+
+func TestRunResync_Failure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	store, err := storage.NewLocalClient(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	// never stored, its photo fails to download
+	missing := untappd.Checkin{CheckinID: 1, CreatedAt: now.Add(-time.Hour).Format(time.RFC1123Z)}
+	missing.Media.Items = []untappd.MediaItem{{Photo: untappd.Photo{PhotoImgOg: "http://example.com/1.jpg"}}}
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			return pageProcessor(ctx, []untappd.Checkin{missing}, 0)
+		},
+	}
+	mockDownloader := &mockDownloader{
+		DownloadAndSaveFunc: func(
+			ctx context.Context,
+			cfg *config.Config,
+			store storage.PhotoStore,
+			photoURL string,
+			metadata *storage.CheckinMetadata,
+		) error {
+			return errors.New("photo expired")
+		},
+	}
+
+	cfg := &config.Config{ResyncDays: 3, NumWorkers: 1}
+	counts := &processor.Counts{}
+	if err := runResync(ctx, store, cfg, mockUntappd, mockDownloader, nil, counts, now); err != nil {
+		t.Fatalf("runResync() error = %v", err)
+	}
+
+	if want := (processor.Counts{Failed: 1}); *counts != want {
+		t.Errorf("expected %v, got %v", want, *counts)
+	}
+	failure, err := store.DownloadFailure(ctx, storage.FailureSourceAPI, 1)
+	if err != nil {
+		t.Fatalf("expected the failure to be kept: %v", err)
+	}
+	if failure.Stage != "photo" || failure.Checkin == nil {
+		t.Errorf("unexpected failure: %+v", failure)
+	}
+}
+
+func TestRunResync_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	store, err := storage.NewLocalClient(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	checkins := []untappd.Checkin{
+		{CheckinID: 2, CreatedAt: now.Add(-time.Hour).Format(time.RFC1123Z)},
+		{CheckinID: 1, CreatedAt: now.Add(-2 * time.Hour).Format(time.RFC1123Z)},
+	}
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			err := pageProcessor(ctx, checkins, 0)
+			// stopped once the window is fetched
+			cancel()
+			return err
+		},
+	}
+
+	cfg := &config.Config{ResyncDays: 3, NumWorkers: 1}
+	counts := &processor.Counts{}
+	if err := runResync(ctx, store, cfg, mockUntappd, &mockDownloader{}, nil, counts, now); err != nil {
+		t.Fatalf("runResync() error = %v", err)
+	}
+
+	if want := (processor.Counts{Skipped: 2}); *counts != want {
+		t.Errorf("expected the checkins to be left alone, got %v", *counts)
+	}
+	if ids, _ := store.ListRecords(context.Background(), now); len(ids) != 0 {
+		t.Errorf("expected no record to be stored, got %v", ids)
+	}
+}
+
+func TestRunResync_FewerPhotos(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	root := t.TempDir()
+	store, err := storage.NewLocalClient(root)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	checkin := untappd.Checkin{CheckinID: 1, CreatedAt: now.Add(-time.Hour).Format(time.RFC1123Z)}
+	for _, name := range []string{"1.jpg", "1-2.jpg", "1-3.jpg"} {
+		checkin.Media.Items = append(checkin.Media.Items, untappd.MediaItem{
+			Photo: untappd.Photo{PhotoImgOg: "http://example.com/" + name},
+		})
+	}
+	record, err := storage.RecordFromCheckin(checkin)
+	if err != nil {
+		t.Fatalf("failed to build record: %v", err)
+	}
+	if err := store.UploadRecord(ctx, record); err != nil {
+		t.Fatalf("failed to upload record: %v", err)
+	}
+	for i := range record.PhotoURLs {
+		md := record.Metadata()
+		md.Photo = i + 1
+		if err := store.UploadJPG(ctx, []byte("jpg"), md); err != nil {
+			t.Fatalf("failed to upload photo: %v", err)
+		}
+		if err := store.UploadWEBP(ctx, []byte("webp"), md); err != nil {
+			t.Fatalf("failed to upload photo: %v", err)
+		}
+	}
+
+	// two of the photos were removed on Untappd
+	checkin.Media.Items = checkin.Media.Items[:1]
+	mockUntappd := &mockUntappdClient{
+		FetchHistoryFunc: func(
+			ctx context.Context,
+			maxID uint64,
+			pageProcessor func(context.Context, []untappd.Checkin, uint64) error,
+		) error {
+			return pageProcessor(ctx, []untappd.Checkin{checkin}, 0)
+		},
+	}
+
+	cfg := &config.Config{ResyncDays: 3, NumWorkers: 1}
+	if err := runResync(ctx, store, cfg, mockUntappd, &mockDownloader{}, nil, &processor.Counts{}, now); err != nil {
+		t.Fatalf("runResync() error = %v", err)
+	}
+
+	day := filepath.Join(root, "2025", "11", "10")
+	for _, name := range []string{"1-2.jpg", "1-3.jpg", "WEBP/1-2.webp", "WEBP/1-3.webp"} {
+		if _, err := os.Stat(filepath.Join(day, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"1.jpg", "WEBP/1.webp"} {
+		if _, err := os.Stat(filepath.Join(day, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
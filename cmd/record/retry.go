@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/failures"
+	"github.com/smallwat3r/untappd-recorder/internal/photo"
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// stops fetching the history once the page of a checkin is fetched
+var errRefetched = errors.New("checkin fetched again")
+
+// stores again the checkins which failed in previous runs, from the input
+// kept under failures/. The ones still failing are retried later, with a
+// growing delay.
+func runRetry(
+	ctx context.Context,
+	store storage.Storage,
+	cfg *config.Config,
+	untappdClient untappd.UntappdClient,
+	downloader photo.Downloader,
+	counts *processor.Counts,
+) error {
+	enricher := newEnricher(store, cfg, untappdClient)
+
+	result, err := failures.Retry(
+		ctx,
+		store,
+		storage.FailureSourceAPI,
+		cfg.NumWorkers,
+		time.Now().UTC(),
+		func(ctx context.Context, failure *storage.Failure) error {
+			if failure.Checkin == nil {
+				return fmt.Errorf("no checkin kept for checkin %d", failure.CheckinID)
+			}
+
+			// the photo URLs kept with the checkin may have expired
+			if failure.Stage == failures.StagePhoto {
+				checkin, err := refetchCheckin(ctx, untappdClient, *failure.Checkin)
+				if err != nil {
+					return failures.WithStage(failures.StagePhoto, fmt.Errorf("failed to fetch checkin %d again: %w", failure.CheckinID, err))
+				}
+				failure.Checkin = &checkin
+			}
+
+			return saveCheckin(ctx, store, cfg, *failure.Checkin, downloader, enricher)
+		},
+		processOptions(cfg)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to retry checkins: %w", err)
+	}
+	counts.Add(result.Counts)
+
+	log.Printf("Retried %d failed checkins\n", result.Succeeded+result.Failed)
+	return nil
+}
+
+// fetches the checkin again from the page of the history it is on, for
+// fresh photo URLs. The checkin is returned as is when Untappd no longer
+// has it.
+func refetchCheckin(
+	ctx context.Context,
+	untappdClient untappd.UntappdClient,
+	checkin untappd.Checkin,
+) (untappd.Checkin, error) {
+	fresh, found := checkin, false
+	err := untappdClient.FetchHistory(ctx, checkin.CheckinID+1, func(
+		ctx context.Context,
+		page []untappd.Checkin,
+		nextMaxID uint64,
+	) error {
+		for _, c := range page {
+			if c.CheckinID == checkin.CheckinID {
+				fresh, found = c, true
+			}
+		}
+		return errRefetched
+	})
+	if err != nil && !errors.Is(err, errRefetched) {
+		return checkin, err
+	}
+
+	if !found {
+		log.Printf("Checkin %d no longer returned by Untappd, retrying it as kept", checkin.CheckinID)
+	}
+	return fresh, nil
+}
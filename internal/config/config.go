@@ -1,18 +1,59 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
 	"github.com/caarlos0/env/v6"
 )
 
+// storage providers which can be selected with STORAGE_PROVIDER
+const (
+	ProviderR2    = "r2"
+	ProviderS3    = "s3"
+	ProviderLocal = "local"
+)
+
+// photos used for checkins without one, selected with PLACEHOLDER_MODE
+const (
+	// the file at PLACEHOLDER_PHOTO_PATH
+	PlaceholderStatic = "static"
+	// the archived label of the beer, falling back to the static placeholder
+	PlaceholderLabel = "label"
+	// a card rendered with the details of the checkin
+	PlaceholderCard = "card"
+)
+
 type Config struct {
-	UntappdAccessToken   string `env:"UNTAPPD_ACCESS_TOKEN,required"`
-	R2AccountID          string `env:"R2_ACCOUNT_ID,required"`
-	R2AccessKeyID        string `env:"R2_ACCESS_KEY_ID,required"`
-	R2AccessKeySecret    string `env:"R2_SECRET_ACCESS_KEY"`
-	AWSRegion            string `env:"AWS_REGION"`
-	BucketName           string `env:"BUCKET_NAME,required"`
-	NumWorkers           int    `env:"NUM_WORKERS,required"          envDefault:"4"`
-	PlaceholderPhotoPath string `env:"PLACEHOLDER_PHOTO_PATH"        envDefault:"img/missing.jpg"`
+	UntappdAccessToken   string        `env:"UNTAPPD_ACCESS_TOKEN,required"`
+	UntappdBaseURL       string        `env:"UNTAPPD_BASE_URL"              envDefault:"https://api.untappd.com/v4"`
+	UntappdMaxRetries    int           `env:"UNTAPPD_MAX_RETRIES"           envDefault:"3"`
+	UntappdMaxWait       time.Duration `env:"UNTAPPD_MAX_WAIT"              envDefault:"1m"`
+	StorageProvider      string        `env:"STORAGE_PROVIDER"`
+	R2AccountID          string        `env:"R2_ACCOUNT_ID"`
+	R2AccessKeyID        string        `env:"R2_ACCESS_KEY_ID"`
+	R2AccessKeySecret    string        `env:"R2_SECRET_ACCESS_KEY"`
+	AWSRegion            string        `env:"AWS_REGION"`
+	S3Endpoint           string        `env:"S3_ENDPOINT"`
+	S3UsePathStyle       bool          `env:"S3_USE_PATH_STYLE"`
+	S3SigningRegion      string        `env:"S3_SIGNING_REGION"`
+	S3CABundle           string        `env:"S3_CA_BUNDLE"`
+	S3AccessKeyID        string        `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey    string        `env:"S3_SECRET_ACCESS_KEY"`
+	BucketName           string        `env:"BUCKET_NAME"`
+	LocalStoragePath     string        `env:"LOCAL_STORAGE_PATH"`
+	EnrichBeers          bool          `env:"ENRICH_BEERS"                  envDefault:"false"`
+	EnrichBreweries      bool          `env:"ENRICH_BREWERIES"              envDefault:"false"`
+	NumWorkers           int           `env:"NUM_WORKERS,required"          envDefault:"4"`
+	FailFast             bool          `env:"FAIL_FAST"                     envDefault:"false"`
+	ShutdownGracePeriod  time.Duration `env:"SHUTDOWN_GRACE_PERIOD"         envDefault:"20s"`
+	RunTimeout           time.Duration `env:"RUN_TIMEOUT"                   envDefault:"0"`
+	ResyncDays           int           `env:"RESYNC_DAYS"                   envDefault:"0"`
+	ArchiveRaw           bool          `env:"ARCHIVE_RAW"                   envDefault:"true"`
+	PlaceholderPhotoPath string        `env:"PLACEHOLDER_PHOTO_PATH"        envDefault:"img/missing.jpg"`
+	PlaceholderMode      string        `env:"PLACEHOLDER_MODE"              envDefault:"card"`
 }
 
 func Load() (*Config, error) {
@@ -20,5 +61,104 @@ func Load() (*Config, error) {
 	if err := env.Parse(&cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// returns the configured storage provider. When STORAGE_PROVIDER is not set
+// it is inferred from the other settings, so existing deployments keep working.
+func (c *Config) Provider() string {
+	switch {
+	case c.StorageProvider != "":
+		return c.StorageProvider
+	case c.LocalStoragePath != "":
+		return ProviderLocal
+	case c.R2AccountID != "":
+		return ProviderR2
+	case c.AWSRegion != "" || c.S3Endpoint != "":
+		return ProviderS3
+	default:
+		return ""
+	}
+}
+
+// returns the region requests to S3 are signed for
+func (c *Config) S3Region() string {
+	switch {
+	case c.S3SigningRegion != "":
+		return c.S3SigningRegion
+	case c.AWSRegion != "":
+		return c.AWSRegion
+	default:
+		// S3 compatible servers generally accept any region
+		return "us-east-1"
+	}
+}
+
+// checks the settings are valid, and the ones required by the selected
+// storage provider are set
+func (c *Config) Validate() error {
+	var errs []error
+	if c.UntappdBaseURL != "" {
+		if u, err := url.Parse(c.UntappdBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("UNTAPPD_BASE_URL %q is not a valid URL", c.UntappdBaseURL))
+		}
+	}
+
+	if c.ResyncDays < 0 {
+		errs = append(errs, fmt.Errorf("RESYNC_DAYS must not be negative, got %d", c.ResyncDays))
+	}
+
+	if c.ShutdownGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_GRACE_PERIOD must not be negative, got %s", c.ShutdownGracePeriod))
+	}
+	if c.RunTimeout < 0 {
+		errs = append(errs, fmt.Errorf("RUN_TIMEOUT must not be negative, got %s", c.RunTimeout))
+	}
+
+	switch c.PlaceholderMode {
+	case "", PlaceholderStatic, PlaceholderLabel, PlaceholderCard:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown placeholder mode %q, expected one of %q, %q or %q",
+			c.PlaceholderMode, PlaceholderStatic, PlaceholderLabel, PlaceholderCard,
+		))
+	}
+
+	require := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required for the %q storage provider", name, c.Provider()))
+		}
+	}
+
+	switch c.Provider() {
+	case ProviderR2:
+		require("R2_ACCOUNT_ID", c.R2AccountID)
+		require("R2_ACCESS_KEY_ID", c.R2AccessKeyID)
+		require("R2_SECRET_ACCESS_KEY", c.R2AccessKeySecret)
+		require("BUCKET_NAME", c.BucketName)
+	case ProviderS3:
+		require("BUCKET_NAME", c.BucketName)
+		if c.S3Endpoint == "" {
+			require("AWS_REGION", c.AWSRegion)
+		} else if u, err := url.Parse(c.S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("S3_ENDPOINT %q is not a valid URL", c.S3Endpoint))
+		}
+		if (c.S3AccessKeyID == "") != (c.S3SecretAccessKey == "") {
+			errs = append(errs, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together"))
+		}
+	case ProviderLocal:
+		require("LOCAL_STORAGE_PATH", c.LocalStoragePath)
+	case "":
+		errs = append(errs, errors.New("no storage provider configured, set STORAGE_PROVIDER"))
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown storage provider %q, expected one of %q, %q or %q",
+			c.StorageProvider, ProviderR2, ProviderS3, ProviderLocal,
+		))
+	}
+
+	return errors.Join(errs...)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -21,6 +22,11 @@ func TestLoad(t *testing.T) {
 			t.Errorf("expected BucketName to be 'test_bucket_name', got %s", cfg.BucketName)
 		}
 
+		// enrichment costs API calls, it is opt-in
+		if cfg.EnrichBeers || cfg.EnrichBreweries {
+			t.Errorf("expected enrichment to be disabled by default, got %v and %v", cfg.EnrichBeers, cfg.EnrichBreweries)
+		}
+
 		if cfg.UntappdAccessToken != "test_token" {
 			t.Errorf(
 				"expected UntappdAccessToken to be 'test_token', got %s",
@@ -38,3 +44,127 @@ func TestLoad(t *testing.T) {
 		}
 	})
 }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "r2 inferred from account id",
+			cfg: Config{
+				R2AccountID:       "account",
+				R2AccessKeyID:     "key",
+				R2AccessKeySecret: "secret",
+				BucketName:        "bucket",
+			},
+			want: ProviderR2,
+		},
+		{
+			name:    "r2 missing credentials",
+			cfg:     Config{StorageProvider: ProviderR2, R2AccountID: "account", BucketName: "bucket"},
+			want:    ProviderR2,
+			wantErr: true,
+		},
+		{
+			name: "s3 without any r2 settings",
+			cfg:  Config{AWSRegion: "eu-west-2", BucketName: "bucket"},
+			want: ProviderS3,
+		},
+		{
+			name: "s3 compatible endpoint without region",
+			cfg: Config{
+				StorageProvider:   ProviderS3,
+				S3Endpoint:        "https://minio.local:9000",
+				S3AccessKeyID:     "key",
+				S3SecretAccessKey: "secret",
+				BucketName:        "bucket",
+			},
+			want: ProviderS3,
+		},
+		{
+			name:    "s3 missing region and endpoint",
+			cfg:     Config{StorageProvider: ProviderS3, BucketName: "bucket"},
+			want:    ProviderS3,
+			wantErr: true,
+		},
+		{
+			name:    "s3 invalid endpoint",
+			cfg:     Config{S3Endpoint: "minio.local:9000", BucketName: "bucket"},
+			want:    ProviderS3,
+			wantErr: true,
+		},
+		{
+			name: "s3 partial static credentials",
+			cfg: Config{
+				AWSRegion:     "eu-west-2",
+				S3AccessKeyID: "key",
+				BucketName:    "bucket",
+			},
+			want:    ProviderS3,
+			wantErr: true,
+		},
+		{
+			name: "local inferred from path",
+			cfg:  Config{LocalStoragePath: "/tmp/archive"},
+			want: ProviderLocal,
+		},
+		{
+			name:    "local missing path",
+			cfg:     Config{StorageProvider: ProviderLocal},
+			want:    ProviderLocal,
+			wantErr: true,
+		},
+		{
+			name:    "unknown provider",
+			cfg:     Config{StorageProvider: "gcs"},
+			want:    "gcs",
+			wantErr: true,
+		},
+		{
+			name: "label placeholder",
+			cfg:  Config{LocalStoragePath: "/tmp/archive", PlaceholderMode: PlaceholderLabel},
+			want: ProviderLocal,
+		},
+		{
+			name:    "unknown placeholder mode",
+			cfg:     Config{LocalStoragePath: "/tmp/archive", PlaceholderMode: "blank"},
+			want:    ProviderLocal,
+			wantErr: true,
+		},
+		{
+			name:    "negative re-sync window",
+			cfg:     Config{LocalStoragePath: "/tmp/archive", ResyncDays: -1},
+			want:    ProviderLocal,
+			wantErr: true,
+		},
+		{
+			name:    "negative run timeout",
+			cfg:     Config{LocalStoragePath: "/tmp/archive", RunTimeout: -time.Second},
+			want:    ProviderLocal,
+			wantErr: true,
+		},
+		{
+			name:    "no provider",
+			cfg:     Config{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.Provider(); got != tt.want {
+				t.Errorf("expected provider %q, got %q", tt.want, got)
+			}
+			err := tt.cfg.Validate()
+			if tt.wantErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
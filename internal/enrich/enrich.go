@@ -0,0 +1,172 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/photo"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"golang.org/x/sync/singleflight"
+)
+
+// adds the details the checkins endpoint leaves out to the records, from the
+// Untappd info endpoints. Results are cached in storage so each beer and
+// brewery costs a single API call. Safe for concurrent use.
+type Enricher struct {
+	store  storage.ProfileStore
+	client untappd.UntappdClient
+	group  singleflight.Group
+
+	// downloads the beer labels and brewery logos
+	fetch func(ctx context.Context, url string) ([]byte, error)
+
+	// set once the rate limit is hit, the remaining records of the run are
+	// only enriched from the cache
+	rateLimited atomic.Bool
+}
+
+func New(store storage.ProfileStore, client untappd.UntappdClient) *Enricher {
+	return &Enricher{store: store, client: client, fetch: photo.Fetch}
+}
+
+// enriches the record with the details of its beer
+func (e *Enricher) Beer(ctx context.Context, record *storage.CheckinRecord) error {
+	if record.Beer.BID == 0 {
+		return nil
+	}
+
+	v, err, _ := e.group.Do("beer:"+strconv.FormatUint(record.Beer.BID, 10), func() (any, error) {
+		return e.beer(ctx, record.Beer.BID)
+	})
+	if err != nil {
+		return err
+	}
+
+	record.ApplyBeer(v.(*storage.BeerProfile))
+	return nil
+}
+
+func (e *Enricher) beer(ctx context.Context, bid uint64) (*storage.BeerProfile, error) {
+	profile, err := e.store.DownloadBeer(ctx, bid)
+	switch {
+	case err == nil:
+		if profile.LabelKey != "" || profile.ArchiveLabelURL() == "" {
+			return profile, nil
+		}
+	case errors.Is(err, storage.ErrNotFound):
+		if err := e.checkRateLimit(); err != nil {
+			return nil, err
+		}
+		info, err := e.client.FetchBeer(ctx, bid)
+		if err != nil {
+			return nil, e.apiError(err)
+		}
+		profile = storage.BeerProfileFromInfo(info, time.Now().UTC())
+	default:
+		return nil, fmt.Errorf("failed to read cached beer %d: %w", bid, err)
+	}
+
+	// like the brewery logos, a missing label is retried on a later run
+	if key := profile.LabelArchiveKey(); key != "" {
+		if err := e.saveImage(ctx, key, profile.ArchiveLabelURL(), e.store.UploadLabel); err != nil {
+			log.Printf("failed to archive label of beer %d: %v", bid, err)
+		} else {
+			profile.LabelKey = key
+		}
+	}
+
+	if err := e.store.UploadBeer(ctx, profile); err != nil {
+		return nil, fmt.Errorf("failed to cache beer %d: %w", bid, err)
+	}
+
+	return profile, nil
+}
+
+// enriches the record with the details of its brewery, archiving the
+// brewery logo on the way
+func (e *Enricher) Brewery(ctx context.Context, record *storage.CheckinRecord) error {
+	if record.Brewery.BreweryID == 0 {
+		return nil
+	}
+
+	v, err, _ := e.group.Do("brewery:"+strconv.FormatUint(record.Brewery.BreweryID, 10), func() (any, error) {
+		return e.brewery(ctx, record.Brewery.BreweryID)
+	})
+	if err != nil {
+		return err
+	}
+
+	record.ApplyBrewery(v.(*storage.BreweryProfile))
+	return nil
+}
+
+func (e *Enricher) brewery(ctx context.Context, breweryID uint64) (*storage.BreweryProfile, error) {
+	profile, err := e.store.DownloadBrewery(ctx, breweryID)
+	switch {
+	case err == nil:
+		if profile.LogoKey != "" || profile.LogoURL == "" {
+			return profile, nil
+		}
+	case errors.Is(err, storage.ErrNotFound):
+		if err := e.checkRateLimit(); err != nil {
+			return nil, err
+		}
+		info, err := e.client.FetchBrewery(ctx, breweryID)
+		if err != nil {
+			return nil, e.apiError(err)
+		}
+		profile = storage.BreweryProfileFromInfo(info, time.Now().UTC())
+	default:
+		return nil, fmt.Errorf("failed to read cached brewery %d: %w", breweryID, err)
+	}
+
+	// a missing logo does not fail the profile, it is retried from the cached
+	// profile on a later run without calling the API again
+	if key := profile.LogoArchiveKey(); key != "" {
+		if err := e.saveImage(ctx, key, profile.LogoURL, e.store.UploadBreweryLogo); err != nil {
+			log.Printf("failed to archive logo of brewery %d: %v", breweryID, err)
+		} else {
+			profile.LogoKey = key
+		}
+	}
+
+	if err := e.store.UploadBrewery(ctx, profile); err != nil {
+		return nil, fmt.Errorf("failed to cache brewery %d: %w", breweryID, err)
+	}
+
+	return profile, nil
+}
+
+func (e *Enricher) saveImage(
+	ctx context.Context,
+	key string,
+	imageURL string,
+	upload func(ctx context.Context, key string, b []byte) error,
+) error {
+	b, err := e.fetch(ctx, imageURL)
+	if err != nil {
+		return err
+	}
+	return upload(ctx, key, b)
+}
+
+func (e *Enricher) checkRateLimit() error {
+	if e.rateLimited.Load() {
+		return untappd.ErrRateLimited
+	}
+	return nil
+}
+
+// records the rate limit, so the next records skip the API
+func (e *Enricher) apiError(err error) error {
+	if errors.Is(err, untappd.ErrRateLimited) && !e.rateLimited.Swap(true) {
+		log.Printf("untappd API rate limit reached, skipping enrichment: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockUntappdClient struct {
+	untappd.UntappdClient
+	FetchBeerFunc    func(ctx context.Context, bid uint64) (*untappd.BeerInfo, error)
+	FetchBreweryFunc func(ctx context.Context, breweryID uint64) (*untappd.BreweryInfo, error)
+}
+
+func (m *mockUntappdClient) FetchBeer(ctx context.Context, bid uint64) (*untappd.BeerInfo, error) {
+	return m.FetchBeerFunc(ctx, bid)
+}
+
+func (m *mockUntappdClient) FetchBrewery(
+	ctx context.Context,
+	breweryID uint64,
+) (*untappd.BreweryInfo, error) {
+	return m.FetchBreweryFunc(ctx, breweryID)
+}
+
+func TestEnricher_Beer(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewLocalClient(root)
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	client := &mockUntappdClient{
+		FetchBeerFunc: func(ctx context.Context, bid uint64) (*untappd.BeerInfo, error) {
+			calls.Add(1)
+			return &untappd.BeerInfo{
+				BID:             bid,
+				BeerName:        "Saison Dupont",
+				BeerIBU:         30,
+				BeerDescription: "A classic farmhouse ale",
+				BeerLabel:       "https://labels/1.jpeg",
+				RatingScore:     3.9,
+				RatingCount:     120000,
+			}, nil
+		},
+	}
+
+	e := New(store, client)
+	e.fetch = func(ctx context.Context, url string) ([]byte, error) {
+		assert.Equal(t, "https://labels/1.jpeg", url)
+		return []byte("jpeg"), nil
+	}
+
+	// concurrent checkins of the same beer share a single call
+	var wg sync.WaitGroup
+	records := make([]*storage.CheckinRecord, 5)
+	for i := range records {
+		records[i] = &storage.CheckinRecord{Beer: storage.BeerRecord{BID: 42}}
+		wg.Add(1)
+		go func(r *storage.CheckinRecord) {
+			defer wg.Done()
+			assert.NoError(t, e.Beer(context.Background(), r))
+		}(records[i])
+	}
+	wg.Wait()
+
+	for _, r := range records {
+		assert.Equal(t, "A classic farmhouse ale", r.Beer.Description)
+		assert.Equal(t, 30.0, r.Beer.IBU)
+		assert.Equal(t, 3.9, r.Beer.GlobalRatingScore)
+		assert.Equal(t, 120000, r.Beer.RatingCount)
+		assert.Equal(t, "https://labels/1.jpeg", r.Beer.LabelURL)
+		assert.Equal(t, "labels/42.jpeg", r.Beer.Label)
+	}
+	assert.FileExists(t, filepath.Join(root, "labels", "42.jpeg"))
+
+	// later runs read the cache
+	record := &storage.CheckinRecord{Beer: storage.BeerRecord{BID: 42}}
+	require.NoError(t, New(store, client).Beer(context.Background(), record))
+	assert.Equal(t, "A classic farmhouse ale", record.Beer.Description)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestEnricher_RateLimited(t *testing.T) {
+	store, err := storage.NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	client := &mockUntappdClient{
+		FetchBeerFunc: func(ctx context.Context, bid uint64) (*untappd.BeerInfo, error) {
+			calls.Add(1)
+			return nil, &untappd.RateLimitError{}
+		},
+	}
+
+	e := New(store, client)
+	for _, bid := range []uint64{1, 2, 3} {
+		err := e.Beer(context.Background(), &storage.CheckinRecord{Beer: storage.BeerRecord{BID: bid}})
+		assert.True(t, errors.Is(err, untappd.ErrRateLimited))
+	}
+
+	assert.Equal(t, int32(1), calls.Load(), "expected no call once rate limited")
+}
+
+func TestEnricher_Brewery(t *testing.T) {
+	root := t.TempDir()
+	store, err := storage.NewLocalClient(root)
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	client := &mockUntappdClient{
+		FetchBreweryFunc: func(ctx context.Context, breweryID uint64) (*untappd.BreweryInfo, error) {
+			calls.Add(1)
+			return &untappd.BreweryInfo{
+				BreweryID:    breweryID,
+				BreweryName:  "Brasserie Dupont",
+				BreweryType:  "Micro Brewery",
+				BreweryLabel: "https://assets/brewery_logos/7.png",
+				Location:     untappd.BreweryLocation{BreweryCity: "Tourpes", BreweryState: "Hainaut"},
+				Contact:      untappd.BreweryContact{URL: "https://brasserie-dupont.com"},
+			}, nil
+		},
+	}
+
+	e := New(store, client)
+	e.fetch = func(ctx context.Context, url string) ([]byte, error) {
+		return nil, errors.New("connection reset")
+	}
+
+	// the profile is kept when the logo fails, without a logo key
+	record := &storage.CheckinRecord{Brewery: storage.BreweryRecord{BreweryID: 7}}
+	require.NoError(t, e.Brewery(context.Background(), record))
+	assert.Equal(t, "Micro Brewery", record.Brewery.Type)
+	assert.Equal(t, "Tourpes", record.Brewery.City)
+	assert.Equal(t, "https://brasserie-dupont.com", record.Brewery.URL)
+	assert.Equal(t, "breweries/7.json", record.Brewery.Profile)
+	assert.Empty(t, record.Brewery.Logo)
+
+	// the next run only retries the logo
+	e = New(store, client)
+	e.fetch = func(ctx context.Context, url string) ([]byte, error) {
+		assert.Equal(t, "https://assets/brewery_logos/7.png", url)
+		return []byte("png"), nil
+	}
+
+	record = &storage.CheckinRecord{Brewery: storage.BreweryRecord{BreweryID: 7}}
+	require.NoError(t, e.Brewery(context.Background(), record))
+	assert.Equal(t, "breweries/7.png", record.Brewery.Logo)
+	assert.FileExists(t, filepath.Join(root, "breweries", "7.png"))
+	assert.Equal(t, int32(1), calls.Load())
+
+	profile, err := store.DownloadBrewery(context.Background(), 7)
+	require.NoError(t, err)
+	assert.Equal(t, "breweries/7.png", profile.LogoKey)
+}
@@ -0,0 +1,139 @@
+package failures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/processor"
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+)
+
+// steps of storing a checkin
+const (
+	StageParse  = "parse"
+	StagePhoto  = "photo"
+	StageRecord = "record"
+	// the failed step is not known
+	StageUnknown = "unknown"
+)
+
+// delay before retrying a failure, doubled after each attempt up to
+// maxRetryDelay
+const (
+	baseRetryDelay = 15 * time.Minute
+	maxRetryDelay  = 24 * time.Hour
+)
+
+// error of a step of storing a checkin
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// tags err with the step it happened at, nil when err is
+func WithStage(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// the step err happened at, StageUnknown when it is not tagged
+func StageOf(err error) string {
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return stageErr.Stage
+	}
+	return StageUnknown
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// stores the failure of the checkin with its input, or counts another
+// attempt when it failed before
+func Add(ctx context.Context, store storage.FailureStore, failure *storage.Failure, err error, now time.Time) error {
+	prev, getErr := store.DownloadFailure(ctx, failure.Source, failure.CheckinID)
+	switch {
+	case getErr == nil:
+		failure.Attempts = prev.Attempts + 1
+		failure.FirstFailedAt = prev.FirstFailedAt
+	case errors.Is(getErr, storage.ErrNotFound):
+		failure.Attempts = 1
+		failure.FirstFailedAt = now
+	default:
+		return getErr
+	}
+
+	failure.Stage = StageOf(err)
+	failure.Error = err.Error()
+	failure.LastFailedAt = now
+	failure.NextAttemptAt = now.Add(retryDelay(failure.Attempts))
+
+	if err := store.UploadFailure(ctx, failure); err != nil {
+		return fmt.Errorf("failed to store the failure of checkin %d: %w", failure.CheckinID, err)
+	}
+	return nil
+}
+
+// retries the failures of the source which are due, removing the ones retry
+// succeeds for. The ones not due yet are counted as skipped. opts are passed
+// on to processor.Process.
+func Retry(
+	ctx context.Context,
+	store storage.FailureStore,
+	source string,
+	numWorkers int,
+	now time.Time,
+	retry func(ctx context.Context, failure *storage.Failure) error,
+	opts ...processor.Option,
+) (*processor.Result[*storage.Failure], error) {
+	ids, err := store.ListFailures(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failures: %w", err)
+	}
+
+	var due []*storage.Failure
+	for _, id := range ids {
+		failure, err := store.DownloadFailure(ctx, source, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download failure of checkin %d: %w", id, err)
+		}
+		if failure.NextAttemptAt.After(now) {
+			log.Printf("Checkin %d not due for a retry before %s\n", id, failure.NextAttemptAt.Format(time.RFC3339))
+			continue
+		}
+		due = append(due, failure)
+	}
+
+	result := processor.Process(ctx, due, numWorkers, func(ctx context.Context, failure *storage.Failure) error {
+		log.Printf("Retrying checkin %d, attempt %d", failure.CheckinID, failure.Attempts+1)
+		if err := retry(ctx, failure); err != nil {
+			log.Printf("failed to retry checkin %d: %v", failure.CheckinID, err)
+			if err := Add(context.WithoutCancel(ctx), store, failure, err, now); err != nil {
+				log.Printf("failed to update the failure of checkin %d: %v", failure.CheckinID, err)
+			}
+			return err
+		}
+		return store.DeleteFailure(ctx, source, failure.CheckinID)
+	}, opts...)
+	result.Skipped += len(ids) - len(due)
+
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package failures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdd(t *testing.T) {
+	store, err := storage.NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC)
+	photoErr := WithStage(StagePhoto, errors.New("photo expired"))
+
+	newFailure := func() *storage.Failure {
+		return &storage.Failure{CheckinID: 42, Source: storage.FailureSourceCSV, CSV: map[string]string{"checkin_id": "42"}}
+	}
+	require.NoError(t, Add(ctx, store, newFailure(), photoErr, now))
+	require.NoError(t, Add(ctx, store, newFailure(), errors.New("boom"), now.Add(time.Hour)))
+
+	failure, err := store.DownloadFailure(ctx, storage.FailureSourceCSV, 42)
+	require.NoError(t, err)
+	assert.Equal(t, 2, failure.Attempts)
+	assert.Equal(t, StageUnknown, failure.Stage)
+	assert.Equal(t, "boom", failure.Error)
+	assert.Equal(t, now, failure.FirstFailedAt)
+	assert.Equal(t, now.Add(time.Hour), failure.LastFailedAt)
+	assert.Equal(t, now.Add(time.Hour+2*baseRetryDelay), failure.NextAttemptAt)
+	assert.Equal(t, "42", failure.CSV["checkin_id"])
+}
+
+func TestStageOf(t *testing.T) {
+	err := fmt.Errorf("checkin 1: %w", WithStage(StageRecord, errors.New("upload failed")))
+	assert.Equal(t, StageRecord, StageOf(err))
+	assert.Equal(t, "checkin 1: upload failed", err.Error())
+	assert.Equal(t, StageUnknown, StageOf(errors.New("boom")))
+	assert.NoError(t, WithStage(StagePhoto, nil))
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, baseRetryDelay, retryDelay(1))
+	assert.Equal(t, 4*baseRetryDelay, retryDelay(3))
+	assert.Equal(t, maxRetryDelay, retryDelay(20))
+}
+
+func TestRetry(t *testing.T) {
+	store, err := storage.NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	for id, nextAttemptAt := range map[uint64]time.Time{
+		1: now.Add(-time.Minute),
+		2: now.Add(-time.Minute),
+		3: now.Add(time.Hour),
+	} {
+		require.NoError(t, store.UploadFailure(ctx, &storage.Failure{
+			CheckinID:     id,
+			Source:        storage.FailureSourceAPI,
+			Attempts:      1,
+			FirstFailedAt: now.Add(-time.Hour),
+			NextAttemptAt: nextAttemptAt,
+		}))
+	}
+
+	var retried []uint64
+	result, err := Retry(ctx, store, storage.FailureSourceAPI, 1, now, func(
+		ctx context.Context,
+		failure *storage.Failure,
+	) error {
+		retried = append(retried, failure.CheckinID)
+		if failure.CheckinID == 2 {
+			return WithStage(StageRecord, errors.New("upload failed"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []uint64{1, 2}, retried)
+	assert.Equal(t, 1, result.Succeeded)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 1, result.Skipped)
+
+	ids, err := store.ListFailures(ctx, storage.FailureSourceAPI)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{2, 3}, ids)
+
+	failure, err := store.DownloadFailure(ctx, storage.FailureSourceAPI, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, failure.Attempts)
+	assert.Equal(t, StageRecord, failure.Stage)
+	assert.Equal(t, now.Add(2*baseRetryDelay), failure.NextAttemptAt)
+}
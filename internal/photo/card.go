@@ -0,0 +1,153 @@
+package photo
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/vips"
+)
+
+// rendered placeholder cards are square, like most checkin photos
+const (
+	cardSize   = 1080
+	cardMargin = 90
+)
+
+var (
+	cardBackground = []float64{0x1f, 0x1b, 0x16}
+	cardInk        = []float64{0xf5, 0xef, 0xe6}
+	cardMuted      = []float64{0xb8, 0xad, 0x9e}
+	cardAccent     = []float64{0xf2, 0xb1, 0x2d}
+)
+
+type cardLine struct {
+	text string
+	// pango font description, e.g. "sans bold 64"
+	font string
+	ink  []float64
+	// space above the line
+	gap int
+}
+
+// renders a card showing the checkin, used instead of a photo so photo-less
+// checkins stay informative in the gallery
+func renderCard(md *storage.CheckinMetadata) ([]byte, error) {
+	card, err := vips.NewBlack(cardSize, cardSize, &vips.BlackOptions{Bands: 3})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create card: %w", err)
+	}
+	defer card.Close()
+
+	err = card.DrawRect(cardBackground, 0, 0, cardSize, cardSize, &vips.DrawRectOptions{Fill: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to paint card: %w", err)
+	}
+
+	y := cardMargin
+	for _, line := range cardLines(md) {
+		y += line.gap
+		h, err := drawText(card, line, cardMargin, y)
+		if err != nil {
+			return nil, err
+		}
+		y += h
+	}
+
+	b, err := card.JpegsaveBuffer(&vips.JpegsaveBufferOptions{Q: 85})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export card: %w", err)
+	}
+
+	return b, nil
+}
+
+// draws the line at x, y and returns its height
+func drawText(card *vips.Image, line cardLine, x, y int) (int, error) {
+	// vips renders pango markup, so the text must be escaped
+	mask, err := vips.NewText(html.EscapeString(line.text), &vips.TextOptions{
+		Font:  line.font,
+		Width: cardSize - 2*cardMargin,
+		Dpi:   72,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to render %q: %w", line.text, err)
+	}
+	defer mask.Close()
+
+	if err := card.DrawMask(line.ink, mask, x, y); err != nil {
+		return 0, fmt.Errorf("failed to draw %q: %w", line.text, err)
+	}
+
+	return mask.Height(), nil
+}
+
+// lines of the card, skipping the details the checkin does not have
+func cardLines(md *storage.CheckinMetadata) []cardLine {
+	var lines []cardLine
+	add := func(text, font string, ink []float64, gap int) {
+		if text != "" {
+			lines = append(lines, cardLine{text: text, font: font, ink: ink, gap: gap})
+		}
+	}
+
+	beer := md.Beer
+	if beer == "" {
+		beer = "Unknown beer"
+	}
+	add(beer, "sans bold 72", cardInk, 0)
+	add(md.Brewery, "sans 48", cardInk, 20)
+	add(joinNonEmpty(" · ", md.Style, formatABV(md.ABV)), "sans 36", cardMuted, 20)
+	add(ratingStars(md.Rating), "sans 64", cardAccent, 70)
+	add(joinNonEmpty(", ", md.Venue, md.City, md.Country), "sans 36", cardMuted, 70)
+	add(formatCardDate(md.Date), "sans 36", cardMuted, 20)
+
+	return lines
+}
+
+// five stars filled after the rating, followed by the score. Empty when the
+// checkin was not rated.
+func ratingStars(rating string) string {
+	score, err := strconv.ParseFloat(rating, 64)
+	if err != nil || score <= 0 {
+		return ""
+	}
+
+	filled := int(math.Round(min(score, 5)))
+	return fmt.Sprintf(
+		"%s%s  %s",
+		strings.Repeat("★", filled),
+		strings.Repeat("☆", 5-filled),
+		strconv.FormatFloat(score, 'f', -1, 64),
+	)
+}
+
+func formatABV(abv string) string {
+	v, err := strconv.ParseFloat(abv, 64)
+	if err != nil || v <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64) + "% ABV"
+}
+
+func formatCardDate(date string) string {
+	t, err := time.Parse(time.RFC1123Z, date)
+	if err != nil {
+		return date
+	}
+	return t.Format("Monday 2 January 2006")
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, v := range values {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, sep)
+}
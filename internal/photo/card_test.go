@@ -0,0 +1,66 @@
+package photo
+
+import (
+	"testing"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+)
+
+func TestCardLines(t *testing.T) {
+	md := &storage.CheckinMetadata{
+		Beer:    "Saison Dupont",
+		Brewery: "Brasserie Dupont",
+		Style:   "Farmhouse Ale - Saison",
+		ABV:     "6.50",
+		Rating:  "4.25",
+		Venue:   "The Rake",
+		City:    "London",
+		Country: "England",
+		Date:    "Sat, 01 Nov 2025 18:00:00 +0000",
+	}
+
+	want := []string{
+		"Saison Dupont",
+		"Brasserie Dupont",
+		"Farmhouse Ale - Saison · 6.5% ABV",
+		"★★★★☆  4.25",
+		"The Rake, London, England",
+		"Saturday 1 November 2025",
+	}
+
+	lines := cardLines(md)
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), len(lines))
+	}
+	for i, line := range lines {
+		if line.text != want[i] {
+			t.Errorf("line %d: expected %q, got %q", i, want[i], line.text)
+		}
+	}
+
+	// missing details are left out
+	lines = cardLines(&storage.CheckinMetadata{Beer: "Saison Dupont", Rating: "0.00", ABV: "0.00"})
+	if len(lines) != 1 {
+		t.Errorf("expected only the beer line, got %+v", lines)
+	}
+}
+
+func TestRatingStars(t *testing.T) {
+	tests := []struct {
+		rating string
+		want   string
+	}{
+		{"", ""},
+		{"0.00", ""},
+		{"1.00", "★☆☆☆☆  1"},
+		{"3.50", "★★★★☆  3.5"},
+		{"3.25", "★★★☆☆  3.25"},
+		{"5.00", "★★★★★  5"},
+	}
+
+	for _, tt := range tests {
+		if got := ratingStars(tt.rating); got != tt.want {
+			t.Errorf("ratingStars(%q) = %q, want %q", tt.rating, got, tt.want)
+		}
+	}
+}
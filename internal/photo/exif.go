@@ -0,0 +1,399 @@
+package photo
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"unicode/utf16"
+)
+
+// TIFF field types used by the EXIF writer
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+	tiffIFD       = 13
+)
+
+// size of a value of each TIFF field type, and of the parts swapped when
+// changing the byte order. Rationals are two longs.
+var tiffTypeSizes = map[uint16]struct{ size, unit int }{
+	1:  {1, 1}, // BYTE
+	2:  {1, 1}, // ASCII
+	3:  {2, 2}, // SHORT
+	4:  {4, 4}, // LONG
+	5:  {8, 4}, // RATIONAL
+	6:  {1, 1}, // SBYTE
+	7:  {1, 1}, // UNDEFINED
+	8:  {2, 2}, // SSHORT
+	9:  {4, 4}, // SLONG
+	10: {8, 4}, // SRATIONAL
+	11: {4, 4}, // FLOAT
+	12: {8, 8}, // DOUBLE
+	13: {4, 4}, // IFD
+}
+
+const (
+	tagImageDescription   = 0x010E
+	tagOrientation        = 0x0112
+	tagSoftware           = 0x0131
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagExifVersion        = 0x9000
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagUserComment        = 0x9286
+	tagMakerNote          = 0x927C
+	tagInteropIFD         = 0xA005
+	tagGPSVersionID       = 0x0000
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+
+	// offsets to the image data, the thumbnail and other IFDs
+	tagStripOffsets    = 0x0111
+	tagTileOffsets     = 0x0144
+	tagSubIFDs         = 0x014A
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+)
+
+// tags of an original EXIF which are not carried over: they point at data
+// which is either rebuilt or left behind, or hold offsets which would no
+// longer hold once moved, as the maker notes do
+var droppedTags = map[uint16]bool{
+	tagExifIFD:         true,
+	tagGPSIFD:          true,
+	tagInteropIFD:      true,
+	tagMakerNote:       true,
+	tagStripOffsets:    true,
+	tagTileOffsets:     true,
+	tagSubIFDs:         true,
+	tagThumbnailOffset: true,
+	tagThumbnailLength: true,
+}
+
+const exifSoftware = "untappd-recorder"
+
+// all EXIF data is written big endian ("MM")
+var exifOrder = binary.BigEndian
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type ifd []ifdEntry
+
+// size of the encoded IFD, including values which do not fit in an entry
+func (d ifd) size() uint32 {
+	n := uint32(2 + 12*len(d) + 4)
+	for _, e := range d {
+		if len(e.value) > 4 {
+			n += uint32(len(e.value) + len(e.value)%2)
+		}
+	}
+	return n
+}
+
+// encodes the IFD assuming it starts at the given offset of the TIFF data
+func (d ifd) encode(offset uint32) []byte {
+	sort.Slice(d, func(i, j int) bool { return d[i].tag < d[j].tag })
+
+	head := make([]byte, 0, 2+12*len(d)+4)
+	head = exifOrder.AppendUint16(head, uint16(len(d)))
+
+	var data []byte
+	dataOffset := offset + uint32(2+12*len(d)+4)
+	for _, e := range d {
+		head = exifOrder.AppendUint16(head, e.tag)
+		head = exifOrder.AppendUint16(head, e.typ)
+		head = exifOrder.AppendUint32(head, e.count)
+		if len(e.value) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.value)
+			head = append(head, v...)
+			continue
+		}
+		head = exifOrder.AppendUint32(head, dataOffset+uint32(len(data)))
+		data = append(data, e.value...)
+		if len(e.value)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	// no next IFD
+	head = exifOrder.AppendUint32(head, 0)
+
+	return append(head, data...)
+}
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	v := append([]byte(s), 0)
+	return ifdEntry{tag: tag, typ: tiffASCII, count: uint32(len(v)), value: v}
+}
+
+func longEntry(tag uint16, v uint32) ifdEntry {
+	return ifdEntry{tag: tag, typ: tiffLong, count: 1, value: exifOrder.AppendUint32(nil, v)}
+}
+
+func shortEntry(tag uint16, v uint16) ifdEntry {
+	return ifdEntry{tag: tag, typ: tiffShort, count: 1, value: exifOrder.AppendUint16(nil, v)}
+}
+
+// encodes a decimal coordinate as degrees, minutes and seconds rationals
+func coordinateEntry(tag uint16, coord float64) ifdEntry {
+	const secDenom = 10000
+
+	// work in ten-thousandths of a second so rounding cannot produce 60s
+	total := uint64(math.Round(math.Abs(coord) * 3600 * secDenom))
+	deg := total / (3600 * secDenom)
+	min := total / (60 * secDenom) % 60
+	sec := total % (60 * secDenom)
+
+	var v []byte
+	for _, r := range [][2]uint32{
+		{uint32(deg), 1},
+		{uint32(min), 1},
+		{uint32(sec), secDenom},
+	} {
+		v = exifOrder.AppendUint32(v, r[0])
+		v = exifOrder.AppendUint32(v, r[1])
+	}
+	return ifdEntry{tag: tag, typ: tiffRational, count: 3, value: v}
+}
+
+// UserComment is prefixed with its character code, use unicode only when
+// the comment cannot be written as plain ASCII.
+func userCommentEntry(s string) ifdEntry {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+
+	var v []byte
+	if ascii {
+		v = append([]byte("ASCII\x00\x00\x00"), s...)
+	} else {
+		v = []byte("UNICODE\x00")
+		for _, u := range utf16.Encode([]rune(s)) {
+			v = exifOrder.AppendUint16(v, u)
+		}
+	}
+	return ifdEntry{tag: tagUserComment, typ: tiffUndefined, count: uint32(len(v)), value: v}
+}
+
+// replaces the entry with the same tag, or adds it
+func (d ifd) set(e ifdEntry) ifd {
+	for i := range d {
+		if d[i].tag == e.tag {
+			d[i] = e
+			return d
+		}
+	}
+	return append(d, e)
+}
+
+// the IFDs of an EXIF segment
+type exifIFDs struct {
+	ifd0 ifd
+	exif ifd
+	gps  ifd
+}
+
+// builds the TIFF structure of an EXIF segment for the checkin. The
+// orientation of the original photo is carried over when known.
+func buildEXIF(em *embeddedMetadata, orientation uint16) []byte {
+	var orig exifIFDs
+	if orientation != 0 {
+		orig.ifd0 = ifd{shortEntry(tagOrientation, orientation)}
+	}
+	return mergeEXIF(em, orig)
+}
+
+// builds the TIFF structure of an EXIF segment with the checkin tags added to
+// the ones of the original photo. The checkin tags replace the original ones,
+// and the checkin location the whole GPS IFD.
+func mergeEXIF(em *embeddedMetadata, orig exifIFDs) []byte {
+	ifd0 := slices.Clone(orig.ifd0)
+	ifd0 = ifd0.set(asciiEntry(tagSoftware, exifSoftware))
+	ifd0 = ifd0.set(longEntry(tagExifIFD, 0))
+	if em.title != "" {
+		ifd0 = ifd0.set(asciiEntry(tagImageDescription, em.title))
+	}
+
+	exifIFD := slices.Clone(orig.exif)
+	exifIFD = exifIFD.set(ifdEntry{tag: tagExifVersion, typ: tiffUndefined, count: 4, value: []byte("0232")})
+	exifIFD = exifIFD.set(asciiEntry(tagDateTimeOriginal, em.date.Format("2006:01:02 15:04:05")))
+	exifIFD = exifIFD.set(asciiEntry(tagOffsetTimeOriginal, em.date.Format("-07:00")))
+	if em.description != "" {
+		exifIFD = exifIFD.set(userCommentEntry(em.description))
+	}
+
+	gpsIFD := slices.Clone(orig.gps)
+	if em.hasGPS {
+		latRef, lngRef := "N", "E"
+		if em.lat < 0 {
+			latRef = "S"
+		}
+		if em.lng < 0 {
+			lngRef = "W"
+		}
+		gpsIFD = ifd{
+			{tag: tagGPSVersionID, typ: tiffByte, count: 4, value: []byte{2, 3, 0, 0}},
+			asciiEntry(tagGPSLatitudeRef, latRef),
+			coordinateEntry(tagGPSLatitude, em.lat),
+			asciiEntry(tagGPSLongitudeRef, lngRef),
+			coordinateEntry(tagGPSLongitude, em.lng),
+		}
+	}
+	if len(gpsIFD) > 0 {
+		ifd0 = ifd0.set(longEntry(tagGPSIFD, 0))
+	}
+
+	// the sub-IFDs follow IFD0, whose size does not depend on their offsets
+	const ifd0Offset = 8
+	exifOffset := ifd0Offset + ifd0.size()
+	gpsOffset := exifOffset + exifIFD.size()
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			ifd0[i].value = exifOrder.AppendUint32(nil, exifOffset)
+		case tagGPSIFD:
+			ifd0[i].value = exifOrder.AppendUint32(nil, gpsOffset)
+		}
+	}
+
+	out := []byte{'M', 'M', 0x00, 0x2A}
+	out = exifOrder.AppendUint32(out, ifd0Offset)
+	out = append(out, ifd0.encode(ifd0Offset)...)
+	out = append(out, exifIFD.encode(exifOffset)...)
+	if len(gpsIFD) > 0 {
+		out = append(out, gpsIFD.encode(gpsOffset)...)
+	}
+	return out
+}
+
+// byte order and offset of IFD0 of existing TIFF data, false when it can't
+// be read
+func tiffHeader(tiff []byte) (binary.ByteOrder, uint32, bool) {
+	if len(tiff) < 8 {
+		return nil, 0, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	return order, order.Uint32(tiff[4:]), true
+}
+
+// reads the IFD0, Exif and GPS IFDs of existing TIFF data, with their values
+// in exifOrder. The entries which can't be read are left out, as are the
+// droppedTags.
+func readEXIF(tiff []byte) exifIFDs {
+	order, offset, ok := tiffHeader(tiff)
+	if !ok {
+		return exifIFDs{}
+	}
+
+	var ifds exifIFDs
+	ifd0 := readIFD(tiff, order, offset)
+	for _, e := range ifd0 {
+		if (e.typ != tiffLong && e.typ != tiffIFD) || e.count != 1 {
+			continue
+		}
+		switch e.tag {
+		case tagExifIFD:
+			ifds.exif = readIFD(tiff, order, exifOrder.Uint32(e.value)).without(droppedTags)
+		case tagGPSIFD:
+			ifds.gps = readIFD(tiff, order, exifOrder.Uint32(e.value))
+		}
+	}
+	ifds.ifd0 = ifd0.without(droppedTags)
+	return ifds
+}
+
+// the entries of the IFD at offset, nil when it can't be read
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) ifd {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil
+	}
+
+	var d ifd
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		e := int(offset) + 2 + i*12
+		if e+12 > len(tiff) {
+			return d
+		}
+
+		typ := order.Uint16(tiff[e+2:])
+		sizes, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		n := order.Uint32(tiff[e+4:])
+		length := uint64(sizes.size) * uint64(n)
+
+		var v []byte
+		if length <= 4 {
+			v = tiff[e+8 : e+8+int(length)]
+		} else {
+			at := uint64(order.Uint32(tiff[e+8:]))
+			if at+length > uint64(len(tiff)) {
+				continue
+			}
+			v = tiff[at : at+length]
+		}
+
+		v = slices.Clone(v)
+		if order != exifOrder && sizes.unit > 1 {
+			for j := 0; j+sizes.unit <= len(v); j += sizes.unit {
+				slices.Reverse(v[j : j+sizes.unit])
+			}
+		}
+		d = append(d, ifdEntry{tag: order.Uint16(tiff[e:]), typ: typ, count: n, value: v})
+	}
+	return d
+}
+
+// the entries whose tag is not in tags
+func (d ifd) without(tags map[uint16]bool) ifd {
+	return slices.DeleteFunc(d, func(e ifdEntry) bool { return tags[e.tag] })
+}
+
+// reads the orientation tag from the IFD0 of existing TIFF data, returning 0
+// when it is not present or the data cannot be read.
+func exifOrientation(tiff []byte) uint16 {
+	order, offset, ok := tiffHeader(tiff)
+	if !ok || uint64(offset)+2 > uint64(len(tiff)) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		e := int(offset) + 2 + i*12
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == tagOrientation && order.Uint16(tiff[e+2:]) == tiffShort {
+			return order.Uint16(tiff[e+8:])
+		}
+	}
+	return 0
+}
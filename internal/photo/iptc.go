@@ -0,0 +1,69 @@
+package photo
+
+import (
+	"encoding/binary"
+)
+
+// IPTC IIM datasets, with the maximum length of their values
+type iptcDataset struct {
+	record  byte
+	dataset byte
+	maxLen  int
+}
+
+var (
+	iptcCodedCharacterSet = iptcDataset{1, 90, 32}
+	iptcRecordVersion     = iptcDataset{2, 0, 2}
+	iptcObjectName        = iptcDataset{2, 5, 64}
+	iptcKeywords          = iptcDataset{2, 25, 64}
+	iptcDateCreated       = iptcDataset{2, 55, 8}
+	iptcTimeCreated       = iptcDataset{2, 60, 11}
+	iptcCity              = iptcDataset{2, 90, 32}
+	iptcSublocation       = iptcDataset{2, 92, 32}
+	iptcProvinceState     = iptcDataset{2, 95, 32}
+	iptcCountryName       = iptcDataset{2, 101, 64}
+	iptcCaption           = iptcDataset{2, 120, 2000}
+)
+
+// image resource ID of the IPTC-NAA record in a Photoshop APP13 segment
+const photoshopIPTCResource = 0x0404
+
+func appendIPTC(b []byte, ds iptcDataset, value string) []byte {
+	if value == "" {
+		return b
+	}
+	value = truncateUTF8(value, ds.maxLen)
+	b = append(b, 0x1C, ds.record, ds.dataset)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// builds the Photoshop image resources of an APP13 segment holding the
+// checkin as an IPTC-NAA record. Values are declared as UTF-8.
+func buildIPTC(em *embeddedMetadata) []byte {
+	var iptc []byte
+	iptc = appendIPTC(iptc, iptcCodedCharacterSet, "\x1b%G")
+	iptc = appendIPTC(iptc, iptcRecordVersion, "\x00\x04")
+	iptc = appendIPTC(iptc, iptcObjectName, em.title)
+	for _, k := range em.keywords {
+		iptc = appendIPTC(iptc, iptcKeywords, k)
+	}
+	iptc = appendIPTC(iptc, iptcDateCreated, em.date.Format("20060102"))
+	iptc = appendIPTC(iptc, iptcTimeCreated, em.date.Format("150405-0700"))
+	iptc = appendIPTC(iptc, iptcCity, em.city)
+	iptc = appendIPTC(iptc, iptcSublocation, em.venue)
+	iptc = appendIPTC(iptc, iptcProvinceState, em.state)
+	iptc = appendIPTC(iptc, iptcCountryName, em.country)
+	iptc = appendIPTC(iptc, iptcCaption, em.description)
+
+	// 8BIM resource with an empty, padded, pascal string name
+	out := []byte("8BIM")
+	out = binary.BigEndian.AppendUint16(out, photoshopIPTCResource)
+	out = append(out, 0, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(iptc)))
+	out = append(out, iptc...)
+	if len(iptc)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
@@ -0,0 +1,264 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAPPD = 0xED
+
+	// maximum payload of a JPEG segment, the length field counts itself
+	maxSegmentPayload = 0xFFFF - 2
+)
+
+// the photo is in another format, e.g. PNG
+var errNotJPEG = errors.New("not a jpeg file")
+
+var (
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	photoshopHeader   = []byte("Photoshop 3.0\x00")
+)
+
+// checkin metadata in the shape the EXIF, XMP and IPTC writers need it
+type embeddedMetadata struct {
+	title       string
+	description string
+	keywords    []string
+	rating      float64
+	venue       string
+	city        string
+	state       string
+	country     string
+	date        time.Time
+	hasGPS      bool
+	lat         float64
+	lng         float64
+}
+
+func newEmbeddedMetadata(md *storage.CheckinMetadata) (*embeddedMetadata, error) {
+	t, err := time.Parse(time.RFC1123Z, md.Date)
+	if err != nil {
+		return nil, fmt.Errorf("parse checkin date %q: %w", md.Date, err)
+	}
+
+	em := &embeddedMetadata{
+		title:       checkinTitle(md),
+		description: md.Comment,
+		venue:       md.Venue,
+		city:        md.City,
+		state:       md.State,
+		country:     md.Country,
+		date:        t,
+	}
+
+	for _, k := range []string{md.Beer, md.Brewery, md.Style} {
+		if k != "" {
+			em.keywords = append(em.keywords, k)
+		}
+	}
+
+	if md.Rating != "" {
+		rating, err := strconv.ParseFloat(md.Rating, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse rating %q: %w", md.Rating, err)
+		}
+		em.rating = rating
+	}
+
+	if md.LatLng != "" {
+		lat, lng, err := parseLatLng(md.LatLng)
+		if err != nil {
+			return nil, err
+		}
+		// venues without a known location are reported at 0,0
+		em.hasGPS = lat != 0 || lng != 0
+		em.lat, em.lng = lat, lng
+	}
+
+	return em, nil
+}
+
+func checkinTitle(md *storage.CheckinMetadata) string {
+	switch {
+	case md.Beer != "" && md.Brewery != "":
+		return fmt.Sprintf("%s by %s", md.Beer, md.Brewery)
+	default:
+		return md.Beer
+	}
+}
+
+func parseLatLng(s string) (float64, float64, error) {
+	latStr, lngStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid latlng %q", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse latitude %q: %w", latStr, err)
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(lngStr), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse longitude %q: %w", lngStr, err)
+	}
+	if math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return 0, 0, fmt.Errorf("latlng %q out of range", s)
+	}
+	return lat, lng, nil
+}
+
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// embeds the checkin metadata into a JPEG as EXIF, XMP and IPTC segments.
+// The checkin tags are added to the EXIF of the photo, the XMP and IPTC ones
+// are replaced.
+func embedJPEGMetadata(b []byte, md *storage.CheckinMetadata) ([]byte, error) {
+	em, err := newEmbeddedMetadata(md)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, rest, err := splitJPEG(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		leading []jpegSegment
+		kept    []jpegSegment
+		orig    []byte
+	)
+	for i, s := range segments {
+		switch {
+		// JFIF and JFXX markers have to come right after SOI
+		case s.marker == markerAPP0 && len(kept) == 0 && i == len(leading):
+			leading = append(leading, s)
+		case s.marker == markerAPP1 && bytes.HasPrefix(s.payload, exifHeader):
+			if orig == nil {
+				orig = s.payload[len(exifHeader):]
+			}
+		case s.marker == markerAPP1 && bytes.HasPrefix(s.payload, xmpHeader),
+			s.marker == markerAPP1 && bytes.HasPrefix(s.payload, xmpExtendedHeader),
+			s.marker == markerAPPD && bytes.HasPrefix(s.payload, photoshopHeader):
+			// replaced below
+		default:
+			kept = append(kept, s)
+		}
+	}
+
+	exif := mergeEXIF(em, readEXIF(orig))
+	if len(exifHeader)+len(exif) > maxSegmentPayload {
+		// keep the orientation of the original photo at least, so it still
+		// displays the right way up
+		log.Printf("EXIF of the photo of checkin %s too large, only keeping its orientation", md.ID)
+		exif = buildEXIF(em, exifOrientation(orig))
+	}
+
+	embedded := []jpegSegment{
+		{marker: markerAPP1, payload: concat(exifHeader, exif)},
+		{marker: markerAPP1, payload: concat(xmpHeader, buildXMP(em))},
+		{marker: markerAPPD, payload: concat(photoshopHeader, buildIPTC(em))},
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(b) + 4096)
+	out.Write([]byte{0xFF, markerSOI})
+	for _, group := range [][]jpegSegment{leading, embedded, kept} {
+		for _, s := range group {
+			if len(s.payload) > maxSegmentPayload {
+				return nil, fmt.Errorf("jpeg segment 0x%X exceeds %d bytes", s.marker, maxSegmentPayload)
+			}
+			out.Write([]byte{0xFF, s.marker})
+			binary.Write(&out, binary.BigEndian, uint16(len(s.payload)+2))
+			out.Write(s.payload)
+		}
+	}
+	out.Write(rest)
+
+	return out.Bytes(), nil
+}
+
+// reads the EXIF orientation of a JPEG, returning 0 when it is not set
+func jpegOrientation(b []byte) uint16 {
+	segments, _, err := splitJPEG(b)
+	if err != nil {
+		return 0
+	}
+	for _, s := range segments {
+		if s.marker == markerAPP1 && bytes.HasPrefix(s.payload, exifHeader) {
+			return exifOrientation(s.payload[len(exifHeader):])
+		}
+	}
+	return 0
+}
+
+// splits a JPEG into the marker segments preceding the image data, and the
+// remaining bytes starting at the start of scan marker.
+func splitJPEG(b []byte) ([]jpegSegment, []byte, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != markerSOI {
+		return nil, nil, errNotJPEG
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for {
+		if pos+4 > len(b) || b[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("malformed jpeg segment at offset %d", pos)
+		}
+		marker := b[pos+1]
+		// fill bytes may precede a marker
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, b[pos:], nil
+		}
+
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if length < 2 || pos+2+length > len(b) {
+			return nil, nil, fmt.Errorf("malformed jpeg segment length at offset %d", pos)
+		}
+		segments = append(segments, jpegSegment{
+			marker:  marker,
+			payload: b[pos+4 : pos+2+length],
+		})
+		pos += 2 + length
+	}
+}
+
+func concat(header, body []byte) []byte {
+	out := make([]byte, 0, len(header)+len(body))
+	out = append(out, header...)
+	return append(out, body...)
+}
+
+// truncates s to at most n bytes without splitting a UTF-8 sequence
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,271 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetadata() *storage.CheckinMetadata {
+	return &storage.CheckinMetadata{
+		ID:      "123",
+		Beer:    "Saison Dupont",
+		Brewery: "Brasserie Dupont",
+		Comment: "Très bon 🍺 <3",
+		Rating:  "4.25",
+		Venue:   "Moeder Lambic",
+		City:    "Brussels",
+		Country: "Belgium",
+		LatLng:  "50.833400,-4.345600",
+		Date:    "Sat, 01 Nov 2025 18:30:00 +0100",
+		Style:   "Farmhouse Ale - Saison",
+	}
+}
+
+func TestEmbedJPEGMetadata(t *testing.T) {
+	imgData, err := os.ReadFile("../../img/missing.jpg")
+	require.NoError(t, err)
+
+	out, err := embedJPEGMetadata(imgData, testMetadata())
+	require.NoError(t, err)
+
+	segments, rest, err := splitJPEG(out)
+	require.NoError(t, err)
+
+	_, origRest, err := splitJPEG(imgData)
+	require.NoError(t, err)
+	assert.Equal(t, origRest, rest, "image data should be untouched")
+
+	var exif, xmp, iptc []byte
+	for _, s := range segments {
+		switch {
+		case bytes.HasPrefix(s.payload, exifHeader):
+			exif = s.payload[len(exifHeader):]
+		case bytes.HasPrefix(s.payload, xmpHeader):
+			xmp = s.payload[len(xmpHeader):]
+		case bytes.HasPrefix(s.payload, photoshopHeader):
+			iptc = s.payload[len(photoshopHeader):]
+		}
+	}
+	require.NotNil(t, exif, "missing EXIF segment")
+	require.NotNil(t, xmp, "missing XMP segment")
+	require.NotNil(t, iptc, "missing IPTC segment")
+
+	assert.True(t, bytes.Contains(exif, []byte("2025:11:01 18:30:00\x00")))
+	assert.True(t, bytes.Contains(exif, []byte("+01:00\x00")))
+	assert.True(t, bytes.Contains(exif, []byte("Saison Dupont by Brasserie Dupont\x00")))
+
+	x := string(xmp)
+	assert.Contains(t, x, `<rdf:li xml:lang="x-default">Saison Dupont by Brasserie Dupont</rdf:li>`)
+	assert.Contains(t, x, "Très bon 🍺 &lt;3")
+	assert.Contains(t, x, `xmp:Rating="4.25"`)
+	assert.Contains(t, x, `exif:GPSLatitude="50,50.004000N"`)
+	assert.Contains(t, x, `exif:GPSLongitude="4,20.736000W"`)
+	assert.Contains(t, x, "<rdf:li>Farmhouse Ale - Saison</rdf:li>")
+
+	assert.True(t, bytes.HasPrefix(iptc, []byte("8BIM\x04\x04")))
+	assert.True(t, bytes.Contains(iptc, []byte("Brasserie Dupont")))
+	assert.True(t, bytes.Contains(iptc, []byte("20251101")))
+
+	// embedding again replaces the segments instead of adding new ones
+	again, err := embedJPEGMetadata(out, testMetadata())
+	require.NoError(t, err)
+	assert.Equal(t, out, again)
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// encodes TIFF data in the byte order, as a camera would write it
+func encodeTIFF(order binary.AppendByteOrder, ifd0, exif, gps []tiffEntry) []byte {
+	size := func(d []tiffEntry) int {
+		n := 2 + 12*len(d) + 4
+		for _, e := range d {
+			if len(e.value) > 4 {
+				n += len(e.value)
+			}
+		}
+		return n
+	}
+
+	ifd0 = append(ifd0,
+		tiffEntry{tag: tagExifIFD, typ: tiffLong, count: 1},
+		tiffEntry{tag: tagGPSIFD, typ: tiffLong, count: 1},
+	)
+	exifAt := 8 + size(ifd0)
+	gpsAt := exifAt + size(exif)
+	ifd0[len(ifd0)-2].value = order.AppendUint32(nil, uint32(exifAt))
+	ifd0[len(ifd0)-1].value = order.AppendUint32(nil, uint32(gpsAt))
+
+	out := []byte("MM")
+	if order == binary.LittleEndian {
+		out = []byte("II")
+	}
+	out = order.AppendUint16(out, 0x2A)
+	out = order.AppendUint32(out, 8)
+	for _, d := range []struct {
+		entries []tiffEntry
+		at      int
+	}{{ifd0, 8}, {exif, exifAt}, {gps, gpsAt}} {
+		out = order.AppendUint16(out, uint16(len(d.entries)))
+		var data []byte
+		dataAt := d.at + 2 + 12*len(d.entries) + 4
+		for _, e := range d.entries {
+			out = order.AppendUint16(out, e.tag)
+			out = order.AppendUint16(out, e.typ)
+			out = order.AppendUint32(out, e.count)
+			if len(e.value) <= 4 {
+				out = append(out, append(e.value, make([]byte, 4-len(e.value))...)...)
+				continue
+			}
+			out = order.AppendUint32(out, uint32(dataAt+len(data)))
+			data = append(data, e.value...)
+		}
+		out = order.AppendUint32(out, 0)
+		out = append(out, data...)
+	}
+	return out
+}
+
+// the value of the tag in the IFD, nil when missing
+func ifdValue(d ifd, tag uint16) []byte {
+	for _, e := range d {
+		if e.tag == tag {
+			return e.value
+		}
+	}
+	return nil
+}
+
+func TestEmbedJPEGMetadata_KeepsEXIF(t *testing.T) {
+	imgData, err := os.ReadFile("../../img/missing.jpg")
+	require.NoError(t, err)
+
+	const (
+		tagMake        = 0x010F
+		tagFNumber     = 0x829D
+		tagGPSAltitude = 0x0006
+	)
+	le := binary.LittleEndian
+	tiff := encodeTIFF(le,
+		[]tiffEntry{
+			{tag: tagMake, typ: tiffASCII, count: 6, value: []byte("Canon\x00")},
+			{tag: tagOrientation, typ: tiffShort, count: 1, value: le.AppendUint16(nil, 6)},
+			{tag: tagImageDescription, typ: tiffASCII, count: 4, value: []byte("old\x00")},
+		},
+		[]tiffEntry{
+			{tag: tagFNumber, typ: tiffRational, count: 1, value: le.AppendUint32(le.AppendUint32(nil, 28), 10)},
+			{tag: tagDateTimeOriginal, typ: tiffASCII, count: 20, value: []byte("2020:01:01 00:00:00\x00")},
+			{tag: tagMakerNote, typ: tiffUndefined, count: 8, value: []byte("vendor!!")},
+		},
+		[]tiffEntry{
+			{tag: tagGPSAltitude, typ: tiffRational, count: 1, value: le.AppendUint32(le.AppendUint32(nil, 100), 1)},
+		},
+	)
+	app1 := concat(exifHeader, tiff)
+	photo := append([]byte{0xFF, markerSOI, 0xFF, markerAPP1}, binary.BigEndian.AppendUint16(nil, uint16(len(app1)+2))...)
+	photo = append(photo, app1...)
+	photo = append(photo, imgData[2:]...)
+
+	embeddedEXIF := func(md *storage.CheckinMetadata) exifIFDs {
+		out, err := embedJPEGMetadata(photo, md)
+		require.NoError(t, err)
+		segments, _, err := splitJPEG(out)
+		require.NoError(t, err)
+
+		var found []exifIFDs
+		for _, s := range segments {
+			if s.marker == markerAPP1 && bytes.HasPrefix(s.payload, exifHeader) {
+				found = append(found, readEXIF(s.payload[len(exifHeader):]))
+			}
+		}
+		require.Len(t, found, 1, "expected a single EXIF segment")
+		return found[0]
+	}
+
+	ifds := embeddedEXIF(testMetadata())
+
+	// the camera tags are kept, in the byte order written
+	assert.Equal(t, []byte("Canon\x00"), ifdValue(ifds.ifd0, tagMake))
+	assert.Equal(t, []byte{0, 6}, ifdValue(ifds.ifd0, tagOrientation))
+	assert.Equal(t, []byte{0, 0, 0, 28, 0, 0, 0, 10}, ifdValue(ifds.exif, tagFNumber))
+	// the maker notes can't be moved
+	assert.Nil(t, ifdValue(ifds.exif, tagMakerNote))
+
+	// the checkin tags replace the original ones
+	assert.Equal(t, []byte("Saison Dupont by Brasserie Dupont\x00"), ifdValue(ifds.ifd0, tagImageDescription))
+	assert.Equal(t, []byte("2025:11:01 18:30:00\x00"), ifdValue(ifds.exif, tagDateTimeOriginal))
+	assert.Equal(t, []byte("W\x00"), ifdValue(ifds.gps, tagGPSLongitudeRef))
+	assert.Nil(t, ifdValue(ifds.gps, tagGPSAltitude))
+
+	// without a checkin location the one of the photo is kept
+	md := testMetadata()
+	md.LatLng = ""
+	ifds = embeddedEXIF(md)
+	assert.Equal(t, []byte{0, 0, 0, 100, 0, 0, 0, 1}, ifdValue(ifds.gps, tagGPSAltitude))
+	assert.Nil(t, ifdValue(ifds.gps, tagGPSLongitudeRef))
+}
+
+func TestEmbedJPEGMetadata_NotJPEG(t *testing.T) {
+	_, err := embedJPEGMetadata([]byte("not a jpeg"), testMetadata())
+	assert.ErrorIs(t, err, errNotJPEG)
+
+	// the photo is stored as it is
+	b, err := embedMetadata([]byte("not a jpeg"), testMetadata())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("not a jpeg"), b)
+}
+
+func TestEmbedJPEGMetadata_InvalidDate(t *testing.T) {
+	imgData, err := os.ReadFile("../../img/missing.jpg")
+	require.NoError(t, err)
+
+	md := testMetadata()
+	md.Date = "2025-11-01"
+	_, err = embedJPEGMetadata(imgData, md)
+	assert.Error(t, err)
+}
+
+func TestBuildEXIF_GPS(t *testing.T) {
+	em, err := newEmbeddedMetadata(testMetadata())
+	require.NoError(t, err)
+
+	tiff := buildEXIF(em, 6)
+	assert.Equal(t, uint16(6), exifOrientation(tiff))
+
+	// the GPS IFD is the last one written, find the latitude rationals
+	idx := bytes.Index(tiff, []byte("N\x00"))
+	require.NotEqual(t, -1, idx, "missing latitude reference")
+	assert.True(t, bytes.Contains(tiff, []byte("W\x00")))
+
+	deg := make([]byte, 8)
+	binary.BigEndian.PutUint32(deg, 50)
+	binary.BigEndian.PutUint32(deg[4:], 1)
+	assert.True(t, bytes.Contains(tiff, deg))
+}
+
+func TestBuildEXIF_NoGPSAtZero(t *testing.T) {
+	md := testMetadata()
+	md.LatLng = "0.000000,0.000000"
+	em, err := newEmbeddedMetadata(md)
+	require.NoError(t, err)
+
+	assert.False(t, em.hasGPS)
+	assert.NotContains(t, string(buildXMP(em)), "GPSLatitude")
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "abc", truncateUTF8("abc", 10))
+	assert.Equal(t, "Tr", truncateUTF8("Très", 3))
+	assert.Equal(t, strings.Repeat("a", 64), truncateUTF8(strings.Repeat("a", 100), 64))
+}
@@ -2,12 +2,12 @@ package photo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path"
 	"time"
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
@@ -19,13 +19,18 @@ type Downloader interface {
 	DownloadAndSave(
 		ctx context.Context,
 		cfg *config.Config,
-		store storage.Storage,
+		store storage.PhotoStore,
 		photoURL string,
 		metadata *storage.CheckinMetadata,
 	) error
 	DownloadAndSaveWEBP(
 		ctx context.Context,
-		store storage.Storage,
+		store storage.PhotoStore,
+		metadata *storage.CheckinMetadata,
+	) error
+	RewriteMetadata(
+		ctx context.Context,
+		store storage.PhotoStore,
 		metadata *storage.CheckinMetadata,
 	) error
 }
@@ -43,7 +48,7 @@ func NewDownloader() Downloader {
 func (d *DefaultDownloader) DownloadAndSave(
 	ctx context.Context,
 	cfg *config.Config,
-	store storage.Storage,
+	store storage.PhotoStore,
 	photoURL string,
 	metadata *storage.CheckinMetadata,
 ) error {
@@ -53,14 +58,19 @@ func (d *DefaultDownloader) DownloadAndSave(
 	)
 
 	if photoURL == "" {
-		b, err = usePlaceholderPhoto(cfg.PlaceholderPhotoPath)
+		b, err = placeholderPhoto(ctx, cfg, store, metadata)
 	} else {
-		b, err = d.downloadPhoto(ctx, photoURL)
+		b, err = Fetch(ctx, photoURL)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to get photo: %w", err)
 	}
 
+	b, err = embedMetadata(b, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to embed metadata: %w", err)
+	}
+
 	if err := store.UploadJPG(ctx, b, metadata); err != nil {
 		return fmt.Errorf("failed to upload photo: %w", err)
 	}
@@ -70,15 +80,14 @@ func (d *DefaultDownloader) DownloadAndSave(
 
 func (d *DefaultDownloader) DownloadAndSaveWEBP(
 	ctx context.Context,
-	store storage.Storage,
+	store storage.PhotoStore,
 	metadata *storage.CheckinMetadata,
 ) error {
 	// refetch the original JPG to perform the conversion
-	t, err := time.Parse(time.RFC1123Z, metadata.Date)
+	key, err := metadata.JPGKey()
 	if err != nil {
-		return fmt.Errorf("parse checkin date %q: %w", metadata.Date, err)
+		return err
 	}
-	key := path.Join(t.Format("2006/01/02"), fmt.Sprintf("%s.jpg", metadata.ID))
 	b, err := store.Download(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to download photo from storage: %w", err)
@@ -87,9 +96,48 @@ func (d *DefaultDownloader) DownloadAndSaveWEBP(
 	return d.toWEBP(ctx, store, b, metadata)
 }
 
+// embeds the metadata into the stored photo again, replacing the one it
+// had, and refreshes its WebP. The photo is not downloaded again.
+func (d *DefaultDownloader) RewriteMetadata(
+	ctx context.Context,
+	store storage.PhotoStore,
+	metadata *storage.CheckinMetadata,
+) error {
+	key, err := metadata.JPGKey()
+	if err != nil {
+		return err
+	}
+	b, err := store.Download(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to download photo from storage: %w", err)
+	}
+
+	b, err = embedMetadata(b, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to embed metadata: %w", err)
+	}
+
+	if err := store.UploadJPG(ctx, b, metadata); err != nil {
+		return fmt.Errorf("failed to upload photo: %w", err)
+	}
+
+	return d.toWEBP(ctx, store, b, metadata)
+}
+
+// embeds the metadata into a JPEG photo. The photos in other formats are
+// stored as they are, the metadata is still kept with the object.
+func embedMetadata(b []byte, metadata *storage.CheckinMetadata) ([]byte, error) {
+	out, err := embedJPEGMetadata(b, metadata)
+	if errors.Is(err, errNotJPEG) {
+		log.Printf("photo of checkin %s is not a JPEG, not embedding its metadata", metadata.ID)
+		return b, nil
+	}
+	return out, err
+}
+
 func (d *DefaultDownloader) toWEBP(
 	ctx context.Context,
-	store storage.Storage,
+	store storage.PhotoStore,
 	b []byte,
 	metadata *storage.CheckinMetadata,
 ) error {
@@ -98,6 +146,11 @@ func (d *DefaultDownloader) toWEBP(
 		return fmt.Errorf("failed to convert to webp: %w", err)
 	}
 
+	webp, err = embedWEBPMetadata(webp, metadata, jpegOrientation(b))
+	if err != nil {
+		return fmt.Errorf("failed to embed webp metadata: %w", err)
+	}
+
 	if err := store.UploadWEBP(ctx, webp, metadata); err != nil {
 		return fmt.Errorf("failed to upload webp photo: %w", err)
 	}
@@ -105,6 +158,46 @@ func (d *DefaultDownloader) toWEBP(
 	return nil
 }
 
+// photo of a checkin without one, after the placeholder mode: a rendered
+// card, the archived label of the beer, or the static placeholder which is
+// also the fallback when the others are not available
+func placeholderPhoto(
+	ctx context.Context,
+	cfg *config.Config,
+	store storage.PhotoStore,
+	metadata *storage.CheckinMetadata,
+) ([]byte, error) {
+	switch cfg.PlaceholderMode {
+	case config.PlaceholderCard:
+		b, err := renderCard(metadata)
+		if err == nil {
+			return b, nil
+		}
+		log.Printf("failed to render card, using the placeholder: %v", err)
+	case config.PlaceholderLabel:
+		if metadata.Label == "" {
+			break
+		}
+		b, err := labelPhoto(ctx, store, metadata.Label)
+		if err == nil {
+			return b, nil
+		}
+		log.Printf("failed to use label %q, using the placeholder: %v", metadata.Label, err)
+	}
+	return usePlaceholderPhoto(cfg.PlaceholderPhotoPath)
+}
+
+func labelPhoto(ctx context.Context, store storage.PhotoStore, key string) ([]byte, error) {
+	b, err := store.Download(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download label from storage: %w", err)
+	}
+	if isJPEG(b) {
+		return b, nil
+	}
+	return toJPEG(b)
+}
+
 func usePlaceholderPhoto(path string) ([]byte, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -115,7 +208,8 @@ func usePlaceholderPhoto(path string) ([]byte, error) {
 
 const maxPhotoBytes = 10 << 20 // 10 MiB
 
-func (d *DefaultDownloader) downloadPhoto(ctx context.Context, urlStr string) ([]byte, error) {
+// downloads an image, capped to 10 MiB
+func Fetch(ctx context.Context, urlStr string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed request for photo %q: %w", urlStr, err)
@@ -147,8 +241,30 @@ func (d *DefaultDownloader) downloadPhoto(ctx context.Context, urlStr string) ([
 	return data, nil
 }
 
+func isJPEG(b []byte) bool {
+	return len(b) >= 4 && b[0] == 0xFF && b[1] == markerSOI
+}
+
+// converts an image of any format supported by libvips, e.g. a PNG label
+func toJPEG(b []byte) ([]byte, error) {
+	img, err := vips.NewImageFromBuffer(b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image from buffer: %w", err)
+	}
+	defer img.Close()
+
+	jpg, err := img.JpegsaveBuffer(&vips.JpegsaveBufferOptions{
+		Q: 90,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export jpeg: %w", err)
+	}
+
+	return jpg, nil
+}
+
 func toWEBP(b []byte) ([]byte, error) {
-	img, err := vips.NewJpegloadBuffer(b, nil)
+	img, err := vips.NewImageFromBuffer(b, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create image from buffer: %w", err)
 	}
@@ -1,6 +1,7 @@
 package photo
 
 import (
+	"bytes"
 	"context"
 	"net/http"
 	"net/http/httptest"
@@ -9,92 +10,9 @@ import (
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
 	"github.com/smallwat3r/untappd-recorder/internal/storage"
-	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/smallwat3r/untappd-recorder/internal/storage/storagetest"
 )
 
-type mockStorage struct {
-	UploadJPGFunc             func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	UploadWEBPFunc            func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
-	DownloadFunc              func(ctx context.Context, fileName string) ([]byte, error)
-	CheckinExistsFunc         func(ctx context.Context, checkinID, createdAt string) (bool, error)
-	CheckinWEBPExistsFunc     func(ctx context.Context, checkinID, createdAt string) (bool, error)
-	GetLatestCheckinIDFunc    func(ctx context.Context) (uint64, error)
-	UpdateLatestCheckinIDFunc func(ctx context.Context, checkin untappd.Checkin) error
-}
-
-func (m *mockStorage) UploadJPG(
-	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
-) error {
-	if m.UploadJPGFunc != nil {
-		return m.UploadJPGFunc(ctx, file, metadata)
-	}
-	return nil
-}
-
-func (m *mockStorage) UploadWEBP(
-	ctx context.Context,
-	file []byte,
-	metadata *storage.CheckinMetadata,
-) error {
-	if m.UploadWEBPFunc != nil {
-		return m.UploadWEBPFunc(ctx, file, metadata)
-	}
-	return nil
-}
-
-func (m *mockStorage) Download(
-	ctx context.Context,
-	fileName string,
-) ([]byte, error) {
-	if m.DownloadFunc != nil {
-		return m.DownloadFunc(ctx, fileName)
-	}
-	return nil, nil
-}
-
-func (m *mockStorage) CheckinExists(
-	ctx context.Context,
-	checkinID,
-	createdAt string,
-) (bool, error) {
-	if m.CheckinExistsFunc != nil {
-		return m.CheckinExistsFunc(ctx, checkinID, createdAt)
-	}
-	return false, nil
-}
-
-func (m *mockStorage) CheckinWEBPExists(
-	ctx context.Context,
-	checkinID,
-	createdAt string,
-) (bool, error) {
-	if m.CheckinWEBPExistsFunc != nil {
-		return m.CheckinWEBPExistsFunc(ctx, checkinID, createdAt)
-	}
-	return false, nil
-}
-
-func (m *mockStorage) GetLatestCheckinID(
-	ctx context.Context,
-) (uint64, error) {
-	if m.GetLatestCheckinIDFunc != nil {
-		return m.GetLatestCheckinIDFunc(ctx)
-	}
-	return 0, nil
-}
-
-func (m *mockStorage) UpdateLatestCheckinID(
-	ctx context.Context,
-	checkin untappd.Checkin,
-) error {
-	if m.UpdateLatestCheckinIDFunc != nil {
-		return m.UpdateLatestCheckinIDFunc(ctx, checkin)
-	}
-	return nil
-}
-
 func TestDefaultDownloader_DownloadAndSave(t *testing.T) {
 	imgData, err := os.ReadFile("../../img/missing.jpg")
 	if err != nil {
@@ -172,7 +90,7 @@ func TestDefaultDownloader_DownloadAndSave(t *testing.T) {
 			var uploadJPGCalls int
 			var uploadWEBPCalls int
 
-			mockStore := &mockStorage{
+			mockStore := &storagetest.Storage{
 				UploadJPGFunc: func(
 					ctx context.Context,
 					file []byte,
@@ -285,7 +203,7 @@ func TestDefaultDownloader_DownloadAndSaveWEBP(t *testing.T) {
 			var downloadCalls int
 			var uploadWEBPCalls int
 
-			mockStore := &mockStorage{
+			mockStore := &storagetest.Storage{
 				DownloadFunc: func(
 					ctx context.Context,
 					fileName string,
@@ -337,3 +255,101 @@ func TestDefaultDownloader_DownloadAndSaveWEBP(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultDownloader_RewriteMetadata(t *testing.T) {
+	imgData, err := os.ReadFile("../../img/missing.jpg")
+	if err != nil {
+		t.Fatalf("failed to read missing.jpg: %v", err)
+	}
+
+	metadata := &storage.CheckinMetadata{
+		ID:      "123",
+		Date:    "Sat, 01 Nov 2025 00:00:00 +0000",
+		Comment: "Better than I remembered",
+	}
+
+	var uploaded []byte
+	var uploadWEBPCalls int
+	mockStore := &storagetest.Storage{
+		DownloadFunc: func(ctx context.Context, fileName string) ([]byte, error) {
+			if fileName != "2025/11/01/123.jpg" {
+				t.Errorf("expected the stored photo to be downloaded, got %q", fileName)
+			}
+			return imgData, nil
+		},
+		UploadJPGFunc: func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error {
+			uploaded = file
+			return nil
+		},
+		UploadWEBPFunc: func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error {
+			uploadWEBPCalls++
+			return nil
+		},
+	}
+
+	if err := NewDownloader().RewriteMetadata(context.Background(), mockStore, metadata); err != nil {
+		t.Fatalf("RewriteMetadata() error = %v", err)
+	}
+
+	if !bytes.Contains(uploaded, []byte(metadata.Comment)) {
+		t.Error("expected the photo to embed the new metadata")
+	}
+	if uploadWEBPCalls != 1 {
+		t.Errorf("expected the webp to be refreshed, got %d uploads", uploadWEBPCalls)
+	}
+}
+
+func TestPlaceholderPhoto(t *testing.T) {
+	imgData, err := os.ReadFile("../../img/missing.jpg")
+	if err != nil {
+		t.Fatalf("failed to read missing.jpg: %v", err)
+	}
+	label := []byte{0xFF, 0xD8, 0xFF, 0xE0, 'l', 'a', 'b', 'e', 'l'}
+
+	mockStore := &storagetest.Storage{
+		DownloadFunc: func(ctx context.Context, fileName string) ([]byte, error) {
+			if fileName != "labels/40.jpeg" {
+				return nil, storage.ErrNotFound
+			}
+			return label, nil
+		},
+	}
+
+	tests := []struct {
+		name  string
+		mode  string
+		label string
+		want  []byte
+	}{
+		{name: "static", mode: config.PlaceholderStatic, label: "labels/40.jpeg", want: imgData},
+		{name: "label", mode: config.PlaceholderLabel, label: "labels/40.jpeg", want: label},
+		{name: "beer without label", mode: config.PlaceholderLabel, want: imgData},
+		{name: "label not archived", mode: config.PlaceholderLabel, label: "labels/41.jpeg", want: imgData},
+		{name: "card", mode: config.PlaceholderCard, label: "labels/40.jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				PlaceholderPhotoPath: "../../img/missing.jpg",
+				PlaceholderMode:      tt.mode,
+			}
+			md := &storage.CheckinMetadata{ID: "123", Label: tt.label}
+
+			got, err := placeholderPhoto(context.Background(), cfg, mockStore, md)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// cards are rendered for each checkin
+			if tt.want == nil {
+				if !isJPEG(got) || bytes.Equal(got, imgData) {
+					t.Errorf("expected a rendered card")
+				}
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("expected %d bytes, got %d", len(tt.want), len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+)
+
+// VP8X feature flags
+const (
+	webpFlagXMP   = 0x04
+	webpFlagEXIF  = 0x08
+	webpFlagAlpha = 0x10
+)
+
+type riffChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// embeds the checkin metadata into a WebP as EXIF and XMP chunks. Simple
+// lossy or lossless files are converted to the extended format which is
+// required to carry metadata.
+func embedWEBPMetadata(
+	b []byte,
+	md *storage.CheckinMetadata,
+	orientation uint16,
+) ([]byte, error) {
+	em, err := newEmbeddedMetadata(md)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := splitWEBP(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		vp8x []byte
+		kept []riffChunk
+	)
+	for _, c := range chunks {
+		switch c.fourCC {
+		case "VP8X":
+			vp8x = append([]byte{}, c.payload...)
+		case "EXIF":
+			// some encoders keep the JPEG APP1 header in the EXIF chunk
+			if orientation == 0 {
+				orientation = exifOrientation(bytes.TrimPrefix(c.payload, exifHeader))
+			}
+		case "XMP ":
+			// replaced below
+		default:
+			kept = append(kept, c)
+		}
+	}
+
+	if vp8x == nil {
+		vp8x, err = newVP8X(kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(vp8x) < 10 {
+		return nil, fmt.Errorf("malformed VP8X chunk")
+	}
+	vp8x[0] |= webpFlagEXIF | webpFlagXMP
+
+	// VP8X comes first, metadata chunks go after the image data
+	out := []riffChunk{{fourCC: "VP8X", payload: vp8x}}
+	out = append(out, kept...)
+	out = append(out,
+		riffChunk{fourCC: "EXIF", payload: buildEXIF(em, orientation)},
+		riffChunk{fourCC: "XMP ", payload: buildXMP(em)},
+	)
+
+	return joinWEBP(out), nil
+}
+
+func splitWEBP(b []byte) ([]riffChunk, error) {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a webp file")
+	}
+
+	size := int(binary.LittleEndian.Uint32(b[4:]))
+	if size+8 > len(b) {
+		return nil, fmt.Errorf("truncated webp file")
+	}
+	b = b[12 : size+8]
+
+	var chunks []riffChunk
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, fmt.Errorf("malformed webp chunk header")
+		}
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if 8+n > len(b) {
+			return nil, fmt.Errorf("malformed webp chunk %q size", b[:4])
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(b[:4]), payload: b[8 : 8+n]})
+		// chunks are padded to an even size
+		n += n % 2
+		if 8+n > len(b) {
+			n = len(b) - 8
+		}
+		b = b[8+n:]
+	}
+
+	return chunks, nil
+}
+
+func joinWEBP(chunks []riffChunk) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out = append(out, c.fourCC...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c.payload)))
+		out = append(out, c.payload...)
+		if len(c.payload)%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// builds a VP8X header for a simple format file from its bitstream chunk
+func newVP8X(chunks []riffChunk) ([]byte, error) {
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("webp file has no image data")
+	}
+
+	var (
+		width, height int
+		flags         byte
+	)
+	c := chunks[0]
+	switch c.fourCC {
+	case "VP8 ":
+		// 3 bytes frame tag, then the key frame start code
+		p := c.payload
+		if len(p) < 10 || p[3] != 0x9d || p[4] != 0x01 || p[5] != 0x2a {
+			return nil, fmt.Errorf("malformed VP8 bitstream")
+		}
+		width = int(binary.LittleEndian.Uint16(p[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(p[8:]) & 0x3fff)
+	case "VP8L":
+		p := c.payload
+		if len(p) < 5 || p[0] != 0x2f {
+			return nil, fmt.Errorf("malformed VP8L bitstream")
+		}
+		bits := binary.LittleEndian.Uint32(p[1:])
+		width = int(bits&0x3fff) + 1
+		height = int((bits>>14)&0x3fff) + 1
+		if bits&(1<<28) != 0 {
+			flags |= webpFlagAlpha
+		}
+	default:
+		return nil, fmt.Errorf("unexpected webp chunk %q", c.fourCC)
+	}
+
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("invalid webp dimensions %dx%d", width, height)
+	}
+
+	v := make([]byte, 10)
+	v[0] = flags
+	putUint24(v[4:], uint32(width-1))
+	putUint24(v[7:], uint32(height-1))
+	return v, nil
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
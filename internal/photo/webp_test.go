@@ -0,0 +1,72 @@
+package photo
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func simpleWEBP(fourCC string, payload []byte) []byte {
+	return joinWEBP([]riffChunk{{fourCC: fourCC, payload: payload}})
+}
+
+func TestEmbedWEBPMetadata(t *testing.T) {
+	// lossy 640x480 key frame header
+	vp8 := []byte{0x10, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01, 0xaa, 0xbb}
+
+	out, err := embedWEBPMetadata(simpleWEBP("VP8 ", vp8), testMetadata(), 6)
+	require.NoError(t, err)
+
+	chunks, err := splitWEBP(out)
+	require.NoError(t, err)
+	require.Len(t, chunks, 4)
+
+	assert.Equal(t, "VP8X", chunks[0].fourCC)
+	assert.Equal(t, byte(webpFlagEXIF|webpFlagXMP), chunks[0].payload[0])
+	assert.Equal(t, []byte{0x7f, 0x02, 0x00}, chunks[0].payload[4:7], "canvas width - 1")
+	assert.Equal(t, []byte{0xdf, 0x01, 0x00}, chunks[0].payload[7:10], "canvas height - 1")
+
+	assert.Equal(t, "VP8 ", chunks[1].fourCC)
+	assert.Equal(t, vp8, chunks[1].payload)
+
+	assert.Equal(t, "EXIF", chunks[2].fourCC)
+	assert.Equal(t, "MM", string(chunks[2].payload[:2]))
+	assert.Equal(t, uint16(6), exifOrientation(chunks[2].payload))
+
+	assert.Equal(t, "XMP ", chunks[3].fourCC)
+	assert.Contains(t, string(chunks[3].payload), "Saison Dupont by Brasserie Dupont")
+
+	assert.Equal(t, uint32(len(out)-8), binary.LittleEndian.Uint32(out[4:]))
+
+	// embedding again keeps a single set of metadata chunks and the
+	// orientation already stored in the file
+	again, err := embedWEBPMetadata(out, testMetadata(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, out, again)
+}
+
+func TestEmbedWEBPMetadata_LosslessAlpha(t *testing.T) {
+	// 100x50 with alpha
+	bits := uint32(99) | uint32(49)<<14 | 1<<28
+	vp8l := binary.LittleEndian.AppendUint32([]byte{0x2f}, bits)
+
+	out, err := embedWEBPMetadata(simpleWEBP("VP8L", vp8l), testMetadata(), 0)
+	require.NoError(t, err)
+
+	chunks, err := splitWEBP(out)
+	require.NoError(t, err)
+
+	assert.Equal(t, byte(webpFlagEXIF|webpFlagXMP|webpFlagAlpha), chunks[0].payload[0])
+	assert.Equal(t, []byte{99, 0, 0}, chunks[0].payload[4:7])
+	assert.Equal(t, []byte{49, 0, 0}, chunks[0].payload[7:10])
+}
+
+func TestEmbedWEBPMetadata_Invalid(t *testing.T) {
+	_, err := embedWEBPMetadata([]byte("RIFF\x04\x00\x00\x00WEBP"), testMetadata(), 0)
+	assert.Error(t, err)
+
+	_, err = embedWEBPMetadata([]byte("not a webp"), testMetadata(), 0)
+	assert.Error(t, err)
+}
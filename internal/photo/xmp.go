@@ -0,0 +1,95 @@
+package photo
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	xmpPacketBegin = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n"
+	xmpPacketEnd   = "<?xpacket end=\"w\"?>"
+)
+
+// builds an XMP packet with the checkin described using the Dublin Core,
+// Photoshop, IPTC Core and EXIF schemas.
+func buildXMP(em *embeddedMetadata) []byte {
+	var b strings.Builder
+
+	b.WriteString(xmpPacketBegin)
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about=""` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+		` xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"` +
+		` xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"` +
+		` xmlns:exif="http://ns.adobe.com/exif/1.0/"`)
+
+	date := em.date.Format(time.RFC3339)
+	writeXMPAttr(&b, "xmp:CreateDate", date)
+	writeXMPAttr(&b, "photoshop:DateCreated", date)
+	writeXMPAttr(&b, "exif:DateTimeOriginal", date)
+	if em.rating > 0 {
+		writeXMPAttr(&b, "xmp:Rating", strconv.FormatFloat(em.rating, 'f', -1, 64))
+	}
+	writeXMPAttr(&b, "Iptc4xmpCore:Location", em.venue)
+	writeXMPAttr(&b, "photoshop:City", em.city)
+	writeXMPAttr(&b, "photoshop:State", em.state)
+	writeXMPAttr(&b, "photoshop:Country", em.country)
+	if em.hasGPS {
+		writeXMPAttr(&b, "exif:GPSVersionID", "2.3.0.0")
+		writeXMPAttr(&b, "exif:GPSLatitude", xmpCoordinate(em.lat, "N", "S"))
+		writeXMPAttr(&b, "exif:GPSLongitude", xmpCoordinate(em.lng, "E", "W"))
+	}
+	b.WriteString(">\n")
+
+	writeXMPAlt(&b, "dc:title", em.title)
+	writeXMPAlt(&b, "dc:description", em.description)
+	if len(em.keywords) > 0 {
+		b.WriteString("<dc:subject><rdf:Bag>")
+		for _, k := range em.keywords {
+			b.WriteString("<rdf:li>")
+			xml.EscapeText(&b, []byte(k))
+			b.WriteString("</rdf:li>")
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString(xmpPacketEnd)
+
+	return []byte(b.String())
+}
+
+func writeXMPAttr(b *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("\n " + name + `="`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString(`"`)
+}
+
+func writeXMPAlt(b *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("<" + name + `><rdf:Alt><rdf:li xml:lang="x-default">`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</rdf:li></rdf:Alt></" + name + ">\n")
+}
+
+// formats a coordinate the way XMP expects it, e.g. "51,30.0732N"
+func xmpCoordinate(coord float64, pos, neg string) string {
+	ref := pos
+	if coord < 0 {
+		ref = neg
+	}
+	coord = math.Abs(coord)
+	deg := math.Floor(coord)
+	return fmt.Sprintf("%d,%.6f%s", int(deg), (coord-deg)*60, ref)
+}
@@ -2,33 +2,200 @@ package processor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// returned by the processing function for an item it had nothing to do for
+var ErrSkipped = errors.New("skipped")
+
+// error processing an item, Index is its position in the items
+type ItemError[T any] struct {
+	Index int
+	Item  T
+	Err   error
+}
+
+func (e *ItemError[T]) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError[T]) Unwrap() error {
+	return e.Err
+}
+
+// number of items per outcome
+type Counts struct {
+	Succeeded int
+	Failed    int
+	// skipped by the processing function, or never processed as the
+	// processing stopped before them
+	Skipped int
+}
+
+func (c *Counts) Add(other Counts) {
+	c.Succeeded += other.Succeeded
+	c.Failed += other.Failed
+	c.Skipped += other.Skipped
+}
+
+func (c Counts) Total() int {
+	return c.Succeeded + c.Failed + c.Skipped
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%d succeeded, %d failed, %d skipped", c.Succeeded, c.Failed, c.Skipped)
+}
+
+// outcome of processing the items
+type Result[T any] struct {
+	Counts
+	// errors of the failed items, in the order of the items
+	Errors []*ItemError[T]
+}
+
+// the errors of the failed items joined, nil when none failed
+func (r *Result[T]) Err() error {
+	errs := make([]error, len(r.Errors))
+	for i, err := range r.Errors {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+type options struct {
+	failFast    bool
+	gracePeriod time.Duration
+}
+
+type Option func(*options)
+
+// stops scheduling items after the first failure, the ones in flight are
+// left to finish. By default the processing carries on with the others.
+func FailFast() Option {
+	return func(o *options) {
+		o.failFast = true
+	}
+}
+
+// lets the items in flight finish for up to d once ctx is done, rather than
+// cancelling them with it
+func GracePeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.gracePeriod = d
+	}
+}
+
+// a context which outlives ctx by the grace period. stop releases it.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, func()) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return graceCtx, func() {
+		close(done)
+		cancel()
+	}
+}
+
+// processes the items with up to numWorkers at once, or all at once when
+// numWorkers is not positive. No new item is scheduled once ctx is done.
 func Process[T any](
 	ctx context.Context,
 	items []T,
 	numWorkers int,
-	processFn func(ctx context.Context, item T),
-) {
-	g, ctx := errgroup.WithContext(ctx)
+	processFn func(ctx context.Context, item T) error,
+	opts ...Option,
+) *Result[T] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// the items get the grace period, the scheduling stops with ctx
+	itemCtx := ctx
+	if o.gracePeriod > 0 {
+		var stop func()
+		itemCtx, stop = graceContext(ctx, o.gracePeriod)
+		defer stop()
+	}
+
+	var g errgroup.Group
 	if numWorkers > 0 {
 		g.SetLimit(numWorkers)
 	}
 
-	for _, item := range items {
-		item := item // capture
+	var (
+		mu      sync.Mutex
+		result  Result[T]
+		stopped atomic.Bool
+	)
+	stopping := func() bool {
+		return stopped.Load() || ctx.Err() != nil
+	}
+
+	for i, item := range items {
+		// g.Go waits for a free worker, check again once it has one
+		if stopping() {
+			mu.Lock()
+			result.Skipped += len(items) - i
+			mu.Unlock()
+			break
+		}
+
 		g.Go(func() error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
+			if stopping() {
+				mu.Lock()
+				result.Skipped++
+				mu.Unlock()
+				return nil
+			}
+
+			err := processFn(itemCtx, item)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result.Succeeded++
+			case errors.Is(err, ErrSkipped):
+				result.Skipped++
 			default:
+				result.Failed++
+				result.Errors = append(result.Errors, &ItemError[T]{Index: i, Item: item, Err: err})
+				if o.failFast {
+					stopped.Store(true)
+				}
 			}
-			processFn(ctx, item)
 			return nil
 		})
 	}
 
 	_ = g.Wait()
+
+	slices.SortFunc(result.Errors, func(a, b *ItemError[T]) int {
+		return a.Index - b.Index
+	})
+	return &result
 }
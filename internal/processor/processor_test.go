@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcess(t *testing.T) {
+	errOdd := errors.New("odd")
+
+	result := Process(context.Background(), []int{1, 2, 3, 4, 5, 6}, 2, func(ctx context.Context, n int) error {
+		switch {
+		case n == 6:
+			return ErrSkipped
+		case n%2 == 1:
+			return errOdd
+		default:
+			return nil
+		}
+	})
+
+	want := Counts{Succeeded: 2, Failed: 3, Skipped: 1}
+	if result.Counts != want {
+		t.Errorf("expected %v, got %v", want, result.Counts)
+	}
+
+	if len(result.Errors) != 3 {
+		t.Fatalf("expected 3 errors, got %d", len(result.Errors))
+	}
+	for i, index := range []int{0, 2, 4} {
+		if result.Errors[i].Index != index || result.Errors[i].Item != index+1 {
+			t.Errorf("unexpected error %d: %+v", i, result.Errors[i])
+		}
+	}
+
+	if !errors.Is(result.Err(), errOdd) {
+		t.Errorf("expected the joined error to wrap %v, got %v", errOdd, result.Err())
+	}
+}
+
+func TestProcess_NoErrors(t *testing.T) {
+	result := Process(context.Background(), []int{1, 2}, 0, func(ctx context.Context, n int) error {
+		return nil
+	})
+
+	if result.Succeeded != 2 || result.Err() != nil {
+		t.Errorf("expected 2 items to succeed, got %v: %v", result.Counts, result.Err())
+	}
+}
+
+func TestProcess_FailFast(t *testing.T) {
+	var processed []int
+
+	result := Process(context.Background(), []int{1, 2, 3, 4}, 1, func(ctx context.Context, n int) error {
+		processed = append(processed, n)
+		if n == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	}, FailFast())
+
+	want := Counts{Succeeded: 1, Failed: 1, Skipped: 2}
+	if result.Counts != want {
+		t.Errorf("expected %v, got %v", want, result.Counts)
+	}
+	if len(processed) != 2 {
+		t.Errorf("expected the items after the failure to be left alone, processed %v", processed)
+	}
+}
+
+func TestProcess_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := Process(ctx, []int{1, 2, 3}, 1, func(ctx context.Context, n int) error {
+		t.Errorf("expected item %d not to be processed", n)
+		return nil
+	})
+
+	if result.Skipped != 3 {
+		t.Errorf("expected 3 items to be skipped, got %v", result.Counts)
+	}
+}
+
+func TestProcess_GracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var itemErr error
+	result := Process(ctx, []int{1, 2, 3}, 1, func(itemCtx context.Context, n int) error {
+		// stopped while the first item is in flight
+		cancel()
+		<-ctx.Done()
+		itemErr = itemCtx.Err()
+		return nil
+	}, GracePeriod(time.Minute))
+
+	if itemErr != nil {
+		t.Errorf("expected the item in flight to keep its context, got %v", itemErr)
+	}
+	want := Counts{Succeeded: 1, Skipped: 2}
+	if result.Counts != want {
+		t.Errorf("expected %v, got %v", want, result.Counts)
+	}
+}
+
+func TestProcess_GracePeriodExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := Process(ctx, []int{1}, 1, func(itemCtx context.Context, n int) error {
+		cancel()
+		<-itemCtx.Done()
+		return itemCtx.Err()
+	}, GracePeriod(time.Millisecond))
+
+	if result.Failed != 1 || !errors.Is(result.Err(), context.Canceled) {
+		t.Errorf("expected the item to be cancelled once the grace period is over, got %v: %v", result.Counts, result.Err())
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import "time"
+
+type BadgeRecord struct {
+	BadgeID     uint64 `json:"badge_id"`
+	UserBadgeID uint64 `json:"user_badge_id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	// key of the archived image, empty until it is stored
+	Image string `json:"image,omitempty"`
+}
+
+// a badge earned with a checkin, stored under badges/<badge_id>/ so the
+// checkins which earned a badge can be listed
+type BadgeEarn struct {
+	BadgeID     uint64    `json:"badge_id"`
+	UserBadgeID uint64    `json:"user_badge_id,omitempty"`
+	Name        string    `json:"name"`
+	CheckinID   uint64    `json:"checkin_id"`
+	EarnedAt    time.Time `json:"earned_at"`
+	// key of the record of the checkin
+	Record string `json:"record"`
+}
+
+// key the image of the badge is archived under, empty when it has none
+func (b *BadgeRecord) ImageArchiveKey() string {
+	if b.ImageURL == "" {
+		return ""
+	}
+	return badgeImageKey(b.BadgeID, b.ImageURL)
+}
+
+// the badges earned with the checkin
+func (r *CheckinRecord) BadgeEarns() []*BadgeEarn {
+	earns := make([]*BadgeEarn, 0, len(r.Badges))
+	for _, b := range r.Badges {
+		earns = append(earns, &BadgeEarn{
+			BadgeID:     b.BadgeID,
+			UserBadgeID: b.UserBadgeID,
+			Name:        b.Name,
+			CheckinID:   r.CheckinID,
+			EarnedAt:    r.CreatedAt,
+			Record:      recordKey(r.CheckinID, r.CreatedAt),
+		})
+	}
+	return earns
+}
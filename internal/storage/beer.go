@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// beer details from the Untappd beer/info endpoint, cached under
+// beers/<bid>.json so each beer is only fetched once
+type BeerProfile struct {
+	BID         uint64  `json:"bid"`
+	Name        string  `json:"name"`
+	Style       string  `json:"style,omitempty"`
+	ABV         float64 `json:"abv"`
+	IBU         float64 `json:"ibu,omitempty"`
+	Description string  `json:"description,omitempty"`
+	LabelURL    string  `json:"label_url,omitempty"`
+	LabelHDURL  string  `json:"label_hd_url,omitempty"`
+	RatingScore float64 `json:"rating_score,omitempty"`
+	RatingCount int     `json:"rating_count,omitempty"`
+	BreweryID   uint64  `json:"brewery_id,omitempty"`
+	// key of the archived label, empty until it is stored so a later run
+	// retries it
+	LabelKey  string    `json:"label_key,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func BeerProfileFromInfo(b *untappd.BeerInfo, fetchedAt time.Time) *BeerProfile {
+	return &BeerProfile{
+		BID:         b.BID,
+		Name:        b.BeerName,
+		Style:       b.BeerStyle,
+		ABV:         b.BeerABV,
+		IBU:         b.BeerIBU,
+		Description: b.BeerDescription,
+		LabelURL:    b.BeerLabel,
+		LabelHDURL:  b.BeerLabelHD,
+		RatingScore: b.RatingScore,
+		RatingCount: b.RatingCount,
+		BreweryID:   b.Brewery.BreweryID,
+		FetchedAt:   fetchedAt,
+	}
+}
+
+// URL of the label to archive, the HD one when there is one
+func (p *BeerProfile) ArchiveLabelURL() string {
+	if p.LabelHDURL != "" {
+		return p.LabelHDURL
+	}
+	return p.LabelURL
+}
+
+// key the label of the beer is archived under, empty when it has none
+func (p *BeerProfile) LabelArchiveKey() string {
+	labelURL := p.ArchiveLabelURL()
+	if labelURL == "" {
+		return ""
+	}
+	return labelKey(p.BID, labelURL)
+}
+
+// fills the beer of the record with the details of the profile
+func (r *CheckinRecord) ApplyBeer(p *BeerProfile) {
+	if p.IBU != 0 {
+		r.Beer.IBU = p.IBU
+	}
+	r.Beer.Description = p.Description
+	r.Beer.LabelURL = p.LabelURL
+	r.Beer.LabelHDURL = p.LabelHDURL
+	r.Beer.GlobalRatingScore = p.RatingScore
+	r.Beer.RatingCount = p.RatingCount
+	r.Beer.Label = p.LabelKey
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// brewery details from the Untappd brewery/info endpoint, cached under
+// breweries/<id>.json next to the brewery logo
+type BreweryProfile struct {
+	BreweryID   uint64 `json:"brewery_id"`
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	Country     string `json:"country,omitempty"`
+	City        string `json:"city,omitempty"`
+	State       string `json:"state,omitempty"`
+	Website     string `json:"website,omitempty"`
+	LogoURL     string `json:"logo_url,omitempty"`
+	// key of the archived logo, empty until it is stored so a later run
+	// retries it
+	LogoKey   string    `json:"logo_key,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func BreweryProfileFromInfo(b *untappd.BreweryInfo, fetchedAt time.Time) *BreweryProfile {
+	return &BreweryProfile{
+		BreweryID:   b.BreweryID,
+		Name:        b.BreweryName,
+		Type:        b.BreweryType,
+		Description: b.BreweryDescription,
+		Country:     b.CountryName,
+		City:        b.Location.BreweryCity,
+		State:       b.Location.BreweryState,
+		Website:     b.Contact.URL,
+		LogoURL:     b.BreweryLabel,
+		FetchedAt:   fetchedAt,
+	}
+}
+
+// key the logo of the brewery is archived under, empty when it has none
+func (p *BreweryProfile) LogoArchiveKey() string {
+	if p.LogoURL == "" {
+		return ""
+	}
+	return breweryLogoKey(p.BreweryID, p.LogoURL)
+}
+
+// fills the brewery of the record with the details of the profile, and
+// references the profile so the archive can link to it
+func (r *CheckinRecord) ApplyBrewery(p *BreweryProfile) {
+	b := &r.Brewery
+	b.Type = p.Type
+	if p.City != "" {
+		b.City = p.City
+	}
+	if p.State != "" {
+		b.State = p.State
+	}
+	if p.Website != "" {
+		b.URL = p.Website
+	}
+	b.Profile = breweryKey(p.BreweryID)
+	b.Logo = p.LogoKey
+}
@@ -3,17 +3,20 @@ package storage
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/url"
+	"mime"
+	"net/http"
+	"os"
 	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -28,13 +31,17 @@ type Client struct {
 }
 
 func NewClient(ctx context.Context, cfg *config.Config) (*Client, error) {
-	switch {
-	case cfg.R2AccountID != "":
+	if cfg.BucketName == "" {
+		return nil, fmt.Errorf("no bucket name configured")
+	}
+
+	switch p := cfg.Provider(); p {
+	case config.ProviderR2:
 		return newR2Client(ctx, cfg)
-	case cfg.AWSRegion != "":
+	case config.ProviderS3:
 		return newS3Client(ctx, cfg)
 	default:
-		return nil, fmt.Errorf("no storage provider configured")
+		return nil, fmt.Errorf("storage provider %q is not backed by a bucket", p)
 	}
 }
 
@@ -79,65 +86,389 @@ func newR2Client(ctx context.Context, cfg *config.Config) (*Client, error) {
 }
 
 func newS3Client(ctx context.Context, cfg *config.Config) (*Client, error) {
-	awsCfg, err := awsconfig.LoadDefaultConfig(
-		ctx,
-		awsconfig.WithRegion(cfg.AWSRegion),
-	)
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(cfg.S3Region()),
+	}
+
+	if cfg.S3AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(
+				cfg.S3AccessKeyID,
+				cfg.S3SecretAccessKey,
+				"",
+			),
+		))
+	}
+
+	if cfg.S3CABundle != "" {
+		bundle, err := os.ReadFile(cfg.S3CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %q: %w", cfg.S3CABundle, err)
+		}
+		opts = append(opts, awsconfig.WithCustomCABundle(bytes.NewReader(bundle)))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config for S3: %w", err)
 	}
 
 	return &Client{
-		s3Client:   s3.NewFromConfig(awsCfg),
+		s3Client:   s3.NewFromConfig(awsCfg, s3Options(cfg)),
 		bucketName: cfg.BucketName,
 	}, nil
 }
 
+// options pointing the client at an S3 compatible server (MinIO, Backblaze
+// B2, Wasabi, Garage...) when an endpoint is configured.
+func s3Options(cfg *config.Config) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.UsePathStyle = cfg.S3UsePathStyle
+		if cfg.S3Endpoint == "" {
+			return
+		}
+		o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+		// most compatible servers reject the checksums the SDK now sends
+		// by default
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}
+}
+
 func (c *Client) UploadJPG(ctx context.Context, file []byte, md *CheckinMetadata) error {
-	t, err := time.Parse(time.RFC1123Z, md.Date)
+	key, err := metadataPhotoKey(md, formatJPG)
 	if err != nil {
-		return fmt.Errorf("parse checkin date %q: %w", md.Date, err)
+		return err
 	}
+	return c.uploadPhoto(ctx, key, file, md, "image/jpeg")
+}
 
-	// YYYY/MM/DD/id.jpg
-	key := path.Join(
-		t.Format("2006/01/02"),
-		fmt.Sprintf("%s.jpg", md.ID),
-	)
+func (c *Client) UploadWEBP(ctx context.Context, file []byte, md *CheckinMetadata) error {
+	key, err := metadataPhotoKey(md, formatWEBP)
+	if err != nil {
+		return err
+	}
+	return c.uploadPhoto(ctx, key, file, md, "image/webp")
+}
 
-	_, err = c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+func (c *Client) uploadPhoto(
+	ctx context.Context,
+	key string,
+	file []byte,
+	md *CheckinMetadata,
+	contentType string,
+) error {
+	overflowKey := metadataOverflowKey(key)
+	metadata, overflow := encodeMetadata(md.ToMap(), overflowKey)
+	if len(overflow) > 0 {
+		if err := c.uploadOverflow(ctx, overflowKey, overflow); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(c.bucketName),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(file),
-		Metadata:    md.ToMap(),
-		ContentType: aws.String("image/jpeg"),
+		Metadata:    metadata,
+		ContentType: aws.String(contentType),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload object %q: %w", key, err)
 	}
 
+	// the metadata fits now, drop the overflow of a previous upload
+	if len(overflow) == 0 {
+		return c.deleteObject(ctx, overflowKey)
+	}
 	return nil
 }
 
-func (c *Client) UploadWEBP(ctx context.Context, file []byte, md *CheckinMetadata) error {
-	t, err := time.Parse(time.RFC1123Z, md.Date)
+// stores the checkin metadata which does not fit in the object metadata of a
+// photo
+func (c *Client) uploadOverflow(ctx context.Context, key string, overflow map[string]string) error {
+	b, err := json.Marshal(overflow)
 	if err != nil {
-		return fmt.Errorf("parse checkin date %q: %w", md.Date, err)
+		return fmt.Errorf("failed to encode metadata overflow: %w", err)
 	}
 
-	// YYYY/MM/DD/WEBP/id.webp
-	key := path.Join(
-		t.Format("2006/01/02"),
-		"WEBP",
-		fmt.Sprintf("%s.webp", md.ID),
-	)
+	_, err = c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object %q: %w", key, err)
+	}
+	return nil
+}
+
+// no error when the object is already gone
+func (c *Client) deleteObject(ctx context.Context, key string) error {
+	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object %q: %w", key, err)
+	}
+	return nil
+}
+
+// reads back the checkin metadata of a photo object, decoding the values
+// and merging in the overflow object when there is one.
+func (c *Client) DownloadMetadata(ctx context.Context, key string) (*CheckinMetadata, error) {
+	h, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nfe *types.NotFound
+		if errors.As(err, &nfe) {
+			return nil, fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to head %q: %w", key, err)
+	}
+
+	m, err := decodeMetadata(h.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata on %q: %w", key, err)
+	}
+
+	if overflowKey := m[metaKeyOverflow]; overflowKey != "" {
+		b, err := c.Download(ctx, overflowKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download object %q: %w", overflowKey, err)
+		}
+
+		var overflow map[string]string
+		if err := json.Unmarshal(b, &overflow); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata overflow %q: %w", overflowKey, err)
+		}
+		for k, v := range overflow {
+			m[k] = v
+		}
+	}
+
+	return CheckinMetadataFromMap(m), nil
+}
+
+// stores v as an indented JSON document
+func (c *Client) putJSON(ctx context.Context, key string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", key, err)
+	}
 
 	_, err = c.s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(c.bucketName),
 		Key:         aws.String(key),
-		Body:        bytes.NewReader(file),
-		Metadata:    md.ToMap(),
-		ContentType: aws.String("image/webp"),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// reads the JSON document at key into v, returns ErrNotFound when missing
+func (c *Client) getJSON(ctx context.Context, key string, v any) error {
+	b, err := c.Download(ctx, key)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return fmt.Errorf("failed to download object %q: %w", key, err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to decode %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (c *Client) UploadRecord(ctx context.Context, record *CheckinRecord) error {
+	return c.putJSON(ctx, recordKey(record.CheckinID, record.CreatedAt), record)
+}
+
+func (c *Client) DownloadRecord(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+) (*CheckinRecord, error) {
+	var record CheckinRecord
+	if err := c.getJSON(ctx, recordKey(checkinID, createdAt), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// IDs of the checkins with a record stored on the day
+func (c *Client) ListRecords(ctx context.Context, day time.Time) ([]uint64, error) {
+	prefix := dayPrefix(day) + "/"
+
+	var ids []uint64
+	p := s3.NewListObjectsV2Paginator(c.s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if id, ok := recordID(strings.TrimPrefix(aws.ToString(obj.Key), prefix)); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func (c *Client) UploadBeer(ctx context.Context, beer *BeerProfile) error {
+	return c.putJSON(ctx, beerKey(beer.BID), beer)
+}
+
+func (c *Client) DownloadBeer(ctx context.Context, bid uint64) (*BeerProfile, error) {
+	var beer BeerProfile
+	if err := c.getJSON(ctx, beerKey(bid), &beer); err != nil {
+		return nil, err
+	}
+	return &beer, nil
+}
+
+func (c *Client) UploadBrewery(ctx context.Context, brewery *BreweryProfile) error {
+	return c.putJSON(ctx, breweryKey(brewery.BreweryID), brewery)
+}
+
+func (c *Client) DownloadBrewery(ctx context.Context, breweryID uint64) (*BreweryProfile, error) {
+	var brewery BreweryProfile
+	if err := c.getJSON(ctx, breweryKey(breweryID), &brewery); err != nil {
+		return nil, err
+	}
+	return &brewery, nil
+}
+
+func (c *Client) UploadBreweryLogo(ctx context.Context, key string, logo []byte) error {
+	return c.putImage(ctx, key, logo)
+}
+
+func (c *Client) UploadLabel(ctx context.Context, key string, label []byte) error {
+	return c.putImage(ctx, key, label)
+}
+
+func (c *Client) UploadBadgeImage(ctx context.Context, key string, image []byte) error {
+	return c.putImage(ctx, key, image)
+}
+
+func (c *Client) UploadBadgeEarn(ctx context.Context, earn *BadgeEarn) error {
+	return c.putJSON(ctx, badgeEarnKey(earn.BadgeID, earn.CheckinID), earn)
+}
+
+func (c *Client) ImageExists(ctx context.Context, key string) (bool, error) {
+	return c.exists(ctx, key)
+}
+
+func (c *Client) UploadFailure(ctx context.Context, failure *Failure) error {
+	return c.putJSON(ctx, failureKey(failure.Source, failure.CheckinID), failure)
+}
+
+func (c *Client) DownloadFailure(ctx context.Context, source string, checkinID uint64) (*Failure, error) {
+	var failure Failure
+	if err := c.getJSON(ctx, failureKey(source, checkinID), &failure); err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// IDs of the checkins of the source which failed to be stored
+func (c *Client) ListFailures(ctx context.Context, source string) ([]uint64, error) {
+	prefix := failurePrefix(source)
+
+	var ids []uint64
+	p := s3.NewListObjectsV2Paginator(c.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if id, ok := recordID(strings.TrimPrefix(aws.ToString(obj.Key), prefix)); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// no error when the failure is already gone
+func (c *Client) DeleteFailure(ctx context.Context, source string, checkinID uint64) error {
+	return c.deleteObject(ctx, failureKey(source, checkinID))
+}
+
+// archives an API response under raw/, see rawKey
+func (c *Client) UploadRawResponse(ctx context.Context, raw *untappd.RawResponse) error {
+	return c.putJSON(ctx, rawKey(raw), raw)
+}
+
+// keys of the archived responses of an API method, oldest first
+func (c *Client) ListRawResponses(ctx context.Context, method string) ([]string, error) {
+	prefix := rawPrefix(method)
+
+	var keys []string
+	p := s3.NewListObjectsV2Paginator(c.s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".json") {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	// listed in lexical order, which is the fetch order
+	return keys, nil
+}
+
+func (c *Client) DownloadRawResponse(ctx context.Context, key string) (*untappd.RawResponse, error) {
+	var raw untappd.RawResponse
+	if err := c.getJSON(ctx, key, &raw); err != nil {
+		return nil, err
+	}
+	return &raw, nil
+}
+
+// stores an image, typed after the extension of its key
+func (c *Client) putImage(ctx context.Context, key string, b []byte) error {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
+	_, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String(contentType),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload object %q: %w", key, err)
@@ -146,6 +477,87 @@ func (c *Client) UploadWEBP(ctx context.Context, file []byte, md *CheckinMetadat
 	return nil
 }
 
+func (c *Client) GetHistoryProgress(ctx context.Context) (*HistoryProgress, error) {
+	return getStateHistoryProgress(ctx, c)
+}
+
+func (c *Client) UpdateHistoryProgress(ctx context.Context, progress *HistoryProgress) error {
+	return updateHistoryProgress(ctx, c, progress)
+}
+
+func (c *Client) GetState(ctx context.Context) (*State, error) {
+	return getState(ctx, c)
+}
+
+func (c *Client) UpdateState(ctx context.Context, update func(*State) error) error {
+	return updateState(ctx, c, update)
+}
+
+// the version is the ETag of the object
+func (c *Client) getVersioned(ctx context.Context, key string, v any) (string, error) {
+	output, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return "", fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return "", fmt.Errorf("failed to download object %q: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	if err := json.NewDecoder(output.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("failed to decode %q: %w", key, err)
+	}
+
+	return aws.ToString(output.ETag), nil
+}
+
+// conditional write, with If-Match on the ETag or If-None-Match when the
+// object should not exist yet
+func (c *Client) putVersioned(ctx context.Context, key string, v any, version string) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", key, err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(c.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	}
+	if version == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(version)
+	}
+
+	if _, err := c.s3Client.PutObject(ctx, input); err != nil {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) {
+			switch respErr.HTTPStatusCode() {
+			// 409 when another conditional write is in flight
+			case http.StatusPreconditionFailed, http.StatusConflict:
+				return fmt.Errorf("%w: %q", errStateConflict, key)
+			}
+		}
+		return fmt.Errorf("failed to upload object %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (c *Client) legacyState(ctx context.Context) (*State, error) {
+	id, err := c.legacyLatestCheckinID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return migrateState(ctx, id, c.getJSON)
+}
+
 func (c *Client) Download(ctx context.Context, fileName string) ([]byte, error) {
 	output, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &c.bucketName,
@@ -182,11 +594,25 @@ func (c *Client) CopyObject(
 	return c.s3Client.CopyObject(ctx, params, optFns...)
 }
 
-const (
-	latestKey = "latest.jpg"
-)
+func (c *Client) DeleteObject(
+	ctx context.Context,
+	params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteObjectOutput, error) {
+	return c.s3Client.DeleteObject(ctx, params, optFns...)
+}
 
 func (c *Client) GetLatestCheckinID(ctx context.Context) (uint64, error) {
+	return getLatestCheckinID(ctx, c)
+}
+
+func (c *Client) UpdateLatestCheckinID(ctx context.Context, checkin untappd.Checkin) error {
+	return updateLatestCheckinID(ctx, c, checkin)
+}
+
+// ID stored in the metadata of latest.jpg, the cursor before state.json. 0
+// when there is none.
+func (c *Client) legacyLatestCheckinID(ctx context.Context) (uint64, error) {
 	const metaKeyID = "id"
 
 	h, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -196,7 +622,6 @@ func (c *Client) GetLatestCheckinID(ctx context.Context) (uint64, error) {
 	if err != nil {
 		var nfe *types.NotFound
 		if errors.As(err, &nfe) {
-			log.Println("Latest key not found, starting from scratch")
 			return 0, nil
 		}
 		return 0, fmt.Errorf("failed to head %q: %w", latestKey, err)
@@ -217,71 +642,53 @@ func (c *Client) GetLatestCheckinID(ctx context.Context) (uint64, error) {
 		return 0, fmt.Errorf(`invalid "%s" metadata value %q on %q: %w`, metaKeyID, s, latestKey, err)
 	}
 
-	log.Printf("Latest stored checkinID is: %d\n", id)
 	return id, nil
 }
 
-func (c *Client) UpdateLatestCheckinID(ctx context.Context, checkin untappd.Checkin) error {
-	t, err := time.Parse(time.RFC1123Z, checkin.CreatedAt)
-	if err != nil {
-		return fmt.Errorf("failed to parse checkin date %q: %w", checkin.CreatedAt, err)
-	}
-
-	dir := t.Format("2006/01/02")
-	key := path.Join(dir, fmt.Sprintf("%d.jpg", checkin.CheckinID))
+func (c *Client) CheckinExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	return c.checkinExists(ctx, checkinID, createdAt, photo, formatJPG)
+}
 
-	copySource := c.bucketName + "/" + url.PathEscape(key)
+func (c *Client) CheckinWEBPExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	return c.checkinExists(ctx, checkinID, createdAt, photo, formatWEBP)
+}
 
-	_, err = c.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:            aws.String(c.bucketName),
-		Key:               aws.String(latestKey),
-		CopySource:        aws.String(copySource),
-		MetadataDirective: types.MetadataDirectiveReplace,
-		Metadata: map[string]string{
-			"id":         strconv.FormatUint(checkin.CheckinID, 10),
-			"created_at": t.Format(time.RFC3339),
-		},
-		ContentType: aws.String("image/jpeg"),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to copy %q to %q: %w", key, latestKey, err)
+// removes the JPG and WEBP of a photo along with their metadata overflow, no
+// error when they are already gone
+func (c *Client) DeletePhoto(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) error {
+	for _, format := range []string{formatJPG, formatWEBP} {
+		key := photoKey(strconv.FormatUint(checkinID, 10), photo, createdAt, format)
+		for _, k := range []string{key, metadataOverflowKey(key)} {
+			if err := c.deleteObject(ctx, k); err != nil {
+				return err
+			}
+		}
 	}
-
 	return nil
 }
 
-func (c *Client) CheckinExists(ctx context.Context, checkinID, createdAt string) (bool, error) {
-	return c.checkinExists(ctx, checkinID, createdAt, "jpg")
-}
-
-func (c *Client) CheckinWEBPExists(ctx context.Context, checkinID, createdAt string) (bool, error) {
-	return c.checkinExists(ctx, checkinID, createdAt, "webp")
+func (c *Client) checkinExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+	format string,
+) (bool, error) {
+	return c.exists(ctx, photoKey(strconv.FormatUint(checkinID, 10), photo, createdAt, format))
 }
 
-func (c *Client) checkinExists(ctx context.Context, checkinID, createdAt string, format string) (bool, error) {
-	t, err := time.Parse("2006-01-02 15:04:05", createdAt)
-	if err != nil {
-		return false, fmt.Errorf("parse checkin date %q: %w", createdAt, err)
-	}
-
-	var key string
-	switch format {
-	case "webp":
-		// YYYY/MM/DD/WEBP/id.webp
-		key = path.Join(
-			t.Format("2006/01/02"),
-			"WEBP",
-			fmt.Sprintf("%s.webp", checkinID),
-		)
-	default:
-		// YYYY/MM/DD/id.jpg
-		key = path.Join(
-			t.Format("2006/01/02"),
-			fmt.Sprintf("%s.jpg", checkinID),
-		)
-	}
-
-	_, err = c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+func (c *Client) exists(ctx context.Context, key string) (bool, error) {
+	_, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(c.bucketName),
 		Key:    aws.String(key),
 	})
@@ -2,9 +2,14 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/smallwat3r/untappd-recorder/internal/config"
@@ -41,6 +46,12 @@ type mockS3Client struct {
 		params *s3.ListObjectsV2Input,
 		optFns ...func(*s3.Options),
 	) (*s3.ListObjectsV2Output, error)
+
+	deleteObject func(
+		ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectOutput, error)
 }
 
 func (m *mockS3Client) PutObject(
@@ -67,6 +78,14 @@ func (m *mockS3Client) CopyObject(
 	return m.copyObject(ctx, params, optFns...)
 }
 
+func (m *mockS3Client) DeleteObject(
+	ctx context.Context,
+	params *s3.DeleteObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteObjectOutput, error) {
+	return m.deleteObject(ctx, params, optFns...)
+}
+
 func (m *mockS3Client) ListObjectsV2(
 	ctx context.Context,
 	params *s3.ListObjectsV2Input,
@@ -104,6 +123,13 @@ func TestClient_UploadJPG(t *testing.T) {
 			}
 			return &s3.PutObjectOutput{}, nil
 		},
+		deleteObject: func(
+			ctx context.Context,
+			params *s3.DeleteObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.DeleteObjectOutput, error) {
+			return &s3.DeleteObjectOutput{}, nil
+		},
 	}
 
 	client := &Client{
@@ -134,6 +160,13 @@ func TestClient_UploadWEBP(t *testing.T) {
 		) (*s3.PutObjectOutput, error) {
 			return &s3.PutObjectOutput{}, nil
 		},
+		deleteObject: func(
+			ctx context.Context,
+			params *s3.DeleteObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.DeleteObjectOutput, error) {
+			return &s3.DeleteObjectOutput{}, nil
+		},
 	}
 
 	client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
@@ -144,6 +177,8 @@ func TestClient_UploadWEBP(t *testing.T) {
 }
 
 func TestClient_CheckinWEBPExists(t *testing.T) {
+	createdAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
 	t.Run("WEBP_exists", func(t *testing.T) {
 		mockClient := &mockS3Client{
 			headObject: func(
@@ -151,12 +186,13 @@ func TestClient_CheckinWEBPExists(t *testing.T) {
 				params *s3.HeadObjectInput,
 				optFns ...func(*s3.Options),
 			) (*s3.HeadObjectOutput, error) {
+				assert.Equal(t, "2025/11/01/WEBP/123-2.webp", *params.Key)
 				return &s3.HeadObjectOutput{}, nil
 			},
 		}
 
 		client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
-		exists, err := client.CheckinWEBPExists(context.Background(), "123", "2025-11-01 00:00:00")
+		exists, err := client.CheckinWEBPExists(context.Background(), 123, createdAt, 2)
 
 		assert.NoError(t, err)
 		assert.True(t, exists)
@@ -174,7 +210,7 @@ func TestClient_CheckinWEBPExists(t *testing.T) {
 		}
 
 		client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
-		exists, err := client.CheckinWEBPExists(context.Background(), "123", "2025-11-01 00:00:00")
+		exists, err := client.CheckinWEBPExists(context.Background(), 123, createdAt, 1)
 
 		assert.NoError(t, err)
 		assert.False(t, exists)
@@ -192,12 +228,38 @@ func TestClient_CheckinWEBPExists(t *testing.T) {
 		}
 
 		client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
-		_, err := client.CheckinWEBPExists(context.Background(), "123", "2025-11-01 00:00:00")
+		_, err := client.CheckinWEBPExists(context.Background(), 123, createdAt, 1)
 
 		assert.Error(t, err)
 	})
 }
 
+func TestClient_DeletePhoto(t *testing.T) {
+	var deleted []string
+	mockClient := &mockS3Client{
+		deleteObject: func(
+			ctx context.Context,
+			params *s3.DeleteObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.DeleteObjectOutput, error) {
+			deleted = append(deleted, *params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+
+	client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
+	createdAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	err := client.DeletePhoto(context.Background(), 123, createdAt, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"2025/11/01/123-2.jpg",
+		"2025/11/01/123-2.jpg.meta.json",
+		"2025/11/01/WEBP/123-2.webp",
+		"2025/11/01/WEBP/123-2.webp.meta.json",
+	}, deleted)
+}
+
 func TestNewClient_R2(t *testing.T) {
 	cfg := &config.Config{
 		R2AccountID:       "test-account-id",
@@ -223,3 +285,116 @@ func TestNewClient_S3(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestNewClient_S3Compatible(t *testing.T) {
+	cfg := &config.Config{
+		StorageProvider:   config.ProviderS3,
+		S3Endpoint:        "http://localhost:9000",
+		S3UsePathStyle:    true,
+		S3SigningRegion:   "garage",
+		S3AccessKeyID:     "test-key-id",
+		S3SecretAccessKey: "test-key-secret",
+		BucketName:        "test-bucket",
+	}
+
+	client, err := NewClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	opts := client.s3Client.(*s3.Client).Options()
+	assert.Equal(t, "http://localhost:9000", aws.ToString(opts.BaseEndpoint))
+	assert.True(t, opts.UsePathStyle)
+	assert.Equal(t, "garage", opts.Region)
+	assert.Equal(t, aws.RequestChecksumCalculationWhenRequired, opts.RequestChecksumCalculation)
+
+	creds, err := opts.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "test-key-id", creds.AccessKeyID)
+}
+
+func TestNewClient_MissingCABundle(t *testing.T) {
+	cfg := &config.Config{
+		S3Endpoint: "https://localhost:9000",
+		S3CABundle: "testdata/does-not-exist.pem",
+		BucketName: "test-bucket",
+	}
+
+	_, err := NewClient(context.Background(), cfg)
+	assert.Error(t, err)
+}
+
+func TestClient_UploadRecord(t *testing.T) {
+	var body []byte
+	mockClient := &mockS3Client{
+		putObject: func(
+			ctx context.Context,
+			params *s3.PutObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.PutObjectOutput, error) {
+			assert.Equal(t, "2025/11/01/123.json", *params.Key)
+			assert.Equal(t, "application/json", *params.ContentType)
+			body, _ = io.ReadAll(params.Body)
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+
+	client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
+	record := &CheckinRecord{
+		CheckinID: 123,
+		CreatedAt: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC),
+		Beer:      BeerRecord{Name: "Test Beer"},
+	}
+
+	err := client.UploadRecord(context.Background(), record)
+	assert.NoError(t, err)
+
+	var decoded CheckinRecord
+	assert.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, "Test Beer", decoded.Beer.Name)
+}
+
+func TestClient_DownloadRecord(t *testing.T) {
+	createdAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Record_exists", func(t *testing.T) {
+		mockClient := &mockS3Client{
+			getObject: func(
+				ctx context.Context,
+				params *s3.GetObjectInput,
+				optFns ...func(*s3.Options),
+			) (*s3.GetObjectOutput, error) {
+				assert.Equal(t, "2025/11/01/123.json", *params.Key)
+				return &s3.GetObjectOutput{
+					Body: io.NopCloser(strings.NewReader(`{"checkin_id":123,"beer":{"name":"Test Beer"}}`)),
+				}, nil
+			},
+		}
+
+		client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
+		record, err := client.DownloadRecord(context.Background(), 123, createdAt)
+
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(123), record.CheckinID)
+		assert.Equal(t, "Test Beer", record.Beer.Name)
+	})
+
+	t.Run("Record_does_not_exist", func(t *testing.T) {
+		mockClient := &mockS3Client{
+			getObject: func(
+				ctx context.Context,
+				params *s3.GetObjectInput,
+				optFns ...func(*s3.Options),
+			) (*s3.GetObjectOutput, error) {
+				return nil, &types.NoSuchKey{}
+			},
+		}
+
+		client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
+		_, err := client.DownloadRecord(context.Background(), 123, createdAt)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
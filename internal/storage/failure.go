@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// where a failed checkin came from, which decides how it is retried
+const (
+	FailureSourceAPI = "api"
+	FailureSourceCSV = "csv"
+)
+
+// a checkin which failed to be stored, kept under failures/ along with its
+// input until a retry stores it
+type Failure struct {
+	CheckinID uint64 `json:"checkin_id"`
+	Source    string `json:"source"`
+	// step which failed, e.g. photo or record
+	Stage         string    `json:"stage"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+	// not retried before then
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// the checkin as returned by the API, or the row of the CSV export by
+	// column
+	Checkin *untappd.Checkin  `json:"checkin,omitempty"`
+	CSV     map[string]string `json:"csv,omitempty"`
+}
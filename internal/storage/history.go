@@ -0,0 +1,12 @@
+package storage
+
+import "time"
+
+// checkpoint of the walk through the checkin history, newest to oldest.
+// MaxID is the checkin the next page starts from, 0 before the first page.
+type HistoryProgress struct {
+	MaxID     uint64    `json:"max_id"`
+	Complete  bool      `json:"complete"`
+	Checkins  int       `json:"checkins"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
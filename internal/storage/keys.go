@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+const (
+	// progress of the recorder: the latest checkin, the history checkpoint
+	// and the last runs
+	stateKey = "state.json"
+
+	// where the latest checkin and the history checkpoint were stored
+	// before state.json, read once to migrate them
+	latestKey  = "latest.jpg"
+	historyKey = "history.json"
+
+	formatJPG  = "jpg"
+	formatWEBP = "webp"
+
+	// fixed width, so the raw responses sort chronologically
+	rawTimeFormat = "20060102T150405.000000000Z"
+)
+
+// YYYY/MM/DD/id.jpg or YYYY/MM/DD/WEBP/id.webp for the first photo of a
+// checkin, the next ones are suffixed with their position, e.g. id-2.jpg
+func photoKey(checkinID string, photo int, t time.Time, format string) string {
+	name := checkinID
+	if photo > 1 {
+		name = fmt.Sprintf("%s-%d", checkinID, photo)
+	}
+
+	switch format {
+	case formatWEBP:
+		return path.Join(
+			t.Format("2006/01/02"),
+			"WEBP",
+			fmt.Sprintf("%s.webp", name),
+		)
+	default:
+		return path.Join(
+			t.Format("2006/01/02"),
+			fmt.Sprintf("%s.jpg", name),
+		)
+	}
+}
+
+func metadataPhotoKey(md *CheckinMetadata, format string) (string, error) {
+	t, err := time.Parse(time.RFC1123Z, md.Date)
+	if err != nil {
+		return "", fmt.Errorf("parse checkin date %q: %w", md.Date, err)
+	}
+	return photoKey(md.ID, md.Photo, t, format), nil
+}
+
+// key of the JPG photo the metadata describes
+func (m *CheckinMetadata) JPGKey() (string, error) {
+	return metadataPhotoKey(m, formatJPG)
+}
+
+// YYYY/MM/DD/id.json
+func recordKey(checkinID uint64, createdAt time.Time) string {
+	return path.Join(
+		dayPrefix(createdAt),
+		fmt.Sprintf("%d.json", checkinID),
+	)
+}
+
+// YYYY/MM/DD, the prefix of the objects of the checkins of a day
+func dayPrefix(t time.Time) string {
+	return t.Format("2006/01/02")
+}
+
+// checkin ID of a record key name, false for the other objects of the day
+// such as id.jpg.meta.json
+func recordID(name string) (uint64, bool) {
+	id, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	return n, err == nil
+}
+
+// beers/bid.json
+func beerKey(bid uint64) string {
+	return path.Join("beers", fmt.Sprintf("%d.json", bid))
+}
+
+// breweries/id.json
+func breweryKey(id uint64) string {
+	return path.Join("breweries", fmt.Sprintf("%d.json", id))
+}
+
+// breweries/id.jpeg
+func breweryLogoKey(id uint64, logoURL string) string {
+	return imageKey("breweries", id, logoURL)
+}
+
+// labels/bid.jpeg
+func labelKey(bid uint64, labelURL string) string {
+	return imageKey("labels", bid, labelURL)
+}
+
+// badges/badge_id.png
+func badgeImageKey(badgeID uint64, imageURL string) string {
+	return imageKey("badges", badgeID, imageURL)
+}
+
+// badges/badge_id/checkin_id.json
+func badgeEarnKey(badgeID, checkinID uint64) string {
+	return path.Join("badges", strconv.FormatUint(badgeID, 10), fmt.Sprintf("%d.json", checkinID))
+}
+
+// prefix/id.jpeg, keeping the extension of the image URL when it is an
+// image one
+func imageKey(prefix string, id uint64, imageURL string) string {
+	ext := ".jpeg"
+	if u, err := url.Parse(imageURL); err == nil {
+		switch e := strings.ToLower(path.Ext(u.Path)); e {
+		case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+			ext = e
+		}
+	}
+	return path.Join(prefix, fmt.Sprintf("%d%s", id, ext))
+}
+
+// raw/<method>/<fetched at>.json, e.g. raw/user/checkins/20251101T180000.000000000Z.json,
+// so the responses of a method list in the order they were fetched
+func rawKey(raw *untappd.RawResponse) string {
+	return path.Join(rawPrefix(raw.Method), raw.FetchedAt.UTC().Format(rawTimeFormat)+".json")
+}
+
+func rawPrefix(method string) string {
+	return path.Join("raw", method) + "/"
+}
+
+// failures/<source>/id.json
+func failureKey(source string, checkinID uint64) string {
+	return path.Join(failurePrefix(source), fmt.Sprintf("%d.json", checkinID))
+}
+
+func failurePrefix(source string) string {
+	return path.Join("failures", source) + "/"
+}
+
+// <photo key>.meta.json, e.g. 2025/11/01/123-2.jpg.meta.json, so each photo
+// object has its own
+func metadataOverflowKey(key string) string {
+	return key + ".meta.json"
+}
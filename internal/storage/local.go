@@ -0,0 +1,461 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// suffix of the sidecar files holding the metadata of an object
+const localMetadataSuffix = ".meta.json"
+
+// stores the archive in a local directory, using the same layout as the
+// bucket. Object metadata is kept as JSON in a sidecar file next to each
+// object, e.g. 2025/11/01/123.jpg.meta.json.
+type LocalClient struct {
+	root string
+
+	// serializes the conditional writes of this process
+	versionMu sync.Mutex
+}
+
+func NewLocalClient(root string) (*LocalClient, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %q: %w", root, err)
+	}
+	return &LocalClient{root: root}, nil
+}
+
+func (c *LocalClient) path(key string) string {
+	return filepath.Join(c.root, filepath.FromSlash(key))
+}
+
+// writes the file through a temporary file so readers never see a partial
+// object, even when the process is interrupted.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (c *LocalClient) put(key string, data []byte, metadata map[string]string) error {
+	if err := writeFileAtomic(c.path(key), data); err != nil {
+		return fmt.Errorf("failed to write object %q: %w", key, err)
+	}
+
+	if metadata == nil {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of %q: %w", key, err)
+	}
+	if err := writeFileAtomic(c.path(key)+localMetadataSuffix, b); err != nil {
+		return fmt.Errorf("failed to write metadata of %q: %w", key, err)
+	}
+
+	return nil
+}
+
+func (c *LocalClient) get(key string) ([]byte, error) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to read object %q: %w", key, err)
+	}
+	return b, nil
+}
+
+func (c *LocalClient) metadata(key string) (map[string]string, error) {
+	b, err := c.get(key + localMetadataSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata of %q: %w", key, err)
+	}
+	return m, nil
+}
+
+func (c *LocalClient) exists(key string) (bool, error) {
+	_, err := os.Stat(c.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat %q: %w", key, err)
+	}
+	return true, nil
+}
+
+func (c *LocalClient) UploadJPG(ctx context.Context, file []byte, md *CheckinMetadata) error {
+	key, err := metadataPhotoKey(md, formatJPG)
+	if err != nil {
+		return err
+	}
+	return c.put(key, file, md.ToMap())
+}
+
+func (c *LocalClient) UploadWEBP(ctx context.Context, file []byte, md *CheckinMetadata) error {
+	key, err := metadataPhotoKey(md, formatWEBP)
+	if err != nil {
+		return err
+	}
+	return c.put(key, file, md.ToMap())
+}
+
+func (c *LocalClient) Download(ctx context.Context, fileName string) ([]byte, error) {
+	return c.get(fileName)
+}
+
+// reads back the checkin metadata of a photo object
+func (c *LocalClient) DownloadMetadata(ctx context.Context, key string) (*CheckinMetadata, error) {
+	m, err := c.metadata(key)
+	if err != nil {
+		return nil, err
+	}
+	return CheckinMetadataFromMap(m), nil
+}
+
+func (c *LocalClient) putJSON(ctx context.Context, key string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", key, err)
+	}
+	return c.put(key, b, nil)
+}
+
+func (c *LocalClient) getJSON(ctx context.Context, key string, v any) error {
+	b, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to decode %q: %w", key, err)
+	}
+	return nil
+}
+
+func (c *LocalClient) UploadRecord(ctx context.Context, record *CheckinRecord) error {
+	return c.putJSON(ctx, recordKey(record.CheckinID, record.CreatedAt), record)
+}
+
+func (c *LocalClient) DownloadRecord(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+) (*CheckinRecord, error) {
+	var record CheckinRecord
+	if err := c.getJSON(ctx, recordKey(checkinID, createdAt), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// IDs of the checkins with a record stored on the day
+func (c *LocalClient) ListRecords(ctx context.Context, day time.Time) ([]uint64, error) {
+	entries, err := os.ReadDir(c.path(dayPrefix(day)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %q: %w", dayPrefix(day), err)
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		if id, ok := recordID(e.Name()); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (c *LocalClient) UploadBeer(ctx context.Context, beer *BeerProfile) error {
+	return c.putJSON(ctx, beerKey(beer.BID), beer)
+}
+
+func (c *LocalClient) DownloadBeer(ctx context.Context, bid uint64) (*BeerProfile, error) {
+	var beer BeerProfile
+	if err := c.getJSON(ctx, beerKey(bid), &beer); err != nil {
+		return nil, err
+	}
+	return &beer, nil
+}
+
+func (c *LocalClient) UploadBrewery(ctx context.Context, brewery *BreweryProfile) error {
+	return c.putJSON(ctx, breweryKey(brewery.BreweryID), brewery)
+}
+
+func (c *LocalClient) DownloadBrewery(ctx context.Context, breweryID uint64) (*BreweryProfile, error) {
+	var brewery BreweryProfile
+	if err := c.getJSON(ctx, breweryKey(breweryID), &brewery); err != nil {
+		return nil, err
+	}
+	return &brewery, nil
+}
+
+func (c *LocalClient) UploadBreweryLogo(ctx context.Context, key string, logo []byte) error {
+	return c.put(key, logo, nil)
+}
+
+func (c *LocalClient) UploadLabel(ctx context.Context, key string, label []byte) error {
+	return c.put(key, label, nil)
+}
+
+func (c *LocalClient) UploadBadgeImage(ctx context.Context, key string, image []byte) error {
+	return c.put(key, image, nil)
+}
+
+func (c *LocalClient) UploadBadgeEarn(ctx context.Context, earn *BadgeEarn) error {
+	return c.putJSON(ctx, badgeEarnKey(earn.BadgeID, earn.CheckinID), earn)
+}
+
+func (c *LocalClient) ImageExists(ctx context.Context, key string) (bool, error) {
+	return c.exists(key)
+}
+
+func (c *LocalClient) UploadRawResponse(ctx context.Context, raw *untappd.RawResponse) error {
+	return c.putJSON(ctx, rawKey(raw), raw)
+}
+
+// keys of the archived responses of an API method, oldest first
+func (c *LocalClient) ListRawResponses(ctx context.Context, method string) ([]string, error) {
+	prefix := rawPrefix(method)
+	entries, err := os.ReadDir(c.path(prefix))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+	}
+
+	// ReadDir sorts by name, which is the fetch order
+	var keys []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			keys = append(keys, prefix+e.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+func (c *LocalClient) DownloadRawResponse(ctx context.Context, key string) (*untappd.RawResponse, error) {
+	var raw untappd.RawResponse
+	if err := c.getJSON(ctx, key, &raw); err != nil {
+		return nil, err
+	}
+	return &raw, nil
+}
+
+func (c *LocalClient) UploadFailure(ctx context.Context, failure *Failure) error {
+	return c.putJSON(ctx, failureKey(failure.Source, failure.CheckinID), failure)
+}
+
+func (c *LocalClient) DownloadFailure(ctx context.Context, source string, checkinID uint64) (*Failure, error) {
+	var failure Failure
+	if err := c.getJSON(ctx, failureKey(source, checkinID), &failure); err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// IDs of the checkins of the source which failed to be stored
+func (c *LocalClient) ListFailures(ctx context.Context, source string) ([]uint64, error) {
+	prefix := failurePrefix(source)
+	entries, err := os.ReadDir(c.path(prefix))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		if id, ok := recordID(e.Name()); ok && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// no error when the failure is already gone
+func (c *LocalClient) DeleteFailure(ctx context.Context, source string, checkinID uint64) error {
+	key := failureKey(source, checkinID)
+	if err := os.Remove(c.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object %q: %w", key, err)
+	}
+	return nil
+}
+
+func (c *LocalClient) GetHistoryProgress(ctx context.Context) (*HistoryProgress, error) {
+	return getStateHistoryProgress(ctx, c)
+}
+
+func (c *LocalClient) UpdateHistoryProgress(ctx context.Context, progress *HistoryProgress) error {
+	return updateHistoryProgress(ctx, c, progress)
+}
+
+func (c *LocalClient) GetState(ctx context.Context) (*State, error) {
+	return getState(ctx, c)
+}
+
+func (c *LocalClient) UpdateState(ctx context.Context, update func(*State) error) error {
+	return updateState(ctx, c, update)
+}
+
+// the version is a hash of the file content
+func (c *LocalClient) getVersioned(ctx context.Context, key string, v any) (string, error) {
+	b, err := c.get(key)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return "", fmt.Errorf("failed to decode %q: %w", key, err)
+	}
+	return contentVersion(b), nil
+}
+
+// compares the file with the version right before replacing it. Another
+// process can still write in between, the window is only a few syscalls.
+func (c *LocalClient) putVersioned(ctx context.Context, key string, v any, version string) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", key, err)
+	}
+
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+
+	current, err := c.get(key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if version != "" {
+			return fmt.Errorf("%w: %q", errStateConflict, key)
+		}
+	case err != nil:
+		return err
+	case contentVersion(current) != version:
+		return fmt.Errorf("%w: %q", errStateConflict, key)
+	}
+
+	return c.put(key, b, nil)
+}
+
+func contentVersion(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *LocalClient) legacyState(ctx context.Context) (*State, error) {
+	id, err := c.legacyLatestCheckinID()
+	if err != nil {
+		return nil, err
+	}
+	return migrateState(ctx, id, c.getJSON)
+}
+
+func (c *LocalClient) GetLatestCheckinID(ctx context.Context) (uint64, error) {
+	return getLatestCheckinID(ctx, c)
+}
+
+func (c *LocalClient) UpdateLatestCheckinID(ctx context.Context, checkin untappd.Checkin) error {
+	return updateLatestCheckinID(ctx, c, checkin)
+}
+
+// ID stored in the metadata of latest.jpg, the cursor before state.json. 0
+// when there is none.
+func (c *LocalClient) legacyLatestCheckinID() (uint64, error) {
+	const metaKeyID = "id"
+
+	m, err := c.metadata(latestKey)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	s := strings.TrimSpace(m[metaKeyID])
+	if s == "" {
+		return 0, fmt.Errorf(`empty "%s" metadata on %q`, metaKeyID, latestKey)
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid "%s" metadata value %q on %q: %w`, metaKeyID, s, latestKey, err)
+	}
+
+	return id, nil
+}
+
+func (c *LocalClient) CheckinExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	return c.exists(photoKey(strconv.FormatUint(checkinID, 10), photo, createdAt, formatJPG))
+}
+
+func (c *LocalClient) CheckinWEBPExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	return c.exists(photoKey(strconv.FormatUint(checkinID, 10), photo, createdAt, formatWEBP))
+}
+
+// removes the JPG and WEBP of a photo with their metadata, no error when
+// they are already gone
+func (c *LocalClient) DeletePhoto(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) error {
+	for _, format := range []string{formatJPG, formatWEBP} {
+		key := photoKey(strconv.FormatUint(checkinID, 10), photo, createdAt, format)
+		for _, name := range []string{c.path(key), c.path(key) + localMetadataSuffix} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete object %q: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalClient_Photos(t *testing.T) {
+	root := t.TempDir()
+	client, err := NewLocalClient(root)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	md := &CheckinMetadata{
+		ID:      "123",
+		Beer:    "Saison Dupont",
+		Comment: "Très bon 🍺",
+		Date:    "Sat, 01 Nov 2025 00:00:00 +0000",
+	}
+
+	createdAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	exists, err := client.CheckinExists(ctx, 123, createdAt, 1)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, client.UploadJPG(ctx, []byte("jpg"), md))
+	require.NoError(t, client.UploadWEBP(ctx, []byte("webp"), md))
+
+	assert.FileExists(t, filepath.Join(root, "2025", "11", "01", "123.jpg"))
+	assert.FileExists(t, filepath.Join(root, "2025", "11", "01", "123.jpg.meta.json"))
+	assert.FileExists(t, filepath.Join(root, "2025", "11", "01", "WEBP", "123.webp"))
+
+	exists, err = client.CheckinExists(ctx, 123, createdAt, 1)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = client.CheckinWEBPExists(ctx, 123, createdAt, 1)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	b, err := client.Download(ctx, "2025/11/01/123.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("jpg"), b)
+
+	got, err := client.DownloadMetadata(ctx, "2025/11/01/123.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+
+	_, err = client.Download(ctx, "2025/11/01/456.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// next photos of the checkin get their own keys
+	second := *md
+	second.Photo = 2
+
+	exists, err = client.CheckinExists(ctx, 123, createdAt, 2)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, client.UploadJPG(ctx, []byte("jpg2"), &second))
+	assert.FileExists(t, filepath.Join(root, "2025", "11", "01", "123-2.jpg"))
+
+	exists, err = client.CheckinExists(ctx, 123, createdAt, 2)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	got, err = client.DownloadMetadata(ctx, "2025/11/01/123-2.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Photo)
+
+	// only the photo deleted goes, twice is fine
+	require.NoError(t, client.DeletePhoto(ctx, 123, createdAt, 2))
+	require.NoError(t, client.DeletePhoto(ctx, 123, createdAt, 2))
+	assert.NoFileExists(t, filepath.Join(root, "2025", "11", "01", "123-2.jpg"))
+	assert.NoFileExists(t, filepath.Join(root, "2025", "11", "01", "123-2.jpg.meta.json"))
+	assert.FileExists(t, filepath.Join(root, "2025", "11", "01", "123.jpg"))
+}
+
+func TestLocalClient_LatestCheckinID(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	id, err := client.GetLatestCheckinID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), id)
+
+	// the cursor does not depend on the photo of the checkin being stored
+	err = client.UpdateLatestCheckinID(ctx, untappd.Checkin{
+		CheckinID: 123,
+		CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000",
+	})
+	require.NoError(t, err)
+
+	id, err = client.GetLatestCheckinID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(123), id)
+}
+
+func TestLocalClient_Record(t *testing.T) {
+	root := t.TempDir()
+	client, err := NewLocalClient(root)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	createdAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err = client.DownloadRecord(ctx, 123, createdAt)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	record := &CheckinRecord{CheckinID: 123, CreatedAt: createdAt, Beer: BeerRecord{Name: "Test Beer"}}
+	require.NoError(t, client.UploadRecord(ctx, record))
+
+	got, err := client.DownloadRecord(ctx, 123, createdAt)
+	require.NoError(t, err)
+	assert.Equal(t, "Test Beer", got.Beer.Name)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "2025", "11", "01"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "123.json", entries[0].Name())
+}
+
+func TestLocalClient_ListRecords(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	day := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	ids, err := client.ListRecords(ctx, day)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	for _, id := range []uint64{12, 34} {
+		require.NoError(t, client.UploadRecord(ctx, &CheckinRecord{CheckinID: id, CreatedAt: day.Add(time.Hour)}))
+	}
+	// photos and their metadata are not records
+	require.NoError(t, client.UploadJPG(ctx, []byte("jpg"), &CheckinMetadata{ID: "12", Date: day.Format(time.RFC1123Z)}))
+
+	ids, err = client.ListRecords(ctx, day)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{12, 34}, ids)
+}
+
+func TestLocalClient_RawResponses(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	keys, err := client.ListRawResponses(ctx, "/user/checkins")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	fetchedAt := time.Date(2025, 11, 1, 18, 0, 0, 0, time.UTC)
+	for i, body := range []string{`{"page":2}`, `{"page":1}`} {
+		raw := &untappd.RawResponse{
+			Method:    "/user/checkins",
+			Params:    url.Values{"limit": {"50"}},
+			FetchedAt: fetchedAt.Add(time.Duration(1-i) * time.Second),
+			Body:      json.RawMessage(body),
+		}
+		require.NoError(t, client.UploadRawResponse(ctx, raw))
+	}
+
+	keys, err = client.ListRawResponses(ctx, "/user/checkins")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"raw/user/checkins/20251101T180000.000000000Z.json",
+		"raw/user/checkins/20251101T180001.000000000Z.json",
+	}, keys)
+
+	raw, err := client.DownloadRawResponse(ctx, keys[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"page":1}`, string(raw.Body))
+	assert.Equal(t, "50", raw.Params.Get("limit"))
+	assert.True(t, raw.FetchedAt.Equal(fetchedAt))
+}
+
+func TestLocalClient_Failures(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ids, err := client.ListFailures(ctx, FailureSourceAPI)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	checkin := untappd.Checkin{CheckinID: 42, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"}
+	require.NoError(t, client.UploadFailure(ctx, &Failure{
+		CheckinID: 42,
+		Source:    FailureSourceAPI,
+		Stage:     "photo",
+		Attempts:  1,
+		Checkin:   &checkin,
+	}))
+	require.NoError(t, client.UploadFailure(ctx, &Failure{
+		CheckinID: 7,
+		Source:    FailureSourceCSV,
+		CSV:       map[string]string{"checkin_id": "7"},
+	}))
+
+	ids, err = client.ListFailures(ctx, FailureSourceAPI)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{42}, ids)
+
+	failure, err := client.DownloadFailure(ctx, FailureSourceAPI, 42)
+	require.NoError(t, err)
+	assert.Equal(t, "photo", failure.Stage)
+	assert.Equal(t, checkin, *failure.Checkin)
+
+	require.NoError(t, client.DeleteFailure(ctx, FailureSourceAPI, 42))
+	require.NoError(t, client.DeleteFailure(ctx, FailureSourceAPI, 42))
+	_, err = client.DownloadFailure(ctx, FailureSourceAPI, 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ids, err = client.ListFailures(ctx, FailureSourceCSV)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{7}, ids)
+}
+
+func TestLocalClient_HistoryProgress(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	progress, err := client.GetHistoryProgress(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &HistoryProgress{}, progress)
+
+	progress.MaxID = 98
+	progress.Checkins = 2
+	require.NoError(t, client.UpdateHistoryProgress(context.Background(), progress))
+
+	got, err := client.GetHistoryProgress(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(98), got.MaxID)
+	assert.Equal(t, 2, got.Checkins)
+	assert.False(t, got.Complete)
+}
+
+func TestNew_Local(t *testing.T) {
+	root := t.TempDir()
+
+	store, err := New(context.Background(), &config.Config{LocalStoragePath: root})
+	require.NoError(t, err)
+	assert.IsType(t, &LocalClient{}, store)
+}
+
+func TestLocalClient_Beer(t *testing.T) {
+	root := t.TempDir()
+	client, err := NewLocalClient(root)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	_, err = client.DownloadBeer(ctx, 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, client.UploadBeer(ctx, &BeerProfile{BID: 42, Name: "Saison Dupont", IBU: 30}))
+	assert.FileExists(t, filepath.Join(root, "beers", "42.json"))
+
+	got, err := client.DownloadBeer(ctx, 42)
+	require.NoError(t, err)
+	assert.Equal(t, "Saison Dupont", got.Name)
+	assert.Equal(t, 30.0, got.IBU)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+)
+
+const (
+	// S3 and R2 cap the user metadata at 2 KB, counting keys and values
+	maxMetadataBytes = 2048
+
+	// metadata key pointing to the object holding the values which did not
+	// fit in the object metadata
+	metaKeyOverflow = "overflow"
+)
+
+// encodes a metadata value so it can be sent as an HTTP header. Values which
+// are not printable US-ASCII are written as RFC 2047 encoded-words.
+func encodeMetadataValue(v string) string {
+	// plain values looking like an encoded-word would be mangled on decode
+	if strings.HasPrefix(v, "=?") {
+		return "=?utf-8?b?" + base64.StdEncoding.EncodeToString([]byte(v)) + "?="
+	}
+	return mime.BEncoding.Encode("utf-8", v)
+}
+
+func decodeMetadataValue(v string) (string, error) {
+	if !strings.Contains(v, "=?") {
+		return v, nil
+	}
+	dec := new(mime.WordDecoder)
+	s, err := dec.DecodeHeader(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode metadata value %q: %w", v, err)
+	}
+	return s, nil
+}
+
+func metadataSize(m map[string]string) int {
+	n := 0
+	for k, v := range m {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// encodes the metadata into object metadata fitting the provider limits.
+// When it is too large, the biggest values are left out and returned as
+// overflow, to be stored in the object referenced by overflowKey.
+func encodeMetadata(m map[string]string, overflowKey string) (map[string]string, map[string]string) {
+	encoded := make(map[string]string, len(m))
+	for k, v := range m {
+		encoded[k] = encodeMetadataValue(v)
+	}
+
+	if metadataSize(encoded) <= maxMetadataBytes {
+		return encoded, nil
+	}
+
+	// move the largest values out first, keeping the small identifying
+	// fields on the object itself
+	keys := make([]string, 0, len(encoded))
+	for k := range encoded {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(encoded[keys[i]]) != len(encoded[keys[j]]) {
+			return len(encoded[keys[i]]) > len(encoded[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	overflow := make(map[string]string)
+	encoded[metaKeyOverflow] = overflowKey
+	for _, k := range keys {
+		if metadataSize(encoded) <= maxMetadataBytes {
+			break
+		}
+		overflow[k] = m[k]
+		delete(encoded, k)
+	}
+
+	return encoded, overflow
+}
+
+// decodes object metadata written by encodeMetadata, without resolving
+// the overflow object.
+func decodeMetadata(m map[string]string) (map[string]string, error) {
+	decoded := make(map[string]string, len(m))
+	for k, v := range m {
+		s, err := decodeMetadataValue(v)
+		if err != nil {
+			return nil, err
+		}
+		decoded[k] = s
+	}
+	return decoded, nil
+}
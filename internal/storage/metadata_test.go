@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeMetadataValue(t *testing.T) {
+	for _, v := range []string{
+		"Saison Dupont",
+		"Brasserie Dupont — Tourpes",
+		"Très bon 🍺",
+		"multi\nline",
+		"=?utf-8?b?looks-encoded?=",
+		"",
+	} {
+		encoded := encodeMetadataValue(v)
+		for _, r := range encoded {
+			if r < ' ' || r > '~' {
+				t.Fatalf("encoded value %q is not printable ASCII", encoded)
+			}
+		}
+
+		decoded, err := decodeMetadataValue(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+
+	assert.Equal(t, "Saison Dupont", encodeMetadataValue("Saison Dupont"))
+}
+
+func TestEncodeMetadata_Overflow(t *testing.T) {
+	md := &CheckinMetadata{
+		ID:      "123",
+		Beer:    "Test Beer",
+		Comment: strings.Repeat("é", 2000),
+		Date:    "Sat, 01 Nov 2025 00:00:00 +0000",
+	}
+
+	encoded, overflow := encodeMetadata(md.ToMap(), "2025/11/01/123.jpg.meta.json")
+
+	assert.LessOrEqual(t, metadataSize(encoded), maxMetadataBytes)
+	assert.Equal(t, "2025/11/01/123.jpg.meta.json", encoded[metaKeyOverflow])
+	assert.Equal(t, md.Comment, overflow["comment"])
+	assert.NotContains(t, encoded, "comment")
+	assert.Equal(t, "Test Beer", encoded["beer"])
+	assert.Equal(t, "123", encoded["id"])
+}
+
+func TestClient_UploadJPG_MetadataOverflow(t *testing.T) {
+	objects := map[string][]byte{}
+	metadata := map[string]map[string]string{}
+
+	mockClient := &mockS3Client{
+		putObject: func(
+			ctx context.Context,
+			params *s3.PutObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.PutObjectOutput, error) {
+			b, _ := io.ReadAll(params.Body)
+			objects[*params.Key] = b
+			metadata[*params.Key] = params.Metadata
+			return &s3.PutObjectOutput{}, nil
+		},
+		headObject: func(
+			ctx context.Context,
+			params *s3.HeadObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{Metadata: metadata[*params.Key]}, nil
+		},
+		getObject: func(
+			ctx context.Context,
+			params *s3.GetObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(strings.NewReader(string(objects[*params.Key]))),
+			}, nil
+		},
+		deleteObject: func(
+			ctx context.Context,
+			params *s3.DeleteObjectInput,
+			optFns ...func(*s3.Options),
+		) (*s3.DeleteObjectOutput, error) {
+			delete(objects, *params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+
+	client := &Client{s3Client: mockClient, bucketName: "test-bucket"}
+	md := &CheckinMetadata{
+		ID:      "123",
+		Beer:    "Saison Dupont",
+		Brewery: "Brasserie Dupont",
+		Comment: strings.Repeat("Très bon 🍺 ", 200),
+		Venue:   "Café Brück",
+		Date:    "Sat, 01 Nov 2025 00:00:00 +0000",
+	}
+
+	err := client.UploadJPG(context.Background(), []byte("jpg"), md)
+	require.NoError(t, err)
+
+	require.Contains(t, objects, "2025/11/01/123.jpg.meta.json")
+	var overflow map[string]string
+	require.NoError(t, json.Unmarshal(objects["2025/11/01/123.jpg.meta.json"], &overflow))
+	assert.Equal(t, md.Comment, overflow["comment"])
+
+	stored := metadata["2025/11/01/123.jpg"]
+	assert.LessOrEqual(t, metadataSize(stored), maxMetadataBytes)
+
+	got, err := client.DownloadMetadata(context.Background(), "2025/11/01/123.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+
+	// the other photos and formats have their own overflow
+	second := *md
+	second.Photo = 2
+	second.Comment = strings.Repeat("Toujours bon 🍺 ", 200)
+	require.NoError(t, client.UploadWEBP(context.Background(), []byte("webp"), &second))
+	require.Contains(t, objects, "2025/11/01/WEBP/123-2.webp.meta.json")
+
+	got, err = client.DownloadMetadata(context.Background(), "2025/11/01/123.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, md.Comment, got.Comment)
+
+	// once the metadata fits again, the overflow is removed
+	md.Comment = "Très bon"
+	require.NoError(t, client.UploadJPG(context.Background(), []byte("jpg"), md))
+	assert.NotContains(t, objects, "2025/11/01/123.jpg.meta.json")
+
+	got, err = client.DownloadMetadata(context.Background(), "2025/11/01/123.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// version of the JSON sidecar layout, bumped on breaking changes
+const RecordVersion = 1
+
+const (
+	RecordSourceAPI = "api"
+	RecordSourceCSV = "csv"
+)
+
+// holds the full checkin, stored as a JSON sidecar next to its photos
+type CheckinRecord struct {
+	Version        int           `json:"version"`
+	Source         string        `json:"source"`
+	CheckinID      uint64        `json:"checkin_id"`
+	CreatedAt      time.Time     `json:"created_at"`
+	Comment        string        `json:"comment"`
+	RatingScore    float64       `json:"rating_score"`
+	CheckinURL     string        `json:"checkin_url,omitempty"`
+	PhotoURLs      []string      `json:"photo_urls,omitempty"`
+	Beer           BeerRecord    `json:"beer"`
+	Brewery        BreweryRecord `json:"brewery"`
+	Venue          *VenueRecord  `json:"venue,omitempty"`
+	PurchaseVenue  string        `json:"purchase_venue,omitempty"`
+	FlavorProfiles []string      `json:"flavor_profiles,omitempty"`
+	TaggedFriends  []string      `json:"tagged_friends,omitempty"`
+	TotalToasts    int           `json:"total_toasts"`
+	TotalComments  int           `json:"total_comments"`
+	// the toasts and comments returned by the API, none from a CSV export
+	Toasts   []ToastRecord   `json:"toasts,omitempty"`
+	Comments []CommentRecord `json:"comments,omitempty"`
+	Badges   []BadgeRecord   `json:"badges,omitempty"`
+	// tombstone set when the checkin was deleted from Untappd
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ToastRecord struct {
+	UserName  string    `json:"user_name"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CommentRecord struct {
+	CommentID uint64    `json:"comment_id"`
+	UserName  string    `json:"user_name"`
+	Name      string    `json:"name,omitempty"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BeerRecord struct {
+	BID                       uint64  `json:"bid,omitempty"`
+	Name                      string  `json:"name"`
+	Style                     string  `json:"style,omitempty"`
+	ABV                       float64 `json:"abv"`
+	IBU                       float64 `json:"ibu,omitempty"`
+	URL                       string  `json:"url,omitempty"`
+	GlobalRatingScore         float64 `json:"global_rating_score,omitempty"`
+	GlobalWeightedRatingScore float64 `json:"global_weighted_rating_score,omitempty"`
+	RatingCount               int     `json:"rating_count,omitempty"`
+	Description               string  `json:"description,omitempty"`
+	LabelURL                  string  `json:"label_url,omitempty"`
+	LabelHDURL                string  `json:"label_hd_url,omitempty"`
+	// key of the archived label, see BeerProfile
+	Label string `json:"label,omitempty"`
+}
+
+type BreweryRecord struct {
+	BreweryID uint64 `json:"brewery_id,omitempty"`
+	Name      string `json:"name"`
+	Country   string `json:"country,omitempty"`
+	City      string `json:"city,omitempty"`
+	State     string `json:"state,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Type      string `json:"type,omitempty"`
+	// keys of the brewery profile and logo, see BreweryProfile
+	Profile string `json:"profile,omitempty"`
+	Logo    string `json:"logo,omitempty"`
+}
+
+type VenueRecord struct {
+	VenueID uint64 `json:"venue_id,omitempty"`
+	Name    string `json:"name"`
+	// normalized kind of venue, one of the untappd.VenueType* constants
+	Type         string       `json:"type,omitempty"`
+	Categories   []string     `json:"categories,omitempty"`
+	Address      string       `json:"address,omitempty"`
+	FoursquareID string       `json:"foursquare_id,omitempty"`
+	City         string       `json:"city,omitempty"`
+	State        string       `json:"state,omitempty"`
+	Country      string       `json:"country,omitempty"`
+	Coordinates  *Coordinates `json:"coordinates,omitempty"`
+}
+
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// builds the record of a checkin returned by the Untappd API
+func RecordFromCheckin(c untappd.Checkin) (*CheckinRecord, error) {
+	createdAt, err := time.Parse(time.RFC1123Z, c.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parse checkin date %q: %w", c.CreatedAt, err)
+	}
+
+	r := &CheckinRecord{
+		Version:     RecordVersion,
+		Source:      RecordSourceAPI,
+		CheckinID:   c.CheckinID,
+		CreatedAt:   createdAt,
+		Comment:     c.CheckinComment,
+		RatingScore: c.RatingScore,
+		CheckinURL:  c.URL(),
+		Beer: BeerRecord{
+			BID:   c.Beer.BID,
+			Name:  c.Beer.BeerName,
+			Style: c.Beer.BeerStyle,
+			ABV:   c.Beer.BeerABV,
+			IBU:   c.Beer.BeerIBU,
+		},
+		Brewery: BreweryRecord{
+			BreweryID: c.Brewery.BreweryID,
+			Name:      c.Brewery.BreweryName,
+			Country:   c.Brewery.BreweryCountry,
+			City:      c.Brewery.Location.BreweryCity,
+			State:     c.Brewery.Location.BreweryState,
+			URL:       c.Brewery.Contact.URL,
+		},
+		TotalToasts:   c.Toasts.Total(),
+		TotalComments: c.Comments.Total(),
+	}
+
+	for _, f := range c.TaggedFriends.Items {
+		r.TaggedFriends = append(r.TaggedFriends, f.User.UserName)
+	}
+	for _, t := range c.Toasts.Items {
+		r.Toasts = append(r.Toasts, ToastRecord{
+			UserName:  t.User.UserName,
+			Name:      fullName(t.User),
+			CreatedAt: parseAPITime(t.CreatedAt),
+		})
+	}
+	for _, cm := range c.Comments.Items {
+		r.Comments = append(r.Comments, CommentRecord{
+			CommentID: cm.CommentID,
+			UserName:  cm.User.UserName,
+			Name:      fullName(cm.User),
+			Comment:   cm.Comment,
+			CreatedAt: parseAPITime(cm.CreatedAt),
+		})
+	}
+
+	for _, item := range c.Media.Items {
+		r.PhotoURLs = append(r.PhotoURLs, item.Photo.PhotoImgOg)
+	}
+
+	for _, b := range c.Badges.Items {
+		r.Badges = append(r.Badges, BadgeRecord{
+			BadgeID:     b.BadgeID,
+			UserBadgeID: b.UserBadgeID,
+			Name:        b.BadgeName,
+			Description: b.BadgeDescription,
+			ImageURL:    b.ImageURL(),
+		})
+	}
+
+	if c.Venue != nil {
+		r.Venue = &VenueRecord{
+			VenueID:      c.Venue.VenueID,
+			Name:         c.Venue.Name(),
+			Type:         c.Venue.Type(),
+			Categories:   c.Venue.CategoryNames(),
+			FoursquareID: c.Venue.Foursquare.FoursquareID,
+			City:         c.Venue.City(),
+			State:        c.Venue.State(),
+			Country:      c.Venue.Country(),
+		}
+		if c.Venue.VenueName != untappd.VenueUntappdAtHome {
+			r.Venue.Address = c.Venue.Location.Address
+			r.Venue.Coordinates = &Coordinates{
+				Lat: c.Venue.Location.Lat,
+				Lng: c.Venue.Location.Lng,
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func fullName(u untappd.User) string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// zero when the API returns no or an unexpected date, which is not worth
+// failing the whole record for
+func parseAPITime(s string) time.Time {
+	t, err := time.Parse(time.RFC1123Z, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// reports whether the checkin was edited on Untappd since the old record was
+// stored: a new rating, comment, photo, venue or social context, or when it
+// came back after being marked as deleted
+func (r *CheckinRecord) ChangedFrom(old *CheckinRecord) bool {
+	return old.DeletedAt != nil ||
+		r.Comment != old.Comment ||
+		r.RatingScore != old.RatingScore ||
+		!slices.Equal(r.PhotoURLs, old.PhotoURLs) ||
+		r.Venue.name() != old.Venue.name() ||
+		!slices.Equal(r.TaggedFriends, old.TaggedFriends) ||
+		r.TotalToasts != old.TotalToasts ||
+		r.TotalComments != old.TotalComments ||
+		len(r.Badges) != len(old.Badges)
+}
+
+// keeps the details of the old record which the API does not return, such
+// as the flavor profiles and purchase venue of a CSV export, and the ones
+// the enrichment added, should it not run again
+func (r *CheckinRecord) MergeFrom(old *CheckinRecord) {
+	if len(r.FlavorProfiles) == 0 {
+		r.FlavorProfiles = old.FlavorProfiles
+	}
+	if r.PurchaseVenue == "" {
+		r.PurchaseVenue = old.PurchaseVenue
+	}
+	if r.Beer.GlobalWeightedRatingScore == 0 {
+		r.Beer.GlobalWeightedRatingScore = old.Beer.GlobalWeightedRatingScore
+	}
+	if r.Beer.BID == old.Beer.BID {
+		r.Beer.mergeFrom(&old.Beer)
+	}
+	if r.Brewery.BreweryID == old.Brewery.BreweryID {
+		r.Brewery.mergeFrom(&old.Brewery)
+	}
+}
+
+// keeps the enriched details of the old beer which b is missing
+func (b *BeerRecord) mergeFrom(old *BeerRecord) {
+	keep(&b.IBU, old.IBU)
+	keep(&b.GlobalRatingScore, old.GlobalRatingScore)
+	keep(&b.RatingCount, old.RatingCount)
+	keep(&b.Description, old.Description)
+	keep(&b.LabelURL, old.LabelURL)
+	keep(&b.LabelHDURL, old.LabelHDURL)
+	keep(&b.Label, old.Label)
+}
+
+// keeps the enriched details of the old brewery which b is missing
+func (b *BreweryRecord) mergeFrom(old *BreweryRecord) {
+	keep(&b.City, old.City)
+	keep(&b.State, old.State)
+	keep(&b.URL, old.URL)
+	keep(&b.Type, old.Type)
+	keep(&b.Profile, old.Profile)
+	keep(&b.Logo, old.Logo)
+}
+
+// sets v to old when it is the zero value
+func keep[T comparable](v *T, old T) {
+	var zero T
+	if *v == zero {
+		*v = old
+	}
+}
+
+func (v *VenueRecord) name() string {
+	if v == nil {
+		return ""
+	}
+	return v.Name
+}
+
+// first photo of the checkin, empty when it has none
+func (r *CheckinRecord) PhotoURL() string {
+	if len(r.PhotoURLs) == 0 {
+		return ""
+	}
+	return r.PhotoURLs[0]
+}
+
+// flattens the record into the metadata attached to the photo objects
+func (r *CheckinRecord) Metadata() *CheckinMetadata {
+	md := &CheckinMetadata{
+		ID:             strconv.FormatUint(r.CheckinID, 10),
+		Beer:           r.Beer.Name,
+		Brewery:        r.Brewery.Name,
+		BreweryCountry: r.Brewery.Country,
+		BreweryProfile: r.Brewery.Profile,
+		Comment:        r.Comment,
+		Rating:         fmt.Sprintf("%.2f", r.RatingScore),
+		Date:           r.CreatedAt.Format(time.RFC1123Z),
+		Style:          r.Beer.Style,
+		ABV:            fmt.Sprintf("%.2f", r.Beer.ABV),
+		Label:          r.Beer.Label,
+		TaggedFriends:  strings.Join(r.TaggedFriends, ","),
+	}
+	if r.TotalToasts > 0 {
+		md.Toasts = strconv.Itoa(r.TotalToasts)
+	}
+	if r.TotalComments > 0 {
+		md.Comments = strconv.Itoa(r.TotalComments)
+	}
+
+	if v := r.Venue; v != nil {
+		md.Venue = v.Name
+		md.City = v.City
+		md.State = v.State
+		md.Country = v.Country
+		if v.Coordinates != nil {
+			md.LatLng = fmt.Sprintf("%f,%f", v.Coordinates.Lat, v.Coordinates.Lng)
+		}
+	}
+
+	return md
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFromCheckin(t *testing.T) {
+	checkin := untappd.Checkin{
+		CheckinID:      123,
+		CheckinComment: "Lovely",
+		RatingScore:    4.25,
+		CreatedAt:      "Sat, 01 Nov 2025 18:30:00 +0000",
+		Media: untappd.Media{Items: []untappd.MediaItem{
+			{Photo: untappd.Photo{PhotoImgOg: "https://example.com/1.jpg"}},
+			{Photo: untappd.Photo{PhotoImgOg: "https://example.com/2.jpg"}},
+		}},
+		Beer:    untappd.Beer{BID: 10, BeerName: "Test Beer", BeerStyle: "IPA", BeerABV: 6.5},
+		Brewery: untappd.Brewery{BreweryID: 20, BreweryName: "Test Brewery", BreweryCountry: "Belgium"},
+		Venue: &untappd.Venue{
+			VenueID:         30,
+			VenueName:       "Test Venue",
+			PrimaryCategory: "Nightlife Spot",
+			Categories: untappd.VenueCategories{Items: []untappd.VenueCategory{
+				{CategoryName: "Beer Bar", IsPrimary: true},
+				{CategoryName: "Restaurant"},
+			}},
+			Location: untappd.Location{
+				Address: "Rue de la Loi 1",
+				Lat:     50.5,
+				Lng:     4.25,
+				City:    "Brussels",
+			},
+			Foursquare: untappd.Foursquare{FoursquareID: "4b0588cbf964a520cdd222e3"},
+		},
+		User: untappd.User{UserName: "someone"},
+		// only the latest toasts are returned
+		Toasts: untappd.Toasts{Count: 1, TotalCount: 2, Items: []untappd.Toast{{
+			User:      untappd.User{UserName: "friend", FirstName: "Best", LastName: "Friend"},
+			CreatedAt: "Sat, 01 Nov 2025 19:00:00 +0000",
+		}}},
+		Comments: untappd.Comments{Count: 1, Items: []untappd.Comment{{
+			CommentID: 9,
+			User:      untappd.User{UserName: "friend"},
+			Comment:   "Cheers!",
+			CreatedAt: "Sat, 01 Nov 2025 19:05:00 +0000",
+		}}},
+		TaggedFriends: untappd.TaggedFriends{Items: []untappd.TaggedFriend{
+			{User: untappd.User{UserName: "friend"}},
+			{User: untappd.User{UserName: "other"}},
+		}},
+		Badges: untappd.Badges{Items: []untappd.Badge{{
+			BadgeID:     40,
+			UserBadgeID: 41,
+			BadgeName:   "Hopped Up",
+			BadgeImage: untappd.BadgeImage{
+				Sm: "https://example.com/badge_sm.png",
+				Lg: "https://example.com/badge_lg.png",
+			},
+		}}},
+	}
+
+	r, err := RecordFromCheckin(checkin)
+	require.NoError(t, err)
+
+	assert.Equal(t, RecordSourceAPI, r.Source)
+	assert.Equal(t, uint64(10), r.Beer.BID)
+	assert.Equal(t, uint64(20), r.Brewery.BreweryID)
+	assert.Equal(t, 2, r.TotalToasts)
+	assert.Equal(t, 1, r.TotalComments)
+	assert.Equal(t, []string{"friend", "other"}, r.TaggedFriends)
+	require.Len(t, r.Toasts, 1)
+	assert.Equal(t, "friend", r.Toasts[0].UserName)
+	assert.Equal(t, "Best Friend", r.Toasts[0].Name)
+	assert.True(t, r.Toasts[0].CreatedAt.Equal(time.Date(2025, 11, 1, 19, 0, 0, 0, time.UTC)))
+	require.Len(t, r.Comments, 1)
+	assert.Equal(t, "Cheers!", r.Comments[0].Comment)
+	assert.Equal(t, uint64(9), r.Comments[0].CommentID)
+	assert.Equal(t, "https://example.com/1.jpg", r.PhotoURL())
+	assert.Len(t, r.PhotoURLs, 2)
+	assert.Equal(t, "https://untappd.com/user/someone/checkin/123", r.CheckinURL)
+	assert.Equal(t, &VenueRecord{
+		VenueID:      30,
+		Name:         "Test Venue",
+		Type:         untappd.VenueTypeBar,
+		Categories:   []string{"Beer Bar", "Restaurant"},
+		Address:      "Rue de la Loi 1",
+		FoursquareID: "4b0588cbf964a520cdd222e3",
+		City:         "Brussels",
+		Coordinates:  &Coordinates{Lat: 50.5, Lng: 4.25},
+	}, r.Venue)
+
+	require.Len(t, r.Badges, 1)
+	assert.Equal(t, "https://example.com/badge_lg.png", r.Badges[0].ImageURL)
+	assert.Equal(t, "badges/40.png", r.Badges[0].ImageArchiveKey())
+	assert.Equal(t, []*BadgeEarn{{
+		BadgeID:     40,
+		UserBadgeID: 41,
+		Name:        "Hopped Up",
+		CheckinID:   123,
+		EarnedAt:    r.CreatedAt,
+		Record:      "2025/11/01/123.json",
+	}}, r.BadgeEarns())
+
+	md := r.Metadata()
+	assert.Equal(t, &CheckinMetadata{
+		ID:             "123",
+		Beer:           "Test Beer",
+		Brewery:        "Test Brewery",
+		BreweryCountry: "Belgium",
+		Comment:        "Lovely",
+		Rating:         "4.25",
+		Venue:          "Test Venue",
+		City:           "Brussels",
+		LatLng:         "50.500000,4.250000",
+		Date:           "Sat, 01 Nov 2025 18:30:00 +0000",
+		Style:          "IPA",
+		ABV:            "6.50",
+		TaggedFriends:  "friend,other",
+		Toasts:         "2",
+		Comments:       "1",
+	}, md)
+}
+
+func TestRecordFromCheckin_AtHome(t *testing.T) {
+	r, err := RecordFromCheckin(untappd.Checkin{
+		CheckinID: 1,
+		CreatedAt: "Sat, 01 Nov 2025 18:30:00 +0000",
+		Venue: &untappd.Venue{
+			VenueName: untappd.VenueUntappdAtHome,
+			Location:  untappd.Location{Lat: 1, Lng: 2, City: "Somewhere", Address: "1 Home Street"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, untappd.VenueTypeHome, r.Venue.Type)
+	assert.Empty(t, r.Venue.Address)
+	assert.Nil(t, r.Venue.Coordinates)
+	assert.Empty(t, r.Metadata().LatLng)
+	assert.Empty(t, r.Metadata().City)
+}
+
+func TestRecordFromCheckin_InvalidDate(t *testing.T) {
+	_, err := RecordFromCheckin(untappd.Checkin{CheckinID: 1, CreatedAt: "yesterday"})
+	assert.Error(t, err)
+}
+
+func TestCheckinRecord_ChangedFrom(t *testing.T) {
+	old := &CheckinRecord{CheckinID: 1, Comment: "Nice", RatingScore: 4, FlavorProfiles: []string{"hoppy"}}
+
+	same := &CheckinRecord{CheckinID: 1, Comment: "Nice", RatingScore: 4}
+	// CSV-only fields are not an edit
+	assert.False(t, same.ChangedFrom(old))
+
+	edited := &CheckinRecord{CheckinID: 1, Comment: "Nice", RatingScore: 4.5}
+	assert.True(t, edited.ChangedFrom(old))
+
+	photo := &CheckinRecord{CheckinID: 1, Comment: "Nice", RatingScore: 4, PhotoURLs: []string{"https://img/1.jpg"}}
+	assert.True(t, photo.ChangedFrom(old))
+
+	deletedAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	tombstoned := *old
+	tombstoned.DeletedAt = &deletedAt
+	assert.True(t, same.ChangedFrom(&tombstoned))
+
+	edited.MergeFrom(old)
+	assert.Equal(t, []string{"hoppy"}, edited.FlavorProfiles)
+}
+
+func TestCheckinRecord_MergeFrom_Enrichment(t *testing.T) {
+	old := &CheckinRecord{
+		CheckinID: 1,
+		Beer: BeerRecord{
+			BID:         10,
+			Description: "Hazy",
+			LabelURL:    "https://img/label.jpg",
+			Label:       "labels/10.jpeg",
+			RatingCount: 42,
+		},
+		Brewery: BreweryRecord{
+			BreweryID: 100,
+			Type:      "Micro Brewery",
+			Profile:   "breweries/100.json",
+			Logo:      "breweries/100.jpeg",
+		},
+	}
+
+	// not enriched this time
+	r := &CheckinRecord{
+		CheckinID: 1,
+		Beer:      BeerRecord{BID: 10, Name: "Beer"},
+		Brewery:   BreweryRecord{BreweryID: 100, Name: "Brewery"},
+	}
+	r.MergeFrom(old)
+
+	assert.Equal(t, "Beer", r.Beer.Name)
+	assert.Equal(t, "Hazy", r.Beer.Description)
+	assert.Equal(t, "https://img/label.jpg", r.Beer.LabelURL)
+	assert.Equal(t, "labels/10.jpeg", r.Beer.Label)
+	assert.Equal(t, 42, r.Beer.RatingCount)
+	assert.Equal(t, "Micro Brewery", r.Brewery.Type)
+	assert.Equal(t, "breweries/100.json", r.Brewery.Profile)
+	assert.Equal(t, "breweries/100.jpeg", r.Brewery.Logo)
+
+	// the checkin was moved to another beer
+	moved := &CheckinRecord{CheckinID: 1, Beer: BeerRecord{BID: 20}}
+	moved.MergeFrom(old)
+	assert.Empty(t, moved.Beer.Label)
+}
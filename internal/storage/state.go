@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+// version of the state document, bumped when its layout changes
+const stateSchemaVersion = 1
+
+// attempts at updating the state when other runs keep changing it
+const maxStateAttempts = 5
+
+// the state document changed since it was read
+var errStateConflict = errors.New("state changed concurrently")
+
+// progress of the recorder, stored in state.json
+type State struct {
+	SchemaVersion int `json:"schema_version"`
+	// newest checkin recorded, the next run fetches the ones after it
+	LatestCheckinID uint64          `json:"latest_checkin_id"`
+	LatestCheckinAt *time.Time      `json:"latest_checkin_at,omitempty"`
+	History         HistoryProgress `json:"history"`
+	LastRun         *RunStats       `json:"last_run,omitempty"`
+	LastSuccessAt   *time.Time      `json:"last_success_at,omitempty"`
+	Runs            int             `json:"runs"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// summary of a run of the recorder
+type RunStats struct {
+	// recent, history or replay
+	Mode       string    `json:"mode"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// checkins stored, and the ones that failed to be
+	Checkins int    `json:"checkins"`
+	Failed   int    `json:"failed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// records the run, it succeeded when it has no error
+func (s *State) AddRun(run RunStats) {
+	s.LastRun = &run
+	s.Runs++
+	if run.Error == "" {
+		finishedAt := run.FinishedAt
+		s.LastSuccessAt = &finishedAt
+	}
+}
+
+// a storage backend able to hold the state document
+type versionedStore interface {
+	// reads the JSON document at key into v and returns its version,
+	// ErrNotFound when missing
+	getVersioned(ctx context.Context, key string, v any) (string, error)
+	// writes v at key if it is still at version, or if it does not exist
+	// when version is empty. Returns errStateConflict otherwise.
+	putVersioned(ctx context.Context, key string, v any, version string) error
+	// the state kept before state.json, in latest.jpg and history.json
+	legacyState(ctx context.Context) (*State, error)
+}
+
+// reads the state along with its version, migrating the legacy one when
+// there is no state document yet
+func loadState(ctx context.Context, s versionedStore) (*State, string, error) {
+	var state State
+	version, err := s.getVersioned(ctx, stateKey, &state)
+	if errors.Is(err, ErrNotFound) {
+		legacy, err := s.legacyState(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to migrate the legacy state: %w", err)
+		}
+		return legacy, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if state.SchemaVersion > stateSchemaVersion {
+		return nil, "", fmt.Errorf(
+			"%q has schema version %d, this version supports up to %d",
+			stateKey,
+			state.SchemaVersion,
+			stateSchemaVersion,
+		)
+	}
+
+	return &state, version, nil
+}
+
+func getState(ctx context.Context, s versionedStore) (*State, error) {
+	state, _, err := loadState(ctx, s)
+	return state, err
+}
+
+// applies update to the latest state and writes it, provided no other run
+// wrote it in between. Reapplied on the new state when one did.
+func updateState(ctx context.Context, s versionedStore, update func(*State) error) error {
+	for attempt := 1; ; attempt++ {
+		state, version, err := loadState(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		if err := update(state); err != nil {
+			return err
+		}
+		state.SchemaVersion = stateSchemaVersion
+		state.UpdatedAt = time.Now().UTC()
+
+		err = s.putVersioned(ctx, stateKey, state, version)
+		if !errors.Is(err, errStateConflict) || attempt == maxStateAttempts {
+			return err
+		}
+		log.Printf("%s changed during the update, retrying\n", stateKey)
+	}
+}
+
+// builds the state from the legacy latest checkin ID and history.json
+func migrateState(
+	ctx context.Context,
+	latestCheckinID uint64,
+	getJSON func(ctx context.Context, key string, v any) error,
+) (*State, error) {
+	var progress HistoryProgress
+	if err := getJSON(ctx, historyKey, &progress); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	if latestCheckinID != 0 || progress.MaxID != 0 || progress.Complete {
+		log.Printf("Migrating %s and %s to %s\n", latestKey, historyKey, stateKey)
+	}
+
+	return &State{LatestCheckinID: latestCheckinID, History: progress}, nil
+}
+
+func getLatestCheckinID(ctx context.Context, s versionedStore) (uint64, error) {
+	state, err := getState(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if state.LatestCheckinID == 0 {
+		log.Println("No latest checkin found, starting from scratch")
+	} else {
+		log.Printf("Latest stored checkinID is: %d\n", state.LatestCheckinID)
+	}
+	return state.LatestCheckinID, nil
+}
+
+// moves the cursor to the checkin, unless a newer one was recorded already
+func updateLatestCheckinID(ctx context.Context, s versionedStore, checkin untappd.Checkin) error {
+	t, err := time.Parse(time.RFC1123Z, checkin.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to parse checkin date %q: %w", checkin.CreatedAt, err)
+	}
+
+	return updateState(ctx, s, func(state *State) error {
+		if checkin.CheckinID > state.LatestCheckinID {
+			state.LatestCheckinID = checkin.CheckinID
+			state.LatestCheckinAt = &t
+		}
+		return nil
+	})
+}
+
+func getStateHistoryProgress(ctx context.Context, s versionedStore) (*HistoryProgress, error) {
+	state, err := getState(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	progress := state.History
+	return &progress, nil
+}
+
+func updateHistoryProgress(ctx context.Context, s versionedStore, progress *HistoryProgress) error {
+	return updateState(ctx, s, func(state *State) error {
+		state.History = *progress
+		return nil
+	})
+}
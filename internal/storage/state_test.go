@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalClient_StateMigration(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, client.put(latestKey, []byte("jpg"), map[string]string{"id": "42"}))
+	require.NoError(t, client.putJSON(ctx, historyKey, &HistoryProgress{MaxID: 7, Checkins: 50}))
+
+	state, err := client.GetState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), state.LatestCheckinID)
+	assert.Equal(t, uint64(7), state.History.MaxID)
+
+	require.NoError(t, client.UpdateState(ctx, func(state *State) error {
+		state.AddRun(RunStats{Mode: "recent", Checkins: 3, FinishedAt: time.Now().UTC()})
+		return nil
+	}))
+
+	// once migrated, the legacy objects are no longer read
+	require.NoError(t, client.put(latestKey, []byte("jpg"), map[string]string{"id": "1"}))
+
+	state, err = client.GetState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, stateSchemaVersion, state.SchemaVersion)
+	assert.Equal(t, uint64(42), state.LatestCheckinID)
+	assert.Equal(t, 50, state.History.Checkins)
+	assert.Equal(t, 1, state.Runs)
+	assert.Equal(t, 3, state.LastRun.Checkins)
+	assert.NotNil(t, state.LastSuccessAt)
+}
+
+func TestLocalClient_StateConflict(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, client.UpdateState(ctx, func(state *State) error {
+		state.LatestCheckinID = 10
+		return nil
+	}))
+
+	var stale State
+	version, err := client.getVersioned(ctx, stateKey, &stale)
+	require.NoError(t, err)
+
+	// another run moves the cursor in between
+	require.NoError(t, client.UpdateLatestCheckinID(ctx, checkinAt(20)))
+
+	err = client.putVersioned(ctx, stateKey, &stale, version)
+	assert.ErrorIs(t, err, errStateConflict)
+	err = client.putVersioned(ctx, stateKey, &stale, "")
+	assert.ErrorIs(t, err, errStateConflict)
+
+	// the cursor never moves backwards
+	require.NoError(t, client.UpdateLatestCheckinID(ctx, checkinAt(15)))
+	id, err := client.GetLatestCheckinID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), id)
+}
+
+// a state store which lets another run write the state once, right before
+// the first write
+type racingStateStore struct {
+	*LocalClient
+	raced bool
+}
+
+func (s *racingStateStore) putVersioned(ctx context.Context, key string, v any, version string) error {
+	if !s.raced {
+		s.raced = true
+		if err := s.LocalClient.UpdateHistoryProgress(ctx, &HistoryProgress{MaxID: 99}); err != nil {
+			return err
+		}
+	}
+	return s.LocalClient.putVersioned(ctx, key, v, version)
+}
+
+func TestUpdateState_Retry(t *testing.T) {
+	client, err := NewLocalClient(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	store := &racingStateStore{LocalClient: client}
+
+	attempts := 0
+	require.NoError(t, updateState(ctx, store, func(state *State) error {
+		attempts++
+		state.LatestCheckinID = 5
+		return nil
+	}))
+	assert.Equal(t, 2, attempts)
+
+	// both updates are kept
+	state, err := client.GetState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), state.LatestCheckinID)
+	assert.Equal(t, uint64(99), state.History.MaxID)
+}
+
+func TestClient_StateConditionalWrites(t *testing.T) {
+	const etag = `"v1"`
+
+	var conditions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(`{"schema_version":1,"latest_checkin_id":10}`))
+		case http.MethodPut:
+			conditions = append(conditions, r.Header.Get("If-Match")+r.Header.Get("If-None-Match"))
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code></Error>`))
+		}
+	}))
+	defer srv.Close()
+
+	client := &Client{
+		s3Client: s3.New(s3.Options{
+			BaseEndpoint: aws.String(srv.URL),
+			UsePathStyle: true,
+			Region:       "us-east-1",
+			Credentials:  aws.AnonymousCredentials{},
+		}),
+		bucketName: "test-bucket",
+	}
+
+	ctx := context.Background()
+	var state State
+	version, err := client.getVersioned(ctx, stateKey, &state)
+	require.NoError(t, err)
+	assert.Equal(t, etag, version)
+	assert.Equal(t, uint64(10), state.LatestCheckinID)
+
+	assert.ErrorIs(t, client.putVersioned(ctx, stateKey, &state, version), errStateConflict)
+	assert.ErrorIs(t, client.putVersioned(ctx, stateKey, &state, ""), errStateConflict)
+	assert.Equal(t, []string{etag, "*"}, conditions)
+
+	// gives up once other runs kept winning
+	err = client.UpdateLatestCheckinID(ctx, checkinAt(11))
+	assert.ErrorIs(t, err, errStateConflict)
+	assert.Len(t, conditions, 2+maxStateAttempts)
+}
+
+func checkinAt(id uint64) untappd.Checkin {
+	return untappd.Checkin{CheckinID: id, CreatedAt: "Sat, 01 Nov 2025 00:00:00 +0000"}
+}
@@ -2,19 +2,91 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/smallwat3r/untappd-recorder/internal/config"
 	"github.com/smallwat3r/untappd-recorder/internal/untappd"
 )
 
+var ErrNotFound = errors.New("object not found")
+
+// the whole archive, each consumer takes the part of it it uses
 type Storage interface {
+	PhotoStore
+	RecordStore
+	ProfileStore
+	RawStore
+	FailureStore
+	StateStore
+}
+
+// the checkin photos along with their metadata, and the objects read back by
+// key
+type PhotoStore interface {
 	UploadJPG(ctx context.Context, file []byte, metadata *CheckinMetadata) error
 	UploadWEBP(ctx context.Context, file []byte, metadata *CheckinMetadata) error
 	Download(ctx context.Context, fileName string) ([]byte, error)
-	CheckinExists(ctx context.Context, checkinID, createdAt string) (bool, error)
-	CheckinWEBPExists(ctx context.Context, checkinID, createdAt string) (bool, error)
+	DownloadMetadata(ctx context.Context, key string) (*CheckinMetadata, error)
+	CheckinExists(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error)
+	CheckinWEBPExists(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error)
+	DeletePhoto(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) error
+}
+
+// the JSON records of the checkins
+type RecordStore interface {
+	UploadRecord(ctx context.Context, record *CheckinRecord) error
+	DownloadRecord(ctx context.Context, checkinID uint64, createdAt time.Time) (*CheckinRecord, error)
+	ListRecords(ctx context.Context, day time.Time) ([]uint64, error)
+}
+
+// the beer, brewery and badge profiles, with their images
+type ProfileStore interface {
+	UploadBeer(ctx context.Context, beer *BeerProfile) error
+	DownloadBeer(ctx context.Context, bid uint64) (*BeerProfile, error)
+	UploadBrewery(ctx context.Context, brewery *BreweryProfile) error
+	DownloadBrewery(ctx context.Context, breweryID uint64) (*BreweryProfile, error)
+	UploadBreweryLogo(ctx context.Context, key string, logo []byte) error
+	UploadLabel(ctx context.Context, key string, label []byte) error
+	UploadBadgeImage(ctx context.Context, key string, image []byte) error
+	UploadBadgeEarn(ctx context.Context, earn *BadgeEarn) error
+	ImageExists(ctx context.Context, key string) (bool, error)
+}
+
+// the API responses archived under raw/
+type RawStore interface {
+	UploadRawResponse(ctx context.Context, raw *untappd.RawResponse) error
+	ListRawResponses(ctx context.Context, method string) ([]string, error)
+	DownloadRawResponse(ctx context.Context, key string) (*untappd.RawResponse, error)
+}
+
+// the checkins which failed to be stored, kept under failures/
+type FailureStore interface {
+	UploadFailure(ctx context.Context, failure *Failure) error
+	DownloadFailure(ctx context.Context, source string, checkinID uint64) (*Failure, error)
+	ListFailures(ctx context.Context, source string) ([]uint64, error)
+	DeleteFailure(ctx context.Context, source string, checkinID uint64) error
+}
+
+// the progress of the recorder, kept in state.json
+type StateStore interface {
 	GetLatestCheckinID(ctx context.Context) (uint64, error)
 	UpdateLatestCheckinID(ctx context.Context, checkin untappd.Checkin) error
+	GetHistoryProgress(ctx context.Context) (*HistoryProgress, error)
+	UpdateHistoryProgress(ctx context.Context, progress *HistoryProgress) error
+	GetState(ctx context.Context) (*State, error)
+	UpdateState(ctx context.Context, update func(*State) error) error
+}
+
+// creates the storage backend selected by the configuration, a local
+// directory takes precedence over a bucket.
+func New(ctx context.Context, cfg *config.Config) (Storage, error) {
+	if cfg.Provider() == config.ProviderLocal {
+		return NewLocalClient(cfg.LocalStoragePath)
+	}
+	return NewClient(ctx, cfg)
 }
 
 type S3Client interface {
@@ -43,6 +115,11 @@ type S3Client interface {
 		params *s3.CopyObjectInput,
 		optFns ...func(*s3.Options),
 	) (*s3.CopyObjectOutput, error)
+	DeleteObject(
+		ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectOutput, error)
 }
 
 // holds the metadata for a checkin photo
@@ -51,6 +128,8 @@ type CheckinMetadata struct {
 	Beer           string
 	Brewery        string
 	BreweryCountry string
+	// key of the brewery profile, set once the brewery is enriched
+	BreweryProfile string
 	Comment        string
 	Rating         string
 	Venue          string
@@ -61,10 +140,18 @@ type CheckinMetadata struct {
 	Date           string
 	Style          string
 	ABV            string
+	// key of the beer label, set once the beer is enriched
+	Label string
+	// social context, comma separated user names and totals
+	TaggedFriends string
+	Toasts        string
+	Comments      string
+	// position of the photo in the checkin, starting at 1
+	Photo int
 }
 
 func (m *CheckinMetadata) ToMap() map[string]string {
-	return map[string]string{
+	md := map[string]string{
 		"id":              m.ID,
 		"beer":            m.Beer,
 		"brewery":         m.Brewery,
@@ -80,4 +167,50 @@ func (m *CheckinMetadata) ToMap() map[string]string {
 		"style":           m.Style,
 		"abv":             m.ABV,
 	}
+	if m.BreweryProfile != "" {
+		md["brewery_profile"] = m.BreweryProfile
+	}
+	if m.Label != "" {
+		md["label"] = m.Label
+	}
+	for k, v := range map[string]string{
+		"tagged_friends": m.TaggedFriends,
+		"toasts":         m.Toasts,
+		"comments":       m.Comments,
+	} {
+		if v != "" {
+			md[k] = v
+		}
+	}
+	// only set on the next photos, keeping the first one unchanged
+	if m.Photo > 1 {
+		md["photo"] = strconv.Itoa(m.Photo)
+	}
+	return md
+}
+
+func CheckinMetadataFromMap(m map[string]string) *CheckinMetadata {
+	photo, _ := strconv.Atoi(m["photo"])
+	return &CheckinMetadata{
+		Photo:          photo,
+		ID:             m["id"],
+		Beer:           m["beer"],
+		Brewery:        m["brewery"],
+		BreweryCountry: m["brewery_country"],
+		BreweryProfile: m["brewery_profile"],
+		Comment:        m["comment"],
+		Rating:         m["rating"],
+		Venue:          m["venue"],
+		City:           m["city"],
+		State:          m["state"],
+		Country:        m["country"],
+		LatLng:         m["latlng"],
+		Date:           m["date"],
+		Style:          m["style"],
+		ABV:            m["abv"],
+		Label:          m["label"],
+		TaggedFriends:  m["tagged_friends"],
+		Toasts:         m["toasts"],
+		Comments:       m["comments"],
+	}
 }
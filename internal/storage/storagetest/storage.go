@@ -0,0 +1,319 @@
+// Package storagetest provides a fake of the storage, for the tests of its
+// consumers. Each method calls the matching Func field when set, and
+// otherwise behaves as an empty archive.
+package storagetest
+
+import (
+	"context"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/storage"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+type Storage struct {
+	// storage.PhotoStore
+	UploadJPGFunc         func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
+	UploadWEBPFunc        func(ctx context.Context, file []byte, metadata *storage.CheckinMetadata) error
+	DownloadFunc          func(ctx context.Context, fileName string) ([]byte, error)
+	DownloadMetadataFunc  func(ctx context.Context, key string) (*storage.CheckinMetadata, error)
+	CheckinExistsFunc     func(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error)
+	CheckinWEBPExistsFunc func(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) (bool, error)
+	DeletePhotoFunc       func(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) error
+
+	// storage.RecordStore
+	UploadRecordFunc   func(ctx context.Context, record *storage.CheckinRecord) error
+	DownloadRecordFunc func(ctx context.Context, checkinID uint64, createdAt time.Time) (*storage.CheckinRecord, error)
+	ListRecordsFunc    func(ctx context.Context, day time.Time) ([]uint64, error)
+
+	// storage.ProfileStore
+	UploadBeerFunc        func(ctx context.Context, beer *storage.BeerProfile) error
+	DownloadBeerFunc      func(ctx context.Context, bid uint64) (*storage.BeerProfile, error)
+	UploadBreweryFunc     func(ctx context.Context, brewery *storage.BreweryProfile) error
+	DownloadBreweryFunc   func(ctx context.Context, breweryID uint64) (*storage.BreweryProfile, error)
+	UploadBreweryLogoFunc func(ctx context.Context, key string, logo []byte) error
+	UploadLabelFunc       func(ctx context.Context, key string, label []byte) error
+	UploadBadgeImageFunc  func(ctx context.Context, key string, image []byte) error
+	UploadBadgeEarnFunc   func(ctx context.Context, earn *storage.BadgeEarn) error
+	ImageExistsFunc       func(ctx context.Context, key string) (bool, error)
+
+	// storage.RawStore
+	UploadRawResponseFunc   func(ctx context.Context, raw *untappd.RawResponse) error
+	ListRawResponsesFunc    func(ctx context.Context, method string) ([]string, error)
+	DownloadRawResponseFunc func(ctx context.Context, key string) (*untappd.RawResponse, error)
+
+	// storage.FailureStore
+	UploadFailureFunc   func(ctx context.Context, failure *storage.Failure) error
+	DownloadFailureFunc func(ctx context.Context, source string, checkinID uint64) (*storage.Failure, error)
+	ListFailuresFunc    func(ctx context.Context, source string) ([]uint64, error)
+	DeleteFailureFunc   func(ctx context.Context, source string, checkinID uint64) error
+
+	// storage.StateStore
+	GetLatestCheckinIDFunc    func(ctx context.Context) (uint64, error)
+	UpdateLatestCheckinIDFunc func(ctx context.Context, checkin untappd.Checkin) error
+	GetHistoryProgressFunc    func(ctx context.Context) (*storage.HistoryProgress, error)
+	UpdateHistoryProgressFunc func(ctx context.Context, progress *storage.HistoryProgress) error
+	GetStateFunc              func(ctx context.Context) (*storage.State, error)
+	UpdateStateFunc           func(ctx context.Context, update func(*storage.State) error) error
+}
+
+func (m *Storage) UploadJPG(
+	ctx context.Context,
+	file []byte,
+	metadata *storage.CheckinMetadata,
+) error {
+	if m.UploadJPGFunc != nil {
+		return m.UploadJPGFunc(ctx, file, metadata)
+	}
+	return nil
+}
+
+func (m *Storage) UploadWEBP(
+	ctx context.Context,
+	file []byte,
+	metadata *storage.CheckinMetadata,
+) error {
+	if m.UploadWEBPFunc != nil {
+		return m.UploadWEBPFunc(ctx, file, metadata)
+	}
+	return nil
+}
+
+func (m *Storage) Download(ctx context.Context, fileName string) ([]byte, error) {
+	if m.DownloadFunc != nil {
+		return m.DownloadFunc(ctx, fileName)
+	}
+	return nil, nil
+}
+
+func (m *Storage) DownloadMetadata(ctx context.Context, key string) (*storage.CheckinMetadata, error) {
+	if m.DownloadMetadataFunc != nil {
+		return m.DownloadMetadataFunc(ctx, key)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) CheckinExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	if m.CheckinExistsFunc != nil {
+		return m.CheckinExistsFunc(ctx, checkinID, createdAt, photo)
+	}
+	return false, nil
+}
+
+func (m *Storage) CheckinWEBPExists(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+	photo int,
+) (bool, error) {
+	if m.CheckinWEBPExistsFunc != nil {
+		return m.CheckinWEBPExistsFunc(ctx, checkinID, createdAt, photo)
+	}
+	return false, nil
+}
+
+func (m *Storage) DeletePhoto(ctx context.Context, checkinID uint64, createdAt time.Time, photo int) error {
+	if m.DeletePhotoFunc != nil {
+		return m.DeletePhotoFunc(ctx, checkinID, createdAt, photo)
+	}
+	return nil
+}
+
+func (m *Storage) UploadRecord(ctx context.Context, record *storage.CheckinRecord) error {
+	if m.UploadRecordFunc != nil {
+		return m.UploadRecordFunc(ctx, record)
+	}
+	return nil
+}
+
+func (m *Storage) DownloadRecord(
+	ctx context.Context,
+	checkinID uint64,
+	createdAt time.Time,
+) (*storage.CheckinRecord, error) {
+	if m.DownloadRecordFunc != nil {
+		return m.DownloadRecordFunc(ctx, checkinID, createdAt)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) ListRecords(ctx context.Context, day time.Time) ([]uint64, error) {
+	if m.ListRecordsFunc != nil {
+		return m.ListRecordsFunc(ctx, day)
+	}
+	return nil, nil
+}
+
+func (m *Storage) UploadBeer(ctx context.Context, beer *storage.BeerProfile) error {
+	if m.UploadBeerFunc != nil {
+		return m.UploadBeerFunc(ctx, beer)
+	}
+	return nil
+}
+
+func (m *Storage) DownloadBeer(ctx context.Context, bid uint64) (*storage.BeerProfile, error) {
+	if m.DownloadBeerFunc != nil {
+		return m.DownloadBeerFunc(ctx, bid)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) UploadBrewery(ctx context.Context, brewery *storage.BreweryProfile) error {
+	if m.UploadBreweryFunc != nil {
+		return m.UploadBreweryFunc(ctx, brewery)
+	}
+	return nil
+}
+
+func (m *Storage) DownloadBrewery(
+	ctx context.Context,
+	breweryID uint64,
+) (*storage.BreweryProfile, error) {
+	if m.DownloadBreweryFunc != nil {
+		return m.DownloadBreweryFunc(ctx, breweryID)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) UploadBreweryLogo(ctx context.Context, key string, logo []byte) error {
+	if m.UploadBreweryLogoFunc != nil {
+		return m.UploadBreweryLogoFunc(ctx, key, logo)
+	}
+	return nil
+}
+
+func (m *Storage) UploadLabel(ctx context.Context, key string, label []byte) error {
+	if m.UploadLabelFunc != nil {
+		return m.UploadLabelFunc(ctx, key, label)
+	}
+	return nil
+}
+
+func (m *Storage) UploadBadgeImage(ctx context.Context, key string, image []byte) error {
+	if m.UploadBadgeImageFunc != nil {
+		return m.UploadBadgeImageFunc(ctx, key, image)
+	}
+	return nil
+}
+
+func (m *Storage) UploadBadgeEarn(ctx context.Context, earn *storage.BadgeEarn) error {
+	if m.UploadBadgeEarnFunc != nil {
+		return m.UploadBadgeEarnFunc(ctx, earn)
+	}
+	return nil
+}
+
+func (m *Storage) ImageExists(ctx context.Context, key string) (bool, error) {
+	if m.ImageExistsFunc != nil {
+		return m.ImageExistsFunc(ctx, key)
+	}
+	return false, nil
+}
+
+func (m *Storage) UploadRawResponse(ctx context.Context, raw *untappd.RawResponse) error {
+	if m.UploadRawResponseFunc != nil {
+		return m.UploadRawResponseFunc(ctx, raw)
+	}
+	return nil
+}
+
+func (m *Storage) ListRawResponses(ctx context.Context, method string) ([]string, error) {
+	if m.ListRawResponsesFunc != nil {
+		return m.ListRawResponsesFunc(ctx, method)
+	}
+	return nil, nil
+}
+
+func (m *Storage) DownloadRawResponse(ctx context.Context, key string) (*untappd.RawResponse, error) {
+	if m.DownloadRawResponseFunc != nil {
+		return m.DownloadRawResponseFunc(ctx, key)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) UploadFailure(ctx context.Context, failure *storage.Failure) error {
+	if m.UploadFailureFunc != nil {
+		return m.UploadFailureFunc(ctx, failure)
+	}
+	return nil
+}
+
+func (m *Storage) DownloadFailure(
+	ctx context.Context,
+	source string,
+	checkinID uint64,
+) (*storage.Failure, error) {
+	if m.DownloadFailureFunc != nil {
+		return m.DownloadFailureFunc(ctx, source, checkinID)
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *Storage) ListFailures(ctx context.Context, source string) ([]uint64, error) {
+	if m.ListFailuresFunc != nil {
+		return m.ListFailuresFunc(ctx, source)
+	}
+	return nil, nil
+}
+
+func (m *Storage) DeleteFailure(ctx context.Context, source string, checkinID uint64) error {
+	if m.DeleteFailureFunc != nil {
+		return m.DeleteFailureFunc(ctx, source, checkinID)
+	}
+	return nil
+}
+
+func (m *Storage) GetLatestCheckinID(ctx context.Context) (uint64, error) {
+	if m.GetLatestCheckinIDFunc != nil {
+		return m.GetLatestCheckinIDFunc(ctx)
+	}
+	return 0, nil
+}
+
+func (m *Storage) UpdateLatestCheckinID(
+	ctx context.Context,
+	checkin untappd.Checkin,
+) error {
+	if m.UpdateLatestCheckinIDFunc != nil {
+		return m.UpdateLatestCheckinIDFunc(ctx, checkin)
+	}
+	return nil
+}
+
+func (m *Storage) GetHistoryProgress(ctx context.Context) (*storage.HistoryProgress, error) {
+	if m.GetHistoryProgressFunc != nil {
+		return m.GetHistoryProgressFunc(ctx)
+	}
+	return &storage.HistoryProgress{}, nil
+}
+
+func (m *Storage) UpdateHistoryProgress(
+	ctx context.Context,
+	progress *storage.HistoryProgress,
+) error {
+	if m.UpdateHistoryProgressFunc != nil {
+		return m.UpdateHistoryProgressFunc(ctx, progress)
+	}
+	return nil
+}
+
+func (m *Storage) GetState(ctx context.Context) (*storage.State, error) {
+	if m.GetStateFunc != nil {
+		return m.GetStateFunc(ctx)
+	}
+	return &storage.State{}, nil
+}
+
+func (m *Storage) UpdateState(ctx context.Context, update func(*storage.State) error) error {
+	if m.UpdateStateFunc != nil {
+		return m.UpdateStateFunc(ctx, update)
+	}
+	return nil
+}
@@ -4,35 +4,74 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/smallwat3r/untappd-recorder/internal/config"
 )
 
+// used when no base URL is configured
+const defaultBaseURL = "https://api.untappd.com/v4"
+
+// API method listing the checkins of the user
+const CheckinsMethod = "/user/checkins"
+
+// number of checkins requested per page when walking the history, the
+// maximum allowed by the API
+const historyPageSize = 50
+
 type UntappdClient interface {
 	FetchCheckins(
 		ctx context.Context,
 		sinceID uint64,
 		checkinProcessor func(context.Context, []Checkin) error,
 	) error
+	FetchHistory(
+		ctx context.Context,
+		maxID uint64,
+		pageProcessor func(ctx context.Context, checkins []Checkin, nextMaxID uint64) error,
+	) error
+	FetchBeer(ctx context.Context, bid uint64) (*BeerInfo, error)
+	FetchBrewery(ctx context.Context, breweryID uint64) (*BreweryInfo, error)
 }
 
 type Client struct {
-	cfg    *config.Config
-	client *http.Client
+	cfg     *config.Config
+	client  *http.Client
+	retry   retryPolicy
+	archive ArchiveFunc
 }
 
-func NewClient(cfg *config.Config) UntappdClient {
-	return &Client{
+func NewClient(cfg *config.Config, opts ...Option) UntappdClient {
+	c := &Client{
 		cfg: cfg,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		retry: retryPolicy{
+			maxRetries: cfg.UntappdMaxRetries,
+			baseDelay:  baseRetryDelay,
+			maxWait:    cfg.UntappdMaxWait,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// full URL of an API method, e.g. /user/checkins
+func (c *Client) endpoint(method string) string {
+	baseURL := c.cfg.UntappdBaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
 	}
+	return strings.TrimSuffix(baseURL, "/") + method
 }
 
 func extractCheckins(r *UntappdResponse) ([]Checkin, error) {
@@ -48,26 +87,61 @@ func extractCheckins(r *UntappdResponse) ([]Checkin, error) {
 	}
 }
 
-func (c *Client) handleResponse(
+// reads the body of a successful response and archives it
+func (c *Client) readResponse(
 	ctx context.Context,
 	resp *http.Response,
-	checkinProcessor func(context.Context, []Checkin) error,
-) (uint64, bool, error) {
+	method string,
+	params url.Values,
+) (*RawResponse, error) {
 	if resp.StatusCode != http.StatusOK {
-		return 0, true, fmt.Errorf("API request failed with status: %s", resp.Status)
+		return nil, newAPIError(resp)
 	}
 
-	if resp.Header.Get("X-Ratelimit-Remaining") == "0" {
-		log.Println("untappd API rate limit reached. Stopping for now.")
-		return 0, true, nil
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var untappdResp UntappdResponse
-	if err := json.NewDecoder(resp.Body).Decode(&untappdResp); err != nil {
-		return 0, true, fmt.Errorf("failed to decode response: %w", err)
+	raw := &RawResponse{
+		Method:    method,
+		Params:    params,
+		FetchedAt: time.Now().UTC(),
+		Body:      body,
+	}
+	if c.archive != nil {
+		if err := c.archive(ctx, raw); err != nil {
+			log.Printf("failed to archive %s response: %v", method, err)
+		}
+	}
+
+	return raw, nil
+}
+
+func (c *Client) decodeResponse(
+	ctx context.Context,
+	resp *http.Response,
+	params url.Values,
+) (*UntappdResponse, error) {
+	raw, err := c.readResponse(ctx, resp, CheckinsMethod, params)
+	if err != nil {
+		return nil, err
+	}
+	return raw.decode()
+}
+
+func (c *Client) handleResponse(
+	ctx context.Context,
+	resp *http.Response,
+	params url.Values,
+	checkinProcessor func(context.Context, []Checkin) error,
+) (uint64, bool, error) {
+	untappdResp, err := c.decodeResponse(ctx, resp, params)
+	if err != nil {
+		return 0, true, err
 	}
 
-	checkins, err := extractCheckins(&untappdResp)
+	checkins, err := extractCheckins(untappdResp)
 	if err != nil {
 		return 0, true, err
 	}
@@ -85,7 +159,7 @@ func (c *Client) handleResponse(
 		return 0, true, nil
 	}
 
-	nextMinID, err := parseMinID(sinceURL)
+	nextMinID, err := parseQueryID(sinceURL, "min_id")
 	if err != nil {
 		return 0, true, fmt.Errorf(
 			"failed to parse min_id from since_url %q: %w",
@@ -94,23 +168,86 @@ func (c *Client) handleResponse(
 		)
 	}
 
+	if err := rateLimitExhausted(resp); err != nil {
+		return 0, true, err
+	}
+
 	return nextMinID, false, nil
 }
 
-func parseMinID(rawURL string) (uint64, error) {
+// handles a page of the history, returning the max_id of the next (older)
+// page, or 0 once the first checkin of the account has been reached.
+func (c *Client) handleHistoryResponse(
+	ctx context.Context,
+	resp *http.Response,
+	params url.Values,
+	maxID uint64,
+	pageProcessor func(context.Context, []Checkin, uint64) error,
+) (uint64, bool, error) {
+	untappdResp, err := c.decodeResponse(ctx, resp, params)
+	if err != nil {
+		return 0, true, err
+	}
+
+	checkins, err := extractCheckins(untappdResp)
+	if err != nil {
+		return 0, true, err
+	}
+
+	var nextMaxID uint64
+	if nextURL := untappdResp.Response.Pagination.NextURL; nextURL != "" && len(checkins) > 0 {
+		nextMaxID, err = parseQueryID(nextURL, "max_id")
+		if err != nil {
+			return 0, true, fmt.Errorf(
+				"failed to parse max_id from next_url %q: %w",
+				nextURL,
+				err,
+			)
+		}
+		if maxID != 0 && nextMaxID >= maxID {
+			return 0, true, fmt.Errorf("history pagination did not move past max_id %d", maxID)
+		}
+	}
+
+	if err := pageProcessor(ctx, checkins, nextMaxID); err != nil {
+		return 0, true, fmt.Errorf("failed to process checkins: %w", err)
+	}
+
+	if nextMaxID == 0 {
+		return 0, true, nil
+	}
+
+	if err := rateLimitExhausted(resp); err != nil {
+		return 0, true, err
+	}
+
+	return nextMaxID, false, nil
+}
+
+// the page has been processed, but there are no calls left to fetch the
+// next one this hour
+func rateLimitExhausted(resp *http.Response) error {
+	if resp.Header.Get("X-Ratelimit-Remaining") != "0" {
+		return nil
+	}
+	log.Println("untappd API rate limit reached. Stopping for now.")
+	return &RateLimitError{RetryAfter: retryAfter(resp.Header, time.Now())}
+}
+
+func parseQueryID(rawURL, name string) (uint64, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return 0, fmt.Errorf("failed to parse URL %q: %w", rawURL, err)
 	}
 
-	minIDStr := u.Query().Get("min_id")
-	if minIDStr == "" {
-		return 0, fmt.Errorf("min_id not found in %q", rawURL)
+	idStr := u.Query().Get(name)
+	if idStr == "" {
+		return 0, fmt.Errorf("%s not found in %q", name, rawURL)
 	}
 
-	v, err := strconv.ParseUint(minIDStr, 10, 64)
+	v, err := strconv.ParseUint(idStr, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse min_id %q: %w", minIDStr, err)
+		return 0, fmt.Errorf("failed to parse %s %q: %w", name, idStr, err)
 	}
 
 	return v, nil
@@ -119,7 +256,7 @@ func parseMinID(rawURL string) (uint64, error) {
 func (c *Client) buildRequest(
 	ctx context.Context,
 	endpoint string,
-	minID uint64,
+	params url.Values,
 ) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
@@ -128,16 +265,35 @@ func (c *Client) buildRequest(
 
 	q := req.URL.Query()
 	q.Add("access_token", c.cfg.UntappdAccessToken)
+	for k, vs := range params {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+
+	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+func checkinsParams(minID uint64) url.Values {
+	params := url.Values{}
 	if minID != 0 {
-		q.Add("min_id", strconv.FormatUint(minID, 10))
+		params.Set("min_id", strconv.FormatUint(minID, 10))
 	} else {
 		// if sinceID is 0, it means we are starting from scratch, so we only
 		// want to fetch the first checkin and stop.
-		q.Add("limit", "1")
+		params.Set("limit", "1")
 	}
+	return params
+}
 
-	req.URL.RawQuery = q.Encode()
-	return req, nil
+func historyParams(maxID uint64) url.Values {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(historyPageSize))
+	if maxID != 0 {
+		params.Set("max_id", strconv.FormatUint(maxID, 10))
+	}
+	return params
 }
 
 func (c *Client) FetchCheckins(
@@ -145,23 +301,23 @@ func (c *Client) FetchCheckins(
 	sinceID uint64,
 	checkinProcessor func(context.Context, []Checkin) error,
 ) error {
-	endpoint := "https://api.untappd.com/v4/user/checkins"
+	endpoint := c.endpoint(CheckinsMethod)
 	minID := sinceID
 
 	for {
-		req, err := c.buildRequest(ctx, endpoint, minID)
+		params := checkinsParams(minID)
+		req, err := c.buildRequest(ctx, endpoint, params)
 		if err != nil {
 			return err
 		}
 
-		resp, err := c.client.Do(req)
+		resp, err := c.do(req)
 		if err != nil {
 			return err
 		}
 
-		defer resp.Body.Close()
-
-		newMinID, shouldBreak, err := c.handleResponse(ctx, resp, checkinProcessor)
+		newMinID, shouldBreak, err := c.handleResponse(ctx, resp, params, checkinProcessor)
+		resp.Body.Close()
 		if err != nil {
 			return err
 		}
@@ -174,3 +330,94 @@ func (c *Client) FetchCheckins(
 
 	return nil
 }
+
+// walks the checkins backwards, newest first, starting at maxID (or the
+// latest checkin when 0). pageProcessor receives the max_id of the next page
+// so callers can checkpoint their progress, it is 0 on the last page.
+func (c *Client) FetchHistory(
+	ctx context.Context,
+	maxID uint64,
+	pageProcessor func(ctx context.Context, checkins []Checkin, nextMaxID uint64) error,
+) error {
+	for {
+		params := historyParams(maxID)
+		req, err := c.buildRequest(ctx, c.endpoint(CheckinsMethod), params)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return err
+		}
+
+		nextMaxID, shouldBreak, err := c.handleHistoryResponse(ctx, resp, params, maxID, pageProcessor)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if shouldBreak {
+			return nil
+		}
+
+		maxID = nextMaxID
+	}
+}
+
+// calls an API method and decodes the response object into v
+func (c *Client) get(ctx context.Context, method string, params url.Values, v any) error {
+	req, err := c.buildRequest(ctx, c.endpoint(method), params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := c.readResponse(ctx, resp, method, params)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Response any `json:"response"`
+	}{Response: v}
+	if err := json.Unmarshal(raw.Body, &body); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) FetchBeer(ctx context.Context, bid uint64) (*BeerInfo, error) {
+	var resp struct {
+		Beer *BeerInfo `json:"beer"`
+	}
+	params := url.Values{}
+	params.Set("compact", "true")
+	if err := c.get(ctx, fmt.Sprintf("/beer/info/%d", bid), params, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch beer %d: %w", bid, err)
+	}
+	if resp.Beer == nil {
+		return nil, fmt.Errorf("no beer found in response for %d", bid)
+	}
+	return resp.Beer, nil
+}
+
+func (c *Client) FetchBrewery(ctx context.Context, breweryID uint64) (*BreweryInfo, error) {
+	var resp struct {
+		Brewery *BreweryInfo `json:"brewery"`
+	}
+	params := url.Values{}
+	params.Set("compact", "true")
+	if err := c.get(ctx, fmt.Sprintf("/brewery/info/%d", breweryID), params, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch brewery %d: %w", breweryID, err)
+	}
+	if resp.Brewery == nil {
+		return nil, fmt.Errorf("no brewery found in response for %d", breweryID)
+	}
+	return resp.Brewery, nil
+}
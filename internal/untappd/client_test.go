@@ -51,6 +51,89 @@ func TestFetchCheckins_RateLimit(t *testing.T) {
 	}
 }
 
+func TestFetchHistory(t *testing.T) {
+	pages := map[string]string{
+		"": `{"response":{"pagination":{"next_url":"https://api.untappd.com/v4/user/checkins?max_id=98"},
+			"checkins":{"items":[{"checkin_id":100},{"checkin_id":99}]}}}`,
+		"98": `{"response":{"pagination":{"next_url":""},
+			"checkins":{"items":[{"checkin_id":98}]}}}`,
+	}
+
+	var requested []string
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			q := r.URL.Query()
+			if q.Get("limit") != "50" {
+				t.Errorf("expected limit=50, got %q", q.Get("limit"))
+			}
+			maxID := q.Get("max_id")
+			requested = append(requested, maxID)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(pages[maxID])),
+			}, nil
+		}),
+	}
+
+	client := newTestClient(&config.Config{UntappdAccessToken: "test-token"}, mockClient)
+
+	var seen []uint64
+	var nextMaxIDs []uint64
+	err := client.FetchHistory(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []Checkin, nextMaxID uint64) error {
+			for _, c := range checkins {
+				seen = append(seen, c.CheckinID)
+			}
+			nextMaxIDs = append(nextMaxIDs, nextMaxID)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("FetchHistory returned error: %v", err)
+	}
+
+	if len(requested) != 2 || requested[0] != "" || requested[1] != "98" {
+		t.Errorf("unexpected max_id sequence: %v", requested)
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected 3 checkins, got %v", seen)
+	}
+	if len(nextMaxIDs) != 2 || nextMaxIDs[0] != 98 || nextMaxIDs[1] != 0 {
+		t.Errorf("unexpected checkpoints: %v", nextMaxIDs)
+	}
+}
+
+func TestFetchHistory_NoProgress(t *testing.T) {
+	mockClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body: io.NopCloser(strings.NewReader(
+					`{"response":{"pagination":{"next_url":"https://api.untappd.com/v4/user/checkins?max_id=10"},
+					"checkins":{"items":[{"checkin_id":10}]}}}`,
+				)),
+			}, nil
+		}),
+	}
+
+	client := newTestClient(&config.Config{UntappdAccessToken: "test-token"}, mockClient)
+
+	err := client.FetchHistory(
+		context.Background(),
+		10,
+		func(ctx context.Context, checkins []Checkin, nextMaxID uint64) error {
+			return nil
+		},
+	)
+	if err == nil {
+		t.Fatal("expected an error when pagination does not move backwards")
+	}
+}
+
 type roundTripFunc func(r *http.Request) (*http.Response, error)
 
 func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
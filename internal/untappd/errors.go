@@ -0,0 +1,117 @@
+package untappd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("untappd access token is invalid or revoked")
+	ErrInvalidParam = errors.New("untappd API rejected a request parameter")
+	ErrRateLimited  = errors.New("untappd API rate limit reached")
+)
+
+// the meta object describing the outcome of every Untappd API call
+type Meta struct {
+	Code              int    `json:"code"`
+	ErrorType         string `json:"error_type,omitempty"`
+	ErrorDetail       string `json:"error_detail,omitempty"`
+	DeveloperFriendly string `json:"developer_friendly,omitempty"`
+}
+
+// a failed Untappd API call. Matches ErrInvalidToken, ErrInvalidParam or
+// ErrRateLimited with errors.Is depending on the error type in the meta.
+type APIError struct {
+	StatusCode int
+	Meta       Meta
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("untappd API request failed with status %d", e.StatusCode)
+	if e.Meta.ErrorType != "" {
+		msg += ": " + e.Meta.ErrorType
+	}
+	if e.Meta.ErrorDetail != "" {
+		msg += ": " + e.Meta.ErrorDetail
+	}
+	return msg
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalidToken:
+		switch e.Meta.ErrorType {
+		case "invalid_auth", "invalid_token", "auth_failed":
+			return true
+		}
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrInvalidParam:
+		switch e.Meta.ErrorType {
+		case "invalid_param", "param_error":
+			return true
+		}
+		return false
+	case ErrRateLimited:
+		return e.Meta.ErrorType == "invalid_limit" || e.StatusCode == http.StatusTooManyRequests
+	default:
+		return false
+	}
+}
+
+// true for server side failures worth retrying on a later run
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError &&
+		!errors.Is(e, ErrInvalidToken) &&
+		!errors.Is(e, ErrInvalidParam)
+}
+
+// returned once the hourly rate limit is exhausted, with the time the API
+// expects us to wait when it told us. Matches ErrRateLimited with errors.Is,
+// and unwraps to the *APIError when the API answered with an error.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        *APIError
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%v, retry after %s", ErrRateLimited, e.RetryAfter)
+	}
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+func (e *RateLimitError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+// builds the error of a failed call, decoding the meta object of the body
+// when there is one. Untappd reports some failures, such as an invalid
+// token, with a 500 status so the meta is the only way to tell them apart.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return apiErr
+	}
+
+	var body struct {
+		Meta Meta `json:"meta"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil {
+		apiErr.Meta = body.Meta
+	}
+
+	return apiErr
+}
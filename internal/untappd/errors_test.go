@@ -0,0 +1,117 @@
+package untappd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIError_Is(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       *APIError
+		target    error
+		want      bool
+		temporary bool
+	}{
+		{
+			name:   "invalid auth",
+			err:    &APIError{StatusCode: 500, Meta: Meta{ErrorType: "invalid_auth"}},
+			target: ErrInvalidToken,
+			want:   true,
+		},
+		{
+			name:   "unauthorized status",
+			err:    &APIError{StatusCode: 401},
+			target: ErrInvalidToken,
+			want:   true,
+		},
+		{
+			name:   "invalid param",
+			err:    &APIError{StatusCode: 400, Meta: Meta{ErrorType: "invalid_param"}},
+			target: ErrInvalidParam,
+			want:   true,
+		},
+		{
+			name:   "invalid limit",
+			err:    &APIError{StatusCode: 429, Meta: Meta{ErrorType: "invalid_limit"}},
+			target: ErrRateLimited,
+			want:   true,
+		},
+		{
+			name:      "server error",
+			err:       &APIError{StatusCode: 503},
+			target:    ErrInvalidToken,
+			want:      false,
+			temporary: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("expected errors.Is to be %v, got %v", tt.want, got)
+			}
+			if got := tt.err.Temporary(); got != tt.temporary {
+				t.Errorf("expected Temporary to be %v, got %v", tt.temporary, got)
+			}
+		})
+	}
+}
+
+func TestDo_InvalidToken(t *testing.T) {
+	var slept []time.Duration
+	client, calls := newRetryTestClient([]*http.Response{
+		response(
+			http.StatusInternalServerError,
+			nil,
+			`{"meta":{"code":500,"error_detail":"Invalid access token","error_type":"invalid_auth"},"response":[]}`,
+		),
+	}, &slept)
+
+	err := client.FetchCheckins(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []Checkin) error { return nil },
+	)
+
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError, got %T", err)
+	}
+	if apiErr.Meta.ErrorDetail != "Invalid access token" {
+		t.Errorf("expected the meta to be decoded, got %+v", apiErr.Meta)
+	}
+	if *calls != 1 {
+		t.Errorf("expected an invalid token not to be retried, got %d calls", *calls)
+	}
+}
+
+func TestDo_RateLimitMeta(t *testing.T) {
+	var slept []time.Duration
+	client, _ := newRetryTestClient([]*http.Response{
+		response(
+			http.StatusTooManyRequests,
+			nil,
+			`{"meta":{"code":429,"error_detail":"You have exceeded the API limit","error_type":"invalid_limit"}}`,
+		),
+	}, &slept)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.untappd.com/v4/user/checkins", nil)
+	_, err := client.do(req)
+
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Meta.ErrorType != "invalid_limit" {
+		t.Errorf("expected the meta to be attached, got %v", err)
+	}
+}
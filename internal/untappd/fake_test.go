@@ -0,0 +1,197 @@
+package untappd_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+	"github.com/smallwat3r/untappd-recorder/internal/untappd/untappdtest"
+)
+
+func newFakeServer(t *testing.T, n int) *untappdtest.Server {
+	t.Helper()
+
+	srv := untappdtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	start := time.Date(2025, 1, 1, 18, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		srv.AddCheckins(srv.NewCheckin(uint64(i), start.AddDate(0, 0, i)))
+	}
+	return srv
+}
+
+func newFakeClient(srv *untappdtest.Server) untappd.UntappdClient {
+	return untappd.NewClient(&config.Config{
+		UntappdAccessToken: "test-token",
+		UntappdBaseURL:     srv.BaseURL(),
+		UntappdMaxRetries:  2,
+		UntappdMaxWait:     time.Second,
+	})
+}
+
+func TestFetchCheckins_Fake(t *testing.T) {
+	srv := newFakeServer(t, 30)
+
+	var ids []uint64
+	err := newFakeClient(srv).FetchCheckins(
+		context.Background(),
+		27,
+		func(ctx context.Context, checkins []untappd.Checkin) error {
+			for _, c := range checkins {
+				ids = append(ids, c.CheckinID)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("FetchCheckins returned error: %v", err)
+	}
+
+	if len(ids) != 3 || ids[0] != 30 || ids[2] != 28 {
+		t.Errorf("expected checkins 30 to 28, got %v", ids)
+	}
+}
+
+func TestFetchHistory_Fake(t *testing.T) {
+	srv := newFakeServer(t, 120)
+
+	var ids []uint64
+	err := newFakeClient(srv).FetchHistory(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error {
+			for _, c := range checkins {
+				ids = append(ids, c.CheckinID)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("FetchHistory returned error: %v", err)
+	}
+
+	if len(ids) != 120 || ids[0] != 120 || ids[119] != 1 {
+		t.Errorf("expected the 120 checkins newest first, got %d", len(ids))
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("expected 3 pages of 50, got %d requests", n)
+	}
+}
+
+func TestFetchHistory_FakeArchive(t *testing.T) {
+	srv := newFakeServer(t, 60)
+
+	var archived []*untappd.RawResponse
+	client := untappd.NewClient(&config.Config{
+		UntappdAccessToken: "test-token",
+		UntappdBaseURL:     srv.BaseURL(),
+	}, untappd.WithArchive(func(ctx context.Context, raw *untappd.RawResponse) error {
+		archived = append(archived, raw)
+		// archiving is best effort
+		return errors.New("bucket unavailable")
+	}))
+
+	var ids []uint64
+	err := client.FetchHistory(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error {
+			for _, c := range checkins {
+				ids = append(ids, c.CheckinID)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("FetchHistory returned error: %v", err)
+	}
+	if len(ids) != 60 {
+		t.Errorf("expected the 60 checkins, got %d", len(ids))
+	}
+
+	if len(archived) != 2 {
+		t.Fatalf("expected the 2 pages to be archived, got %d", len(archived))
+	}
+	second := archived[1]
+	if second.Method != "/user/checkins" || second.Params.Get("max_id") != "11" || second.FetchedAt.IsZero() {
+		t.Errorf("expected the page parameters to be archived, got %+v", second)
+	}
+	if second.Params.Has("access_token") {
+		t.Error("expected the access token not to be archived")
+	}
+
+	checkins, err := second.Checkins()
+	if err != nil {
+		t.Fatalf("failed to decode archived page: %v", err)
+	}
+	if len(checkins) != 10 || checkins[0].CheckinID != 10 {
+		t.Errorf("expected the archived page to hold checkins 10 to 1, got %d", len(checkins))
+	}
+}
+
+func TestFetchHistory_FakeRateLimit(t *testing.T) {
+	srv := newFakeServer(t, 120)
+	srv.SetRateLimit(2)
+
+	var lastMaxID uint64
+	err := newFakeClient(srv).FetchHistory(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []untappd.Checkin, nextMaxID uint64) error {
+			lastMaxID = nextMaxID
+			return nil
+		},
+	)
+
+	if !errors.Is(err, untappd.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if lastMaxID != 21 {
+		t.Errorf("expected the checkpoint to be checkin 21, got %d", lastMaxID)
+	}
+}
+
+func TestFetchCheckins_FakeErrors(t *testing.T) {
+	t.Run("should report a revoked token", func(t *testing.T) {
+		srv := newFakeServer(t, 1)
+		srv.Token = "another-token"
+
+		err := newFakeClient(srv).FetchCheckins(
+			context.Background(),
+			0,
+			func(ctx context.Context, checkins []untappd.Checkin) error { return nil },
+		)
+		if !errors.Is(err, untappd.ErrInvalidToken) {
+			t.Fatalf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("should retry transient failures", func(t *testing.T) {
+		srv := newFakeServer(t, 1)
+		srv.Fail(untappdtest.Failure{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Retry-After": []string{"0"}},
+		})
+
+		var processed int
+		err := newFakeClient(srv).FetchCheckins(
+			context.Background(),
+			0,
+			func(ctx context.Context, checkins []untappd.Checkin) error {
+				processed += len(checkins)
+				return nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("FetchCheckins returned error: %v", err)
+		}
+		if processed != 1 {
+			t.Errorf("expected 1 checkin, got %d", processed)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package untappd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// an API response as received, archived so the recorder can be replayed
+// without calling Untappd
+type RawResponse struct {
+	// API method, e.g. /user/checkins
+	Method string `json:"method"`
+	// query string, without the access token
+	Params    url.Values      `json:"params"`
+	FetchedAt time.Time       `json:"fetched_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// receives every successful API response before it is decoded
+type ArchiveFunc func(ctx context.Context, raw *RawResponse) error
+
+type Option func(*Client)
+
+// archives the raw API responses, failures are logged and do not stop the
+// calls
+func WithArchive(archive ArchiveFunc) Option {
+	return func(c *Client) {
+		c.archive = archive
+	}
+}
+
+func (r *RawResponse) decode() (*UntappdResponse, error) {
+	var resp UntappdResponse
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &resp, nil
+}
+
+// checkins of a /user/checkins response
+func (r *RawResponse) Checkins() ([]Checkin, error) {
+	resp, err := r.decode()
+	if err != nil {
+		return nil, err
+	}
+	return extractCheckins(resp)
+}
+
+// beer of a /beer/info response
+func (r *RawResponse) Beer() (*BeerInfo, error) {
+	var body struct {
+		Response struct {
+			Beer *BeerInfo `json:"beer"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if body.Response.Beer == nil {
+		return nil, fmt.Errorf("no beer found in response")
+	}
+	return body.Response.Beer, nil
+}
+
+// brewery of a /brewery/info response
+func (r *RawResponse) Brewery() (*BreweryInfo, error) {
+	var body struct {
+		Response struct {
+			Brewery *BreweryInfo `json:"brewery"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if body.Response.Brewery == nil {
+		return nil, fmt.Errorf("no brewery found in response")
+	}
+	return body.Response.Brewery, nil
+}
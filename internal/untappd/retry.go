@@ -0,0 +1,137 @@
+package untappd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// first backoff delay, doubled on each attempt
+const baseRetryDelay = time.Second
+
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxWait    time.Duration
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// exponential backoff with jitter, in [d/2, d) where d doubles each attempt
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseDelay << attempt
+	if p.maxWait > 0 && (d > p.maxWait || d <= 0) {
+		d = p.maxWait
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// parses the Retry-After header (seconds or HTTP date), falling back to
+// X-Ratelimit-Reset which is either a delay in seconds or a unix timestamp.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(max(secs, 0)) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0)
+		}
+	}
+
+	if v := h.Get("X-Ratelimit-Reset"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			// anything past 2001 is a timestamp rather than a delay
+			if n > 1_000_000_000 {
+				return max(time.Unix(n, 0).Sub(now), 0)
+			}
+			return time.Duration(max(n, 0)) * time.Second
+		}
+	}
+
+	return 0
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// sends the request, retrying server errors and timeouts with backoff. Rate
+// limited requests are retried after the delay requested by the API when it
+// fits in the maximum wait, otherwise a *RateLimitError is returned. Any
+// other failed call is returned as an *APIError.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	p := c.retry
+	if p.sleep == nil {
+		p.sleep = sleep
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || !isTimeout(err) || attempt >= p.maxRetries {
+				return nil, err
+			}
+			wait := p.backoff(attempt)
+			log.Printf("untappd API request timed out, retrying in %s: %v", wait, err)
+			if err := p.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode < http.StatusMultipleChoices {
+			return resp, nil
+		}
+
+		apiErr := newAPIError(resp)
+		resp.Body.Close()
+
+		var wait time.Duration
+		switch {
+		case errors.Is(apiErr, ErrRateLimited):
+			wait = retryAfter(resp.Header, time.Now())
+			if attempt >= p.maxRetries || wait == 0 || wait > p.maxWait {
+				return nil, &RateLimitError{RetryAfter: wait, Err: apiErr}
+			}
+			log.Printf("untappd API rate limited, retrying in %s", wait)
+
+		case apiErr.Temporary():
+			if attempt >= p.maxRetries {
+				return nil, apiErr
+			}
+			wait = p.backoff(attempt)
+			if ra := retryAfter(resp.Header, time.Now()); ra > 0 && ra <= p.maxWait {
+				wait = ra
+			}
+			log.Printf("%v, retrying in %s", apiErr, wait)
+
+		default:
+			return nil, apiErr
+		}
+
+		if err := p.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package untappd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/config"
+)
+
+func newRetryTestClient(
+	responses []*http.Response,
+	slept *[]time.Duration,
+) (*Client, *int) {
+	calls := 0
+	c := newTestClient(
+		&config.Config{UntappdAccessToken: "test-token"},
+		&http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				resp := responses[calls]
+				calls++
+				return resp, nil
+			}),
+		},
+	)
+	c.retry = retryPolicy{
+		maxRetries: 3,
+		baseDelay:  time.Second,
+		maxWait:    time.Minute,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			*slept = append(*slept, d)
+			return nil
+		},
+	}
+	return c, &calls
+}
+
+func response(status int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestDo_RetriesServerErrors(t *testing.T) {
+	var slept []time.Duration
+	client, calls := newRetryTestClient([]*http.Response{
+		response(http.StatusBadGateway, nil, ""),
+		response(http.StatusServiceUnavailable, http.Header{"Retry-After": []string{"5"}}, ""),
+		response(http.StatusOK, nil, ""),
+	}, &slept)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.untappd.com/v4/user/checkins", nil)
+	resp, err := client.do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 calls, got %d", *calls)
+	}
+	if len(slept) != 2 || slept[0] < 500*time.Millisecond || slept[0] >= time.Second {
+		t.Errorf("expected a jittered backoff first, got %v", slept)
+	}
+	if slept[1] != 5*time.Second {
+		t.Errorf("expected Retry-After to be honored, got %v", slept[1])
+	}
+}
+
+func TestDo_GivesUpOnServerErrors(t *testing.T) {
+	var slept []time.Duration
+	client, calls := newRetryTestClient([]*http.Response{
+		response(http.StatusInternalServerError, nil, ""),
+		response(http.StatusInternalServerError, nil, ""),
+		response(http.StatusInternalServerError, nil, ""),
+		response(http.StatusInternalServerError, nil, ""),
+	}, &slept)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.untappd.com/v4/user/checkins", nil)
+	_, err := client.do(req)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected an *APIError with status 500, got %v", err)
+	}
+	if *calls != 4 {
+		t.Errorf("expected 4 calls, got %d", *calls)
+	}
+}
+
+func TestDo_RateLimited(t *testing.T) {
+	t.Run("should wait when the reset is within the maximum wait", func(t *testing.T) {
+		var slept []time.Duration
+		client, _ := newRetryTestClient([]*http.Response{
+			response(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"30"}}, ""),
+			response(http.StatusOK, nil, ""),
+		}, &slept)
+
+		req, _ := http.NewRequest(http.MethodGet, "https://api.untappd.com/v4/user/checkins", nil)
+		if _, err := client.do(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(slept) != 1 || slept[0] != 30*time.Second {
+			t.Errorf("expected to wait 30s, got %v", slept)
+		}
+	})
+
+	t.Run("should return a typed error when the reset is too far", func(t *testing.T) {
+		var slept []time.Duration
+		client, _ := newRetryTestClient([]*http.Response{
+			response(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"3600"}}, ""),
+		}, &slept)
+
+		req, _ := http.NewRequest(http.MethodGet, "https://api.untappd.com/v4/user/checkins", nil)
+		_, err := client.do(req)
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("expected ErrRateLimited, got %v", err)
+		}
+
+		var rlErr *RateLimitError
+		if !errors.As(err, &rlErr) || rlErr.RetryAfter != time.Hour {
+			t.Errorf("expected RetryAfter to be 1h, got %v", err)
+		}
+		if len(slept) != 0 {
+			t.Errorf("expected no wait, got %v", slept)
+		}
+	})
+}
+
+func TestFetchCheckins_RateLimitAfterPage(t *testing.T) {
+	var slept []time.Duration
+	client, calls := newRetryTestClient([]*http.Response{
+		response(
+			http.StatusOK,
+			http.Header{"X-Ratelimit-Remaining": []string{"0"}},
+			`{"response":{"pagination":{"since_url":"https://api.untappd.com/v4/user/checkins?min_id=2"},
+			"checkins":{"items":[{"checkin_id":2},{"checkin_id":1}]}}}`,
+		),
+	}, &slept)
+
+	var processed int
+	err := client.FetchCheckins(
+		context.Background(),
+		0,
+		func(ctx context.Context, checkins []Checkin) error {
+			processed += len(checkins)
+			return nil
+		},
+	)
+
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if processed != 2 {
+		t.Errorf("expected the last page to be processed, got %d checkins", processed)
+	}
+	if *calls != 1 {
+		t.Errorf("expected no call past the rate limit, got %d", *calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": []string{"120"}}, 2 * time.Minute},
+		{
+			"http date",
+			http.Header{"Retry-After": []string{"Sat, 01 Nov 2025 12:10:00 GMT"}},
+			10 * time.Minute,
+		},
+		{"reset delay", http.Header{"X-Ratelimit-Reset": []string{"60"}}, time.Minute},
+		{
+			"reset timestamp",
+			http.Header{"X-Ratelimit-Reset": []string{"1762000200"}},
+			time.Unix(1762000200, 0).Sub(now),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header, now); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -23,17 +23,105 @@ type Checkins struct {
 
 type Pagination struct {
 	SinceURL string `json:"since_url"`
+	NextURL  string `json:"next_url"`
 }
 
 type Checkin struct {
-	CheckinID      uint64  `json:"checkin_id"`
-	CheckinComment string  `json:"checkin_comment"`
-	RatingScore    float64 `json:"rating_score"`
-	CreatedAt      string  `json:"created_at"`
-	Media          Media   `json:"media"`
-	Beer           Beer    `json:"beer"`
-	Brewery        Brewery `json:"brewery"`
-	Venue          *Venue  `json:"venue"`
+	CheckinID      uint64        `json:"checkin_id"`
+	CheckinComment string        `json:"checkin_comment"`
+	RatingScore    float64       `json:"rating_score"`
+	CreatedAt      string        `json:"created_at"`
+	Media          Media         `json:"media"`
+	Beer           Beer          `json:"beer"`
+	Brewery        Brewery       `json:"brewery"`
+	Venue          *Venue        `json:"venue"`
+	User           User          `json:"user"`
+	Toasts         Toasts        `json:"toasts"`
+	Comments       Comments      `json:"comments"`
+	TaggedFriends  TaggedFriends `json:"tagged_friends"`
+	Badges         Badges        `json:"badges"`
+}
+
+type User struct {
+	UserName  string `json:"user_name"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// the toasts of a checkin. The API may only return the latest items, with
+// the full count in total_count.
+type Toasts struct {
+	Count      int     `json:"count"`
+	TotalCount int     `json:"total_count"`
+	Items      []Toast `json:"items"`
+}
+
+type Toast struct {
+	User      User   `json:"user"`
+	CreatedAt string `json:"created_at"`
+}
+
+// the comments of a checkin, which may also be truncated like the toasts
+type Comments struct {
+	Count      int       `json:"count"`
+	TotalCount int       `json:"total_count"`
+	Items      []Comment `json:"items"`
+}
+
+type Comment struct {
+	CommentID uint64 `json:"comment_id"`
+	User      User   `json:"user"`
+	Comment   string `json:"comment"`
+	CreatedAt string `json:"created_at"`
+}
+
+type TaggedFriends struct {
+	Count int            `json:"count"`
+	Items []TaggedFriend `json:"items"`
+}
+
+type TaggedFriend struct {
+	User User `json:"user"`
+}
+
+func (t *Toasts) Total() int {
+	return max(t.Count, t.TotalCount, len(t.Items))
+}
+
+func (c *Comments) Total() int {
+	return max(c.Count, c.TotalCount, len(c.Items))
+}
+
+type Badges struct {
+	Items []Badge `json:"items"`
+}
+
+// a badge earned with a checkin
+type Badge struct {
+	BadgeID          uint64     `json:"badge_id"`
+	UserBadgeID      uint64     `json:"user_badge_id"`
+	BadgeName        string     `json:"badge_name"`
+	BadgeDescription string     `json:"badge_description"`
+	CreatedAt        string     `json:"created_at"`
+	BadgeImage       BadgeImage `json:"badge_image"`
+}
+
+type BadgeImage struct {
+	Sm string `json:"sm"`
+	Md string `json:"md"`
+	Lg string `json:"lg"`
+}
+
+// largest image of the badge, empty when it has none
+func (b *Badge) ImageURL() string {
+	switch {
+	case b.BadgeImage.Lg != "":
+		return b.BadgeImage.Lg
+	case b.BadgeImage.Md != "":
+		return b.BadgeImage.Md
+	default:
+		return b.BadgeImage.Sm
+	}
 }
 
 type Media struct {
@@ -49,22 +137,81 @@ type Photo struct {
 }
 
 type Beer struct {
+	BID       uint64  `json:"bid"`
 	BeerName  string  `json:"beer_name"`
 	BeerStyle string  `json:"beer_style"`
 	BeerABV   float64 `json:"beer_abv"`
+	BeerIBU   float64 `json:"beer_ibu"`
+}
+
+// beer details returned by /beer/info
+type BeerInfo struct {
+	BID             uint64  `json:"bid"`
+	BeerName        string  `json:"beer_name"`
+	BeerStyle       string  `json:"beer_style"`
+	BeerABV         float64 `json:"beer_abv"`
+	BeerIBU         float64 `json:"beer_ibu"`
+	BeerDescription string  `json:"beer_description"`
+	BeerLabel       string  `json:"beer_label"`
+	BeerLabelHD     string  `json:"beer_label_hd"`
+	RatingScore     float64 `json:"rating_score"`
+	RatingCount     int     `json:"rating_count"`
+	Brewery         Brewery `json:"brewery"`
 }
 
 type Brewery struct {
-	BreweryName    string `json:"brewery_name"`
-	BreweryCountry string `json:"country_name"`
+	BreweryID      uint64          `json:"brewery_id"`
+	BreweryName    string          `json:"brewery_name"`
+	BreweryCountry string          `json:"country_name"`
+	Location       BreweryLocation `json:"location"`
+	Contact        BreweryContact  `json:"contact"`
+}
+
+// brewery details returned by /brewery/info
+type BreweryInfo struct {
+	BreweryID          uint64          `json:"brewery_id"`
+	BreweryName        string          `json:"brewery_name"`
+	BreweryType        string          `json:"brewery_type"`
+	BreweryLabel       string          `json:"brewery_label"`
+	BreweryDescription string          `json:"brewery_description"`
+	CountryName        string          `json:"country_name"`
+	Location           BreweryLocation `json:"location"`
+	Contact            BreweryContact  `json:"contact"`
+}
+
+type BreweryLocation struct {
+	BreweryCity  string `json:"brewery_city"`
+	BreweryState string `json:"brewery_state"`
+}
+
+type BreweryContact struct {
+	URL string `json:"url"`
 }
 
 type Venue struct {
-	VenueName string   `json:"venue_name"`
-	Location  Location `json:"location"`
+	VenueID         uint64          `json:"venue_id"`
+	VenueName       string          `json:"venue_name"`
+	PrimaryCategory string          `json:"primary_category"`
+	Categories      VenueCategories `json:"categories"`
+	Location        Location        `json:"location"`
+	Foursquare      Foursquare      `json:"foursquare"`
+}
+
+type VenueCategories struct {
+	Items []VenueCategory `json:"items"`
+}
+
+type VenueCategory struct {
+	CategoryName string `json:"category_name"`
+	IsPrimary    bool   `json:"is_primary"`
+}
+
+type Foursquare struct {
+	FoursquareID string `json:"foursquare_id"`
 }
 
 type Location struct {
+	Address string  `json:"venue_address"`
 	Lat     float64 `json:"lat"`
 	Lng     float64 `json:"lng"`
 	City    string  `json:"venue_city"`
@@ -72,6 +219,14 @@ type Location struct {
 	Country string  `json:"venue_country"`
 }
 
+// public page of the checkin, empty when the owner is unknown
+func (c *Checkin) URL() string {
+	if c.User.UserName == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://untappd.com/user/%s/checkin/%d", c.User.UserName, c.CheckinID)
+}
+
 func (v *Venue) Name() string {
 	if v == nil {
 		return ""
@@ -0,0 +1,469 @@
+// Package untappdtest provides an in-process fake of the Untappd API, to run
+// the recorder end-to-end without network access.
+package untappdtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallwat3r/untappd-recorder/internal/untappd"
+)
+
+const (
+	// page size when the request has no limit, and the largest one allowed
+	defaultLimit = 25
+	maxLimit     = 50
+
+	// seconds advertised in Retry-After once the rate limit is exhausted
+	rateLimitRetryAfter = 3600
+)
+
+// a scripted failure, served instead of the next API call
+type Failure struct {
+	StatusCode int
+	Meta       untappd.Meta
+	Header     http.Header
+}
+
+// serves a scripted checkin history under /v4/user/checkins, beer and
+// brewery details under /v4/beer/info and /v4/brewery/info, and the photos
+// under /photos/. Safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	// expected access_token, any token is accepted when empty
+	Token string
+
+	mu        sync.Mutex
+	checkins  []untappd.Checkin
+	beers     map[uint64]untappd.BeerInfo
+	breweries map[uint64]untappd.BreweryInfo
+	photos    map[string][]byte
+	failures  []Failure
+	limit     int
+	remaining int
+	requests  []Request
+}
+
+// an API call received by the fake
+type Request struct {
+	Path string
+	// query string, without the access token
+	Query url.Values
+}
+
+func NewServer() *Server {
+	s := &Server{
+		beers:     make(map[uint64]untappd.BeerInfo),
+		breweries: make(map[uint64]untappd.BreweryInfo),
+		photos:    make(map[string][]byte),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v4/user/checkins", s.api(s.handleCheckins))
+	mux.HandleFunc("GET /v4/beer/info/{bid}", s.api(s.handleBeer))
+	mux.HandleFunc("GET /v4/brewery/info/{id}", s.api(s.handleBrewery))
+	mux.HandleFunc("/photos/", s.handlePhoto)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// value for UNTAPPD_BASE_URL
+func (s *Server) BaseURL() string {
+	return s.URL + "/v4"
+}
+
+// URL of a photo served by the fake, a small JPEG
+func (s *Server) PhotoURL(name string) string {
+	return s.URL + "/photos/" + name
+}
+
+// adds checkins to the history, in any order
+func (s *Server) AddCheckins(checkins ...untappd.Checkin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkins = append(s.checkins, checkins...)
+	// newest first, as returned by the API
+	slices.SortFunc(s.checkins, func(a, b untappd.Checkin) int {
+		switch {
+		case a.CheckinID > b.CheckinID:
+			return -1
+		case a.CheckinID < b.CheckinID:
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+// builds a checkin with a beer, a brewery, a venue and one photo served by
+// the fake
+func (s *Server) NewCheckin(id uint64, createdAt time.Time) untappd.Checkin {
+	c := untappd.Checkin{
+		CheckinID:      id,
+		CheckinComment: fmt.Sprintf("Checkin %d", id),
+		RatingScore:    4,
+		CreatedAt:      createdAt.Format(time.RFC1123Z),
+		Beer: untappd.Beer{
+			BID:       id * 10,
+			BeerName:  fmt.Sprintf("Beer %d", id),
+			BeerStyle: "IPA - American",
+			BeerABV:   6.5,
+			BeerIBU:   60,
+		},
+		Brewery: untappd.Brewery{
+			BreweryID:      id * 100,
+			BreweryName:    "Test Brewery",
+			BreweryCountry: "England",
+		},
+		Venue: &untappd.Venue{
+			VenueID:         id * 1000,
+			VenueName:       "Test Pub",
+			PrimaryCategory: "Nightlife Spot",
+			Categories: untappd.VenueCategories{Items: []untappd.VenueCategory{
+				{CategoryName: "Pub", IsPrimary: true},
+			}},
+			Location: untappd.Location{
+				Address: "1 Test Street",
+				Lat:     51.5,
+				Lng:     -0.12,
+				City:    "London",
+				Country: "England",
+			},
+		},
+		User: untappd.User{UserName: "tester"},
+	}
+	c.Media.Items = []untappd.MediaItem{
+		{Photo: untappd.Photo{PhotoImgOg: s.PhotoURL(fmt.Sprintf("%d.jpg", id))}},
+	}
+	return c
+}
+
+// serves custom bytes for a photo, e.g. to test orientation handling
+func (s *Server) SetPhoto(name string, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.photos[name] = b
+}
+
+// limits the number of API calls, advertised with the X-Ratelimit-* headers.
+// Calls past the limit get a 429 with an invalid_limit meta.
+func (s *Server) SetRateLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	s.remaining = limit
+}
+
+// queues failures served, in order, instead of the next API calls
+func (s *Server) Fail(failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failures...)
+}
+
+// sets the details served by /beer/info for a beer. Beers which are not set
+// are derived from the checkins they appear in.
+func (s *Server) SetBeer(beer untappd.BeerInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beers[beer.BID] = beer
+}
+
+// sets the details served by /brewery/info for a brewery. Breweries which
+// are not set are derived from the checkins they appear in.
+func (s *Server) SetBrewery(brewery untappd.BreweryInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breweries[brewery.BreweryID] = brewery
+}
+
+// API calls received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+type response struct {
+	Meta     untappd.Meta `json:"meta"`
+	Response any          `json:"response"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, meta untappd.Meta) {
+	if meta.Code == 0 {
+		meta.Code = status
+	}
+	writeJSON(w, status, response{Meta: meta, Response: []any{}})
+}
+
+// wraps an API method with the behaviour shared by all of them: scripted
+// failures, rate limiting and token checks. Handlers run with the lock held.
+func (s *Server) api(h func(w http.ResponseWriter, r *http.Request, q url.Values)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		q := r.URL.Query()
+		token := q.Get("access_token")
+		q.Del("access_token")
+		s.requests = append(s.requests, Request{Path: r.URL.Path, Query: q})
+
+		if len(s.failures) > 0 {
+			f := s.failures[0]
+			s.failures = s.failures[1:]
+			for k, vs := range f.Header {
+				w.Header()[k] = vs
+			}
+			writeError(w, f.StatusCode, f.Meta)
+			return
+		}
+
+		if s.limit > 0 {
+			if s.remaining == 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(rateLimitRetryAfter))
+				writeError(w, http.StatusTooManyRequests, untappd.Meta{
+					ErrorType:   "invalid_limit",
+					ErrorDetail: "You have exceeded the API limit",
+				})
+				return
+			}
+			s.remaining--
+			w.Header().Set("X-Ratelimit-Limit", strconv.Itoa(s.limit))
+			w.Header().Set("X-Ratelimit-Remaining", strconv.Itoa(s.remaining))
+		}
+
+		// Untappd reports an invalid token with a 500
+		if s.Token != "" && token != s.Token {
+			writeError(w, http.StatusInternalServerError, untappd.Meta{
+				ErrorType:   "invalid_auth",
+				ErrorDetail: "Invalid access token",
+			})
+			return
+		}
+
+		h(w, r, q)
+	}
+}
+
+func (s *Server) handleCheckins(w http.ResponseWriter, r *http.Request, q url.Values) {
+	limit, err := parseParam(q, "limit", defaultLimit)
+	if err != nil || limit < 1 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: fmt.Sprintf("Invalid limit %q", q.Get("limit")),
+		})
+		return
+	}
+	minID, err1 := parseParam(q, "min_id", 0)
+	maxID, err2 := parseParam(q, "max_id", 0)
+	if err1 != nil || err2 != nil {
+		writeError(w, http.StatusBadRequest, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: "Invalid min_id or max_id",
+		})
+		return
+	}
+
+	endpoint := s.BaseURL() + "/user/checkins"
+
+	// the newest checkins past min_id, or up to max_id (exclusive) walking
+	// backwards
+	var page []untappd.Checkin
+	more := false
+	for _, c := range s.checkins {
+		if minID != 0 && c.CheckinID <= uint64(minID) {
+			break
+		}
+		if maxID != 0 && c.CheckinID >= uint64(maxID) {
+			continue
+		}
+		if len(page) == limit {
+			more = true
+			break
+		}
+		page = append(page, c)
+	}
+	if page == nil {
+		page = []untappd.Checkin{}
+	}
+
+	pagination := map[string]string{"since_url": "", "next_url": ""}
+	if len(page) > 0 {
+		pagination["since_url"] = fmt.Sprintf("%s?min_id=%d", endpoint, page[0].CheckinID)
+		if more {
+			pagination["next_url"] = fmt.Sprintf("%s?max_id=%d", endpoint, page[len(page)-1].CheckinID)
+		}
+	} else if minID != 0 {
+		pagination["since_url"] = fmt.Sprintf("%s?min_id=%d", endpoint, minID)
+	}
+
+	body := map[string]any{"pagination": pagination}
+	// with min_id, the API returns the items without the checkins wrapper
+	if minID != 0 {
+		body["items"] = page
+	} else {
+		body["checkins"] = map[string]any{"count": len(page), "items": page}
+	}
+
+	writeJSON(w, http.StatusOK, response{Meta: untappd.Meta{Code: http.StatusOK}, Response: body})
+}
+
+func (s *Server) handleBeer(w http.ResponseWriter, r *http.Request, q url.Values) {
+	bid, err := strconv.ParseUint(r.PathValue("bid"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: fmt.Sprintf("Invalid BID %q", r.PathValue("bid")),
+		})
+		return
+	}
+
+	beer, ok := s.beers[bid]
+	if !ok {
+		beer, ok = s.beerFromCheckins(bid)
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: "This beer does not exist.",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response{
+		Meta:     untappd.Meta{Code: http.StatusOK},
+		Response: map[string]any{"beer": beer},
+	})
+}
+
+func (s *Server) beerFromCheckins(bid uint64) (untappd.BeerInfo, bool) {
+	for _, c := range s.checkins {
+		if c.Beer.BID != bid {
+			continue
+		}
+		return untappd.BeerInfo{
+			BID:             bid,
+			BeerName:        c.Beer.BeerName,
+			BeerStyle:       c.Beer.BeerStyle,
+			BeerABV:         c.Beer.BeerABV,
+			BeerIBU:         c.Beer.BeerIBU,
+			BeerDescription: fmt.Sprintf("Description of %s", c.Beer.BeerName),
+			BeerLabel:       s.PhotoURL(fmt.Sprintf("labels/%d.jpeg", bid)),
+			BeerLabelHD:     s.PhotoURL(fmt.Sprintf("labels/%d-hd.jpeg", bid)),
+			RatingScore:     3.8,
+			RatingCount:     1200,
+			Brewery:         c.Brewery,
+		}, true
+	}
+	return untappd.BeerInfo{}, false
+}
+
+func (s *Server) handleBrewery(w http.ResponseWriter, r *http.Request, q url.Values) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: fmt.Sprintf("Invalid brewery ID %q", r.PathValue("id")),
+		})
+		return
+	}
+
+	brewery, ok := s.breweries[id]
+	if !ok {
+		brewery, ok = s.breweryFromCheckins(id)
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, untappd.Meta{
+			ErrorType:   "invalid_param",
+			ErrorDetail: "This brewery does not exist.",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response{
+		Meta:     untappd.Meta{Code: http.StatusOK},
+		Response: map[string]any{"brewery": brewery},
+	})
+}
+
+func (s *Server) breweryFromCheckins(id uint64) (untappd.BreweryInfo, bool) {
+	for _, c := range s.checkins {
+		if c.Brewery.BreweryID != id {
+			continue
+		}
+		return untappd.BreweryInfo{
+			BreweryID:          id,
+			BreweryName:        c.Brewery.BreweryName,
+			BreweryType:        "Micro Brewery",
+			BreweryLabel:       s.PhotoURL(fmt.Sprintf("breweries/%d.jpeg", id)),
+			BreweryDescription: fmt.Sprintf("Description of %s", c.Brewery.BreweryName),
+			CountryName:        c.Brewery.BreweryCountry,
+			Location: untappd.BreweryLocation{
+				BreweryCity:  "London",
+				BreweryState: "Greater London",
+			},
+			Contact: untappd.BreweryContact{URL: "https://brewery.example.com"},
+		}, true
+	}
+	return untappd.BreweryInfo{}, false
+}
+
+func parseParam(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/photos/")
+
+	s.mu.Lock()
+	b, ok := s.photos[name]
+	s.mu.Unlock()
+
+	if !ok {
+		b = JPEG(color.RGBA{R: 0xf2, G: 0xb1, B: 0x2d, A: 0xff})
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	_, _ = w.Write(b)
+}
+
+// encodes a small plain JPEG of the given colour
+func JPEG(c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
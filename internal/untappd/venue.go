@@ -0,0 +1,108 @@
+package untappd
+
+import "strings"
+
+// normalized kinds of venue, derived from the Foursquare categories the API
+// returns for a venue
+const (
+	VenueTypeHome       = "home"
+	VenueTypeBrewery    = "brewery"
+	VenueTypeBar        = "bar"
+	VenueTypeRestaurant = "restaurant"
+	VenueTypeStore      = "store"
+	VenueTypeEvent      = "event"
+	VenueTypeOther      = "other"
+)
+
+// words matched against the category names, in order so a brewpub is a
+// brewery rather than a bar, and a coffee shop is not a store
+var venueTypeKeywords = []struct {
+	venueType string
+	keywords  []string
+}{
+	{VenueTypeHome, []string{"home", "residence", "residential", "apartment", "condo"}},
+	{VenueTypeBrewery, []string{
+		"brewery", "microbrewery", "brewpub", "taproom", "meadery", "cidery", "winery", "distillery",
+	}},
+	{VenueTypeBar, []string{"bar", "pub", "gastropub", "nightlife", "beer garden", "lounge", "speakeasy"}},
+	{VenueTypeRestaurant, []string{"restaurant", "food", "café", "cafe", "coffee", "diner", "pizza", "bistro"}},
+	{VenueTypeStore, []string{"store", "shop", "market", "supermarket"}},
+	{VenueTypeEvent, []string{"festival", "event", "stadium", "arena", "concert"}},
+}
+
+// classifies a venue from its name and category names, the primary category
+// first. Empty when there is nothing to go by, e.g. for CSV exports.
+func ClassifyVenue(name string, categories ...string) string {
+	if name == VenueUntappdAtHome {
+		return VenueTypeHome
+	}
+
+	known := false
+	for _, c := range categories {
+		c = strings.ToLower(c)
+		if c == "" {
+			continue
+		}
+		known = true
+		for _, t := range venueTypeKeywords {
+			for _, k := range t.keywords {
+				if containsWord(c, k) {
+					return t.venueType
+				}
+			}
+		}
+	}
+
+	if !known {
+		return ""
+	}
+	return VenueTypeOther
+}
+
+// reports whether s contains the word, so a barbecue joint is not a bar
+func containsWord(s, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		if (start == 0 || !isLetter(s[start-1])) && (end == len(s) || !isLetter(s[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 0x80
+}
+
+// category names of the venue, the primary ones first
+func (v *Venue) CategoryNames() []string {
+	if v == nil {
+		return nil
+	}
+
+	var primary, others []string
+	for _, c := range v.Categories.Items {
+		if c.IsPrimary {
+			primary = append(primary, c.CategoryName)
+		} else {
+			others = append(others, c.CategoryName)
+		}
+	}
+	names := append(primary, others...)
+	if len(names) == 0 && v.PrimaryCategory != "" {
+		names = []string{v.PrimaryCategory}
+	}
+	return names
+}
+
+// normalized kind of the venue, see ClassifyVenue
+func (v *Venue) Type() string {
+	if v == nil {
+		return ""
+	}
+	return ClassifyVenue(v.VenueName, v.CategoryNames()...)
+}
@@ -0,0 +1,49 @@
+package untappd
+
+import "testing"
+
+func TestClassifyVenue(t *testing.T) {
+	tests := []struct {
+		name       string
+		venue      string
+		categories []string
+		want       string
+	}{
+		{"untappd at home", VenueUntappdAtHome, nil, VenueTypeHome},
+		{"private home", "Sam's place", []string{"Home (private)"}, VenueTypeHome},
+		{"taproom", "Cloudwater Tap Room", []string{"Brewery", "Beer Bar"}, VenueTypeBrewery},
+		{"brewpub", "The Brewpub", []string{"Brewpub"}, VenueTypeBrewery},
+		{"pub", "The Rake", []string{"Pub"}, VenueTypeBar},
+		{"nightlife", "Somewhere", []string{"Nightlife Spot"}, VenueTypeBar},
+		{"barbecue is not a bar", "Smokehouse", []string{"BBQ Joint", "Barbecue Restaurant"}, VenueTypeRestaurant},
+		{"coffee shop is not a store", "Flat White", []string{"Coffee Shop"}, VenueTypeRestaurant},
+		{"bottle shop", "Hop Burns & Black", []string{"Liquor Store"}, VenueTypeStore},
+		{"festival", "GBBF", []string{"Music Festival"}, VenueTypeEvent},
+		{"primary category first", "Hotel", []string{"Hotel Bar", "Restaurant"}, VenueTypeBar},
+		{"unknown category", "Airport", []string{"Airport"}, VenueTypeOther},
+		{"no category", "Test Venue", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyVenue(tt.venue, tt.categories...); got != tt.want {
+				t.Errorf("ClassifyVenue(%q, %q) = %q, want %q", tt.venue, tt.categories, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVenue_CategoryNames(t *testing.T) {
+	v := &Venue{Categories: VenueCategories{Items: []VenueCategory{
+		{CategoryName: "Restaurant"},
+		{CategoryName: "Brewery", IsPrimary: true},
+	}}}
+	if got := v.CategoryNames(); len(got) != 2 || got[0] != "Brewery" {
+		t.Errorf("expected the primary category first, got %q", got)
+	}
+
+	v = &Venue{PrimaryCategory: "Nightlife Spot"}
+	if got := v.Type(); got != VenueTypeBar {
+		t.Errorf("expected the primary category to be used, got %q", got)
+	}
+}